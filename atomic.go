@@ -0,0 +1,131 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/util"
+	"bytes"
+	"math"
+	"strconv"
+)
+
+// CompareAndSwap 当key当前的值等于old时，把值修改为new，返回是否修改成功
+// old为nil表示期望key不存在，new为nil表示删除key；修改后key的过期时间会被清除
+func (db *DB) CompareAndSwap(key, old, new []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, util.ErrKeyIsEmpty
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	current, err := db.GetValueByPosition(db.index.Get(key))
+	if err != nil && err != util.ErrKeyNotFound {
+		return false, err
+	}
+
+	//区分不存在的key和值为空的key
+	exists := err == nil
+	if exists != (old != nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+
+	if new == nil {
+		err = db.deleteLocked(key)
+	} else {
+		err = db.putLocked(key, new, 0)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent 只有key不存在(或已经过期)时才写入，返回是否写入成功
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, util.ErrKeyIsEmpty
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if pos := db.index.Get(key); pos != nil && !pos.IsExpired() {
+		return false, nil
+	}
+	if err := db.putLocked(key, value, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Update 在写锁内读取key当前的值并调用fn，将fn的返回值写回DB
+// key不存在时fn的参数为nil；fn返回nil表示删除key，返回错误则放弃修改并将错误返回
+// fn执行期间会阻塞其他所有读写，不能在fn中再调用DB的方法；key原有的过期时间会被保留
+func (db *DB) Update(key []byte, fn func(old []byte) ([]byte, error)) error {
+	if len(key) == 0 {
+		return util.ErrKeyIsEmpty
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return db.updateLocked(key, fn)
+}
+
+// Incr 将key的值当作十进制整数加上delta，返回修改后的值
+// key不存在时视为0；值不是合法整数或者结果溢出时返回 util.ErrValueNotInteger
+func (db *DB) Incr(key []byte, delta int64) (int64, error) {
+	if len(key) == 0 {
+		return 0, util.ErrKeyIsEmpty
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var result int64
+	err := db.updateLocked(key, func(old []byte) ([]byte, error) {
+		var n int64
+		if old != nil {
+			var err error
+			if n, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+				return nil, util.ErrValueNotInteger
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, util.ErrValueNotInteger
+		}
+		result = n + delta
+		return []byte(strconv.FormatInt(result, 10)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// 读取-修改-写回，调用方需要持有写锁
+func (db *DB) updateLocked(key []byte, fn func(old []byte) ([]byte, error)) error {
+	var old []byte
+	var expire int64
+	if pos := db.index.Get(key); pos != nil && !pos.IsExpired() {
+		value, err := db.GetValueByPosition(pos)
+		if err != nil && err != util.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			//值为空的key也要和不存在的key区分开
+			if value == nil {
+				value = []byte{}
+			}
+			old, expire = value, pos.Expire
+		}
+	}
+
+	value, err := fn(old)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return db.deleteLocked(key)
+	}
+	return db.putLocked(key, value, expire)
+}
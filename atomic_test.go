@@ -0,0 +1,184 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := util.GetTestKey(1)
+
+	//期望key不存在
+	ok, err := db.CompareAndSwap(key, nil, []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.CompareAndSwap(key, nil, []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	//旧值不匹配
+	ok, err = db.CompareAndSwap(key, []byte("v2"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.CompareAndSwap(key, []byte("v1"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+
+	//new为nil表示删除
+	ok, err = db.CompareAndSwap(key, []byte("v3"), nil)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(key)
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	//空值和不存在是不同的
+	err = db.Put(key, []byte{})
+	assert.Nil(t, err)
+	ok, err = db.CompareAndSwap(key, nil, []byte("v4"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap(key, []byte{}, []byte("v4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, err = db.CompareAndSwap(nil, nil, []byte("v"))
+	assert.Equal(t, util.ErrKeyIsEmpty, err)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	ok, err := db.PutIfAbsent(util.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(util.GetTestKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	//已经过期的key视为不存在
+	err = db.PutWithTTL(util.GetTestKey(2), []byte("v1"), 10*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	ok, err = db.PutIfAbsent(util.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestDB_Update(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-update")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := util.GetTestKey(1)
+	err = db.Update(key, func(old []byte) ([]byte, error) {
+		assert.Nil(t, old)
+		return []byte("a"), nil
+	})
+	assert.Nil(t, err)
+
+	err = db.Update(key, func(old []byte) ([]byte, error) {
+		return append(old, 'b'), nil
+	})
+	assert.Nil(t, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ab"), val)
+
+	//fn返回错误时不修改
+	errAbort := errors.New("abort")
+	err = db.Update(key, func(old []byte) ([]byte, error) {
+		return []byte("c"), errAbort
+	})
+	assert.Equal(t, errAbort, err)
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ab"), val)
+
+	//保留过期时间
+	err = db.PutWithTTL(key, []byte("x"), time.Hour)
+	assert.Nil(t, err)
+	err = db.Update(key, func(old []byte) ([]byte, error) {
+		return []byte("y"), nil
+	})
+	assert.Nil(t, err)
+	ttl, err := db.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+
+	//返回nil表示删除
+	err = db.Update(key, func(old []byte) ([]byte, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	_, err = db.Get(key)
+	assert.Equal(t, util.ErrKeyNotFound, err)
+}
+
+func TestDB_Incr(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-incr")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.Incr(key, 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := db.Incr(key, -10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(990), n)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("990"), val)
+
+	err = db.Put(key, []byte("not a number"))
+	assert.Nil(t, err)
+	_, err = db.Incr(key, 1)
+	assert.Equal(t, util.ErrValueNotInteger, err)
+
+	err = db.Put(key, []byte("9223372036854775807"))
+	assert.Nil(t, err)
+	_, err = db.Incr(key, 1)
+	assert.Equal(t, util.ErrValueNotInteger, err)
+}
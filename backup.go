@@ -0,0 +1,285 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/fio"
+	"Bitcask_go/util"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const backupManifestFileName = "backup-manifest"
+
+// 除了数据文件之外需要备份的文件
+var backupExtraFileNames = []string{data.HintFileName, data.MergeFinFileName, data.EncryptionCheckFileName}
+
+// RestoreLatest 恢复备份中的全部数据
+const RestoreLatest uint64 = math.MaxUint64
+
+// BackupManifest 备份的描述信息，保存在备份目录中
+type BackupManifest struct {
+	CreatedAt      time.Time    //备份完成的时间
+	SeqNo          uint64       //备份时最新的事务序列号
+	NonMergeFileId uint32       //备份时数据目录中最近一次merge没有处理的第一个文件id
+	Files          []BackupFile //备份的数据文件，按文件id递增排列
+}
+
+// BackupFile 备份中的一个数据文件
+type BackupFile struct {
+	Fid  uint32
+	Size int64 //拷贝的字节数，活跃文件只拷贝到备份时已经写完的位置
+}
+
+// IncrementalBackup 增量备份到dir目录
+// 只在获取数据文件列表和活跃文件的写入位置时短暂持有读锁，拷贝数据时不会阻塞写入；
+// 已经存在于备份目录并且大小一致的旧数据文件不会重复拷贝，merge重写过的文件会重新拷贝
+// 备份只包含数据文件以及merge产生的hint文件，恢复之后需要使用内存索引打开
+func (db *DB) IncrementalBackup(dir string) (*BackupManifest, error) {
+	db.mutex.RLock()
+	manifest := &BackupManifest{SeqNo: db.seqNo}
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.IOManager.Size()
+		if err != nil {
+			db.mutex.RUnlock()
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, BackupFile{Fid: fid, Size: size})
+	}
+	//活跃文件只拷贝到当前的写入位置，之前的数据不会再被修改
+	if db.activeFile != nil {
+		manifest.Files = append(manifest.Files, BackupFile{Fid: db.activeFile.Fid, Size: db.activeFile.WriteOffset})
+	}
+	db.mutex.RUnlock()
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Fid < manifest.Files[j].Fid
+	})
+
+	srcDir := db.configuration.DataDir
+	if _, err := os.Stat(filepath.Join(srcDir, data.MergeFinFileName)); err == nil {
+		nonMergeFileId, err := db.getNonMergeFileId(srcDir)
+		if err != nil {
+			return nil, err
+		}
+		manifest.NonMergeFileId = nonMergeFileId
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	lastManifest, err := ReadBackupManifest(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	//拷贝数据文件
+	backupFids := make(map[uint32]struct{}, len(manifest.Files))
+	for _, file := range manifest.Files {
+		backupFids[file.Fid] = struct{}{}
+		destPath := data.GetDataFileName(dir, file.Fid)
+		if lastManifest != nil && isBackupFileReusable(lastManifest, manifest, file, destPath) {
+			continue
+		}
+		if err := util.CopyFile(data.GetDataFileName(srcDir, file.Fid), destPath, file.Size); err != nil {
+			return nil, err
+		}
+	}
+
+	//删除merge之后已经不存在的数据文件
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileSuffix))
+		if err != nil {
+			continue
+		}
+		if _, ok := backupFids[uint32(fid)]; !ok {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	//merge产生的hint文件、merge完成标记文件以及密钥校验文件
+	for _, fileName := range backupExtraFileNames {
+		if err := copyOptionalFile(filepath.Join(srcDir, fileName), filepath.Join(dir, fileName)); err != nil {
+			return nil, err
+		}
+	}
+
+	//所有文件拷贝完成之后再写入manifest
+	manifest.CreatedAt = time.Now()
+	if err := writeBackupManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// 上次备份的文件在这次备份中是否可以直接复用
+func isBackupFileReusable(last, current *BackupManifest, file BackupFile, destPath string) bool {
+	//发生过新的merge，被重写的文件不能复用
+	if file.Fid < current.NonMergeFileId && last.NonMergeFileId != current.NonMergeFileId {
+		return false
+	}
+	var lastFile *BackupFile
+	for i := range last.Files {
+		if last.Files[i].Fid == file.Fid {
+			lastFile = &last.Files[i]
+			break
+		}
+	}
+	if lastFile == nil || lastFile.Size != file.Size {
+		return false
+	}
+	info, err := os.Stat(destPath)
+	return err == nil && info.Size() == file.Size
+}
+
+// Restore 把备份目录中的数据恢复到一个新的数据目录中，targetDir必须不存在或者为空
+// upToSeqNo指定恢复到的事务序列号，恢复之后的数据就是DB的事务序列号还等于upToSeqNo时的最后状态，
+// 之后提交的WriteBatch或事务以及它们之后的所有写入都会被丢弃；RestoreLatest表示恢复全部数据
+// 最近一次merge重写过的数据文件中已经没有序列号信息，只能整体恢复
+func Restore(backupDir, targetDir string, upToSeqNo uint64) error {
+	return RestoreWithKeyProvider(backupDir, targetDir, upToSeqNo, nil)
+}
+
+// RestoreWithKeyProvider 恢复加密的备份，按序列号恢复时需要使用密钥读取数据文件中的记录
+func RestoreWithKeyProvider(backupDir, targetDir string, upToSeqNo uint64, provider config.KeyProvider) error {
+	manifest, err := ReadBackupManifest(backupDir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(targetDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return util.ErrRestoreDirNotEmpty
+	}
+	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	//找到第一条序列号大于upToSeqNo的记录，从这里开始的数据都不需要恢复
+	stopFid, stopOffset, found := uint32(0), int64(0), false
+	if upToSeqNo < manifest.SeqNo {
+		stopFid, stopOffset, found, err = locateBackupSeqNo(backupDir, manifest, upToSeqNo, data.NewCipher(provider))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, file := range manifest.Files {
+		size := file.Size
+		if found {
+			if file.Fid > stopFid {
+				break
+			}
+			if file.Fid == stopFid {
+				size = stopOffset
+			}
+		}
+		srcPath := data.GetDataFileName(backupDir, file.Fid)
+		if err := util.CopyFile(srcPath, data.GetDataFileName(targetDir, file.Fid), size); err != nil {
+			return err
+		}
+	}
+
+	for _, fileName := range backupExtraFileNames {
+		if err := copyOptionalFile(filepath.Join(backupDir, fileName), filepath.Join(targetDir, fileName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在备份的数据文件中查找第一条序列号大于upToSeqNo的记录所在的位置
+func locateBackupSeqNo(backupDir string, manifest *BackupManifest, upToSeqNo uint64, cipher *data.Cipher) (uint32, int64, bool, error) {
+	for _, file := range manifest.Files {
+		//merge重写过的文件中只有非事务的记录
+		if file.Fid < manifest.NonMergeFileId {
+			continue
+		}
+
+		dataFile, err := data.OpenDataFile(backupDir, file.Fid, fio.StandardFIO)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		dataFile.Cipher = cipher
+
+		var offset int64
+		for offset < file.Size {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				_ = dataFile.Close()
+				return 0, 0, false, err
+			}
+			if _, seqNo := parseLogRecordKeyWithSeq(logRecord.Key); seqNo > upToSeqNo {
+				_ = dataFile.Close()
+				return file.Fid, offset, true, nil
+			}
+			offset += size
+		}
+		if err := dataFile.Close(); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// ReadBackupManifest 读取备份目录中的manifest
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, backupManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(buf, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeBackupManifest(dir string, manifest *BackupManifest) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpName := filepath.Join(dir, backupManifestFileName+".tmp")
+	if err := os.WriteFile(tmpName, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dir, backupManifestFileName))
+}
+
+// 源文件存在则完整拷贝，不存在则删除目标文件
+func copyOptionalFile(src, dest string) error {
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return util.CopyFile(src, dest, info.Size())
+}
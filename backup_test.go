@@ -0,0 +1,172 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_IncrementalBackup(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-incremental-backup")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(128)))
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-incremental-backup-dest")
+	defer os.RemoveAll(backupDir)
+	manifest, err := db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+	assert.True(t, len(manifest.Files) > 2)
+
+	//记录已经备份的旧数据文件的修改时间
+	sealed := manifest.Files[0]
+	info, err := os.Stat(data.GetDataFileName(backupDir, sealed.Fid))
+	assert.Nil(t, err)
+	modTime := info.ModTime()
+
+	for i := 1000; i < 2000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(128)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(util.GetTestKey(i)))
+	}
+	manifest2, err := db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+	assert.True(t, len(manifest2.Files) > len(manifest.Files))
+
+	//旧数据文件没有被重复拷贝
+	info, err = os.Stat(data.GetDataFileName(backupDir, sealed.Fid))
+	assert.Nil(t, err)
+	assert.Equal(t, modTime, info.ModTime())
+
+	loaded, err := ReadBackupManifest(backupDir)
+	assert.Nil(t, err)
+	assert.Equal(t, manifest2.Files, loaded.Files)
+
+	//恢复全部数据
+	restoreDir := filepath.Join(os.TempDir(), "bitcask-go-incremental-restore")
+	_ = os.RemoveAll(restoreDir)
+	assert.Nil(t, Restore(backupDir, restoreDir, RestoreLatest))
+	restoreOpts := config.DefaultOptions
+	restoreOpts.DataDir = restoreDir
+	restoreOpts.DataFileMaxSize = opts.DataFileMaxSize
+	db2, err := Open(restoreOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, db.ListKeys(), db2.ListKeys())
+	for _, key := range db.ListKeys() {
+		expected, err := db.Get(key)
+		assert.Nil(t, err)
+		val, err := db2.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+
+	//目标目录不为空
+	assert.Equal(t, util.ErrRestoreDirNotEmpty, Restore(backupDir, restoreDir, RestoreLatest))
+}
+
+func TestDB_IncrementalBackup_Merge(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-incremental-backup-merge")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(128)))
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-incremental-backup-merge-dest")
+	defer os.RemoveAll(backupDir)
+	_, err = db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+
+	//merge之后重启，旧的数据文件被重写
+	for i := 0; i < 800; i++ {
+		assert.Nil(t, db.Delete(util.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	manifest, err := db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+	assert.True(t, manifest.NonMergeFileId > 0)
+
+	restoreDir := filepath.Join(os.TempDir(), "bitcask-go-incremental-restore-merge")
+	_ = os.RemoveAll(restoreDir)
+	assert.Nil(t, Restore(backupDir, restoreDir, RestoreLatest))
+	restoreOpts := config.DefaultOptions
+	restoreOpts.DataDir = restoreDir
+	db2, err := Open(restoreOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, len(db2.ListKeys()))
+	assert.Equal(t, db.ListKeys(), db2.ListKeys())
+}
+
+func TestRestore_SeqNo(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-restore-seq")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	commitBatch := func(key []byte) {
+		wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put(key, []byte("batch")))
+		assert.Nil(t, wb.Commit())
+	}
+
+	commitBatch([]byte("batch-1"))
+	commitBatch([]byte("batch-2"))
+	//序列号为2时的非事务写入
+	assert.Nil(t, db.Put([]byte("put-2"), []byte("put")))
+	assert.Nil(t, db.Delete([]byte("batch-1")))
+	commitBatch([]byte("batch-3"))
+	assert.Nil(t, db.Put([]byte("put-3"), []byte("put")))
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-restore-seq-dest")
+	defer os.RemoveAll(backupDir)
+	manifest, err := db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), manifest.SeqNo)
+
+	restore := func(upToSeqNo uint64) [][]byte {
+		restoreDir := filepath.Join(os.TempDir(), "bitcask-go-restore-seq-target")
+		_ = os.RemoveAll(restoreDir)
+		assert.Nil(t, Restore(backupDir, restoreDir, upToSeqNo))
+		restoreOpts := config.DefaultOptions
+		restoreOpts.DataDir = restoreDir
+		db2, err := Open(restoreOpts)
+		assert.Nil(t, err)
+		defer destroyDB(db2)
+		var keys [][]byte
+		for _, key := range db2.ListKeys() {
+			keys = append(keys, append([]byte{}, key...))
+		}
+		return keys
+	}
+
+	assert.Equal(t, [][]byte{[]byte("batch-1")}, restore(1))
+	assert.Equal(t, [][]byte{[]byte("batch-2"), []byte("put-2")}, restore(2))
+	assert.Equal(t, [][]byte{[]byte("batch-2"), []byte("batch-3"), []byte("put-2"), []byte("put-3")}, restore(RestoreLatest))
+	assert.Nil(t, restore(0))
+}
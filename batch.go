@@ -82,26 +82,47 @@ func (wb *WriteBatch) Commit() error {
 		return util.ErrExceedMaxBatchNum
 	}
 
-	//保证事务处理的串行化
-	wb.db.mutex.Lock()
-	defer wb.db.mutex.Unlock()
+	//和并发的写入合并成一组，由一个协程持有写锁写入，保证事务处理的串行化
+	syncWrite := wb.options.SyncWrite || wb.db.syncEveryWrite()
+	err := wb.db.commitWrite(syncWrite, func(g *writeGroup) (func() error, error) {
+		return wb.db.stagePendingWrites(g, wb.pendingWrites)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
+
+// 将暂存的数据以一个事务的形式写入数据文件，并更新内存索引，调用方需要持有db.mutex
+func (db *DB) commitPendingWrites(pendingWrites map[string]*data.LogRecord, sync bool) error {
+	return db.writeLocked(sync || db.syncEveryWrite(), func(g *writeGroup) (func() error, error) {
+		return db.stagePendingWrites(g, pendingWrites)
+	})
+}
 
+// 把暂存的数据编码到g的缓冲区中，返回的函数在数据写入活跃文件之后更新内存索引
+// 每条数据的key都带上新的事务序列号，最后追加一条LogRecordFinished标记事务完成
+func (db *DB) stagePendingWrites(g *writeGroup, pendingWrites map[string]*data.LogRecord) (func() error, error) {
 	//获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
-	// 将数据写入到data file中
+	// 将数据编码到缓冲区中
 	positions := make(map[string]*data.LogRecordPos)
 
-	for _, record := range wb.pendingWrites {
-		//写入到datafile中，注意这里使用无锁版本，前面已经加锁了
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+	for _, record := range pendingWrites {
+		logRecordPos, err := db.bufferLogRecord(g, &data.LogRecord{
+			Key:    logRecordKeyWithSeq(record.Key, seqNo),
+			Value:  record.Value,
+			Type:   record.Type,
+			Expire: record.Expire,
 		})
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		positions[string(record.Key)] = logRecordPos
@@ -113,35 +134,43 @@ func (wb *WriteBatch) Commit() error {
 		Type: data.LogRecordFinished,
 	}
 
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
-		return err
+	if _, err := db.bufferLogRecord(g, finishedRecord); err != nil {
+		return nil, err
 	}
-
-	//根据配置决定当前是否要同步到磁盘
-	if wb.options.SyncWrite && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
-		}
+	for _, record := range pendingWrites {
+		g.setExists(record.Key, record.Type == data.LogRecordNormal)
 	}
 
-	//更新内存索引
-	for _, record := range wb.pendingWrites {
-		logRecordPos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, logRecordPos)
-		} else if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+	return func() error {
+		//更新内存索引
+		keys := make([][]byte, 0, len(pendingWrites))
+		for _, record := range pendingWrites {
+			logRecordPos := positions[string(record.Key)]
+			var oldPos *data.LogRecordPos
+			if record.Type == data.LogRecordNormal {
+				oldPos = db.index.Put(record.Key, logRecordPos)
+			} else if record.Type == data.LogRecordDeleted {
+				oldPos, _ = db.index.Delete(record.Key)
+			}
+			if oldPos != nil {
+				db.reclaimSize += int64(oldPos.Size)
+			}
+			keys = append(keys, record.Key)
 		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
-		}
-	}
 
-	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
+		//记录被修改的key，用于乐观事务的冲突检测
+		version := db.oracle.recordWrites(keys...)
 
-	return nil
+		//通知订阅者，同一批次的修改使用相同的序列号
+		for _, record := range pendingWrites {
+			if record.Type == data.LogRecordDeleted {
+				db.watchers.publish(version, EventDelete, record.Key, nil)
+			} else {
+				db.watchers.publish(version, EventPut, record.Key, record.Value)
+			}
+		}
+		return nil
+	}, nil
 }
 
 // 将key和序列号
@@ -59,3 +59,29 @@ func Benchmark_Delete(b *testing.B) {
 		assert.Nil(b, err)
 	}
 }
+
+// 并发写入并且每次写入都同步到磁盘，多个写入通过group commit共用一次fsync
+func Benchmark_ParallelSyncPut(b *testing.B) {
+	cfg := config.DefaultOptions
+	cfg.DataDir, _ = os.MkdirTemp("", "bitcask-go-benchmark-sync")
+	cfg.SyncWrites = true
+	syncDB, err := bitcask.Open(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = syncDB.Close()
+		_ = os.RemoveAll(cfg.DataDir)
+	}()
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := syncDB.Put(util.GetTestKey(rand.Int()), util.RandomValue(128)); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
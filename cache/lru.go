@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	//缓存分片的数量，每个分片有自己的锁
+	lruShardNum = 16
+
+	//每个缓存项除了value之外额外占用的内存，包括链表节点和map中的元素
+	lruEntryOverhead = 96
+)
+
+// Key 缓存的key，数据文件中的一条记录由文件id和偏移唯一确定
+type Key struct {
+	Fid    uint32
+	Offset int64
+}
+
+type lruEntry struct {
+	key   Key
+	value []byte
+}
+
+// lruShard 一个缓存分片，链表头部是最近访问的缓存项
+type lruShard struct {
+	lock     *sync.Mutex
+	capacity int64
+	size     int64
+	items    map[Key]*list.Element
+	lru      *list.List
+}
+
+// LRUCache 按记录位置缓存解码之后的value，超过容量时淘汰最久没有访问的缓存项
+// 按key的哈希分成多个分片，减少并发读取时的锁竞争，每个分片的容量是总容量的 1/lruShardNum
+type LRUCache struct {
+	shards []*lruShard
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewLRUCache 创建一个最多占用capacity字节的缓存
+func NewLRUCache(capacity int64) *LRUCache {
+	shards := make([]*lruShard, lruShardNum)
+	for i := range shards {
+		shards[i] = &lruShard{
+			lock:     new(sync.Mutex),
+			capacity: capacity / lruShardNum,
+			items:    make(map[Key]*list.Element),
+			lru:      list.New(),
+		}
+	}
+	return &LRUCache{shards: shards}
+}
+
+func (c *LRUCache) shard(key Key) *lruShard {
+	h := uint64(key.Fid)*0x9E3779B97F4A7C15 ^ uint64(key.Offset)*0xC2B2AE3D27D4EB4F
+	return c.shards[(h>>32)%lruShardNum]
+}
+
+// Get 获取缓存的value，返回的value由缓存持有，调用方不能修改
+func (c *LRUCache) Get(key Key) ([]byte, bool) {
+	s := c.shard(key)
+	s.lock.Lock()
+	elem, ok := s.items[key]
+	if !ok {
+		s.lock.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	value := elem.Value.(*lruEntry).value
+	s.lock.Unlock()
+	c.hits.Add(1)
+	return value, true
+}
+
+// Put 添加缓存项，超过分片容量的value不会被缓存
+func (c *LRUCache) Put(key Key, value []byte) {
+	charge := int64(len(value)) + lruEntryOverhead
+	s := c.shard(key)
+	if charge > s.capacity {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if elem, ok := s.items[key]; ok {
+		//同一个位置的记录不会改变，已经缓存过直接返回
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.items[key] = s.lru.PushFront(&lruEntry{key: key, value: value})
+	s.size += charge
+	for s.size > s.capacity {
+		s.removeElement(s.lru.Back())
+	}
+}
+
+func (s *lruShard) removeElement(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*lruEntry)
+	delete(s.items, entry.key)
+	s.size -= int64(len(entry.value)) + lruEntryOverhead
+}
+
+// Size 返回缓存当前占用的字节数
+func (c *LRUCache) Size() int64 {
+	var size int64
+	for _, s := range c.shards {
+		s.lock.Lock()
+		size += s.size
+		s.lock.Unlock()
+	}
+	return size
+}
+
+// Stats 返回缓存命中和没有命中的次数
+func (c *LRUCache) Stats() (hits uint64, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
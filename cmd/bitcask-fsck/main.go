@@ -0,0 +1,91 @@
+package main
+
+import (
+	bitcask "Bitcask_go"
+	"Bitcask_go/config"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	dataDir   = flag.String("dir", "", "bitcask data directory to check")
+	repair    = flag.Bool("repair", false, "salvage valid records into the directory given by -output")
+	outputDir = flag.String("output", "", "new data directory for the salvaged records, must be empty")
+	verbose   = flag.Bool("v", false, "print every hint mismatch and orphaned transaction")
+)
+
+func main() {
+	flag.Parse()
+	if *dataDir == "" {
+		log.Fatalf("-dir is required")
+	}
+
+	var report *bitcask.FsckReport
+	var err error
+	if *repair {
+		if *outputDir == "" {
+			log.Fatalf("-output is required with -repair")
+		}
+		cfg := config.DefaultOptions
+		cfg.DataDir = *outputDir
+		report, err = bitcask.Repair(*dataDir, cfg)
+	} else {
+		report, err = bitcask.Fsck(*dataDir, nil)
+	}
+	if err != nil {
+		log.Fatalf("failed to check %s: %v", *dataDir, err)
+	}
+
+	printReport(report)
+	if *repair {
+		fmt.Printf("valid records have been salvaged into %s\n", *outputDir)
+	}
+	if !report.Healthy() {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *bitcask.FsckReport) {
+	var records int
+	for _, file := range report.Files {
+		records += file.Records
+		status := "ok"
+		if len(file.Corruptions) > 0 {
+			status = "CORRUPTED"
+		}
+		fmt.Printf("data file %09d: size %d, %d records, %s\n", file.Fid, file.Size, file.Records, status)
+		for _, c := range file.Corruptions {
+			fmt.Printf("  lost %d bytes at offset %d: %v\n", c.Size, c.Offset, c.Err)
+		}
+	}
+
+	fmt.Printf("orphaned transactions: %d\n", len(report.OrphanedTxns))
+	if *verbose {
+		for _, txn := range report.OrphanedTxns {
+			fmt.Printf("  seq %d: %d records, starts at file %d offset %d\n", txn.SeqNo, txn.Records, txn.Fid, txn.Offset)
+		}
+	}
+
+	fmt.Printf("hint mismatches: %d\n", len(report.HintMismatches))
+	if *verbose {
+		for _, m := range report.HintMismatches {
+			if m.Pos == nil {
+				fmt.Printf("  %s\n", m.Reason)
+				continue
+			}
+			fmt.Printf("  key %q -> file %d offset %d: %s\n", m.Key, m.Pos.Fid, m.Pos.Offset, m.Reason)
+		}
+	}
+
+	if len(report.MergeLeftovers) > 0 {
+		state := "unfinished, will be removed on next open"
+		if report.MergeFinished {
+			state = "finished, will be applied on next open"
+		}
+		fmt.Printf("merge directory: %d files, %s\n", len(report.MergeLeftovers), state)
+	}
+
+	fmt.Printf("total: %d data files, %d valid records, %d bytes lost\n", len(report.Files), records, report.LostBytes())
+}
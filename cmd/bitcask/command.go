@@ -0,0 +1,232 @@
+package main
+
+import (
+	bitcask "Bitcask_go"
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	commands["get"] = &command{usage: "<key>", desc: "print the value of a key", run: getCommand}
+	commands["put"] = &command{usage: "[-ttl duration] <key> <value>", desc: "set the value of a key", run: putCommand}
+	commands["delete"] = &command{usage: "<key>", desc: "delete a key", run: deleteCommand}
+	commands["scan"] = &command{usage: "[-prefix p] [-reverse] [-limit n] [-values]", desc: "list keys in order", run: scanCommand}
+	commands["stat"] = &command{usage: "[-format table|json]", desc: "print the statistics of the directory", run: statCommand}
+	commands["merge"] = &command{usage: "[-force]", desc: "merge data files and apply the result", run: mergeCommand}
+	commands["backup"] = &command{usage: "<dir>", desc: "incrementally back up the directory into dir", run: backupCommand}
+	commands["dump"] = &command{usage: "[-values]", desc: "print every record in the data files", run: dumpCommand}
+}
+
+func getCommand(c *cli, args []string) error {
+	args, err := parseArgs("get", new(flag.FlagSet), args, 1)
+	if err != nil {
+		return err
+	}
+	key, err := c.decode(args[0])
+	if err != nil {
+		return err
+	}
+	return c.withDB(nil, func(db *bitcask.DB) error {
+		value, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, c.encode(value))
+		return nil
+	})
+}
+
+func putCommand(c *cli, args []string) error {
+	fs := new(flag.FlagSet)
+	ttl := fs.Duration("ttl", 0, "time to live of the key, 0 means never expire")
+	args, err := parseArgs("put", fs, args, 2)
+	if err != nil {
+		return err
+	}
+	key, err := c.decode(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.decode(args[1])
+	if err != nil {
+		return err
+	}
+	//命令行每次写入之后都会关闭DB，直接同步写入
+	return c.withDB(func(cfg *config.Configuration) { cfg.SyncWrites = true }, func(db *bitcask.DB) error {
+		return db.PutWithTTL(key, value, *ttl)
+	})
+}
+
+func deleteCommand(c *cli, args []string) error {
+	args, err := parseArgs("delete", new(flag.FlagSet), args, 1)
+	if err != nil {
+		return err
+	}
+	key, err := c.decode(args[0])
+	if err != nil {
+		return err
+	}
+	return c.withDB(func(cfg *config.Configuration) { cfg.SyncWrites = true }, func(db *bitcask.DB) error {
+		if _, err := db.Get(key); err != nil {
+			return err
+		}
+		return db.Delete(key)
+	})
+}
+
+func scanCommand(c *cli, args []string) error {
+	fs := new(flag.FlagSet)
+	prefix := fs.String("prefix", "", "only list keys with this prefix")
+	reverse := fs.Bool("reverse", false, "list keys in reverse order")
+	limit := fs.Int("limit", 0, "maximum number of keys to list, 0 means no limit")
+	values := fs.Bool("values", false, "print values after keys")
+	if _, err := parseArgs("scan", fs, args, 0); err != nil {
+		return err
+	}
+	prefixKey, err := c.decode(*prefix)
+	if err != nil {
+		return err
+	}
+	return c.withDB(nil, func(db *bitcask.DB) error {
+		it := db.NewIterator(config.IteratorOptions{Prefix: prefixKey, Reverse: *reverse})
+		defer it.Close()
+
+		var n int
+		for it.Rewind(); it.Valid(); it.Next() {
+			if *limit > 0 && n >= *limit {
+				break
+			}
+			n++
+			if !*values {
+				fmt.Fprintln(c.out, c.encode(it.Key()))
+				continue
+			}
+			value, err := it.Value()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(c.out, "%s\t%s\n", c.encode(it.Key()), c.encode(value))
+		}
+		return nil
+	})
+}
+
+func statCommand(c *cli, args []string) error {
+	fs := new(flag.FlagSet)
+	format := fs.String("format", "table", "output format, table or json")
+	if _, err := parseArgs("stat", fs, args, 0); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return c.withDB(nil, func(db *bitcask.DB) error {
+		stat := db.Stat()
+		if *format == "json" {
+			enc := json.NewEncoder(c.out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(stat)
+		}
+		w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "KeyNum\t%d\n", stat.KeyNum)
+		fmt.Fprintf(w, "DataFileNum\t%d\n", stat.DataFileNum)
+		fmt.Fprintf(w, "ReclaimableSize\t%d\n", stat.ReclaimableSize)
+		fmt.Fprintf(w, "DiskSize\t%d\n", stat.DiskSize)
+		return w.Flush()
+	})
+}
+
+func mergeCommand(c *cli, args []string) error {
+	fs := new(flag.FlagSet)
+	force := fs.Bool("force", false, "merge even if the reclaimable ratio is below the threshold")
+	if _, err := parseArgs("merge", fs, args, 0); err != nil {
+		return err
+	}
+	configure := func(cfg *config.Configuration) {
+		if *force {
+			cfg.DataFileMergeRatio = 0
+		}
+	}
+	err := c.withDB(configure, func(db *bitcask.DB) error {
+		return db.Merge()
+	})
+	if err == util.ErrMergeRatioUnreached {
+		return fmt.Errorf("%v, use -force to merge anyway", err)
+	}
+	if err != nil {
+		return err
+	}
+	//merge的结果在下次打开时才会替换旧的数据文件
+	if err := c.withDB(nil, func(db *bitcask.DB) error { return nil }); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "merge finished")
+	return nil
+}
+
+func backupCommand(c *cli, args []string) error {
+	args, err := parseArgs("backup", new(flag.FlagSet), args, 1)
+	if err != nil {
+		return err
+	}
+	return c.withDB(nil, func(db *bitcask.DB) error {
+		manifest, err := db.IncrementalBackup(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "backed up %d data files up to seq %d into %s\n", len(manifest.Files), manifest.SeqNo, args[0])
+		return nil
+	})
+}
+
+func dumpCommand(c *cli, args []string) error {
+	fs := new(flag.FlagSet)
+	values := fs.Bool("values", false, "print values of the records")
+	if _, err := parseArgs("dump", fs, args, 0); err != nil {
+		return err
+	}
+	return c.withDB(nil, func(db *bitcask.DB) error {
+		w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "FID\tOFFSET\tSIZE\tSEQ\tTYPE\tEXPIRE\tKEY")
+		if *values {
+			fmt.Fprintf(w, "\tVALUE")
+		}
+		fmt.Fprintln(w)
+		err := db.DumpLogRecords(func(record *bitcask.DumpRecord) bool {
+			expire := "-"
+			if record.Expire > 0 {
+				expire = time.Unix(0, record.Expire).Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%s\t%s", record.Fid, record.Offset, record.Size,
+				record.SeqNo, recordTypeName(record.Type), expire, c.encode(record.Key))
+			if *values {
+				fmt.Fprintf(w, "\t%s", c.encode(record.Value))
+			}
+			fmt.Fprintln(w)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func recordTypeName(tp data.LogRecordType) string {
+	switch tp {
+	case data.LogRecordNormal:
+		return "put"
+	case data.LogRecordDeleted:
+		return "delete"
+	case data.LogRecordFinished:
+		return "txn-fin"
+	default:
+		return fmt.Sprintf("unknown(%d)", tp)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	bitcask "Bitcask_go"
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// cli 一次命令行调用的上下文
+type cli struct {
+	dataDir string
+	hex     bool //key和value使用十六进制输入输出
+	out     io.Writer
+}
+
+type command struct {
+	usage string
+	desc  string
+	run   func(c *cli, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out, errOut io.Writer) error {
+	c := &cli{out: out}
+	fs := flag.NewFlagSet("bitcask", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.StringVar(&c.dataDir, "dir", "/tmp/bitcask-go", "bitcask data directory")
+	fs.BoolVar(&c.hex, "hex", false, "read and print keys and values as hex strings")
+	fs.Usage = func() {
+		fmt.Fprintf(errOut, "usage: bitcask [-dir path] [-hex] <command> [args]\n\ncommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(errOut, "  %-48s %s\n", name+" "+commands[name].usage, commands[name].desc)
+		}
+		fmt.Fprintf(errOut, "\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no command given")
+	}
+
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd.run(c, fs.Args()[1:])
+}
+
+// 打开数据目录，目录被其他进程使用时返回明确的错误
+func (c *cli) openDB(configure func(cfg *config.Configuration)) (*bitcask.DB, error) {
+	if _, err := os.Stat(c.dataDir); err != nil {
+		return nil, err
+	}
+	cfg := config.DefaultOptions
+	cfg.DataDir = c.dataDir
+	if configure != nil {
+		configure(&cfg)
+	}
+	db, err := bitcask.Open(cfg)
+	if err == util.ErrDatabaseIsUsing {
+		return nil, fmt.Errorf("%s is locked by another process", c.dataDir)
+	}
+	return db, err
+}
+
+// 在打开的DB上执行fn，之后关闭DB
+func (c *cli) withDB(configure func(cfg *config.Configuration), fn func(db *bitcask.DB) error) error {
+	db, err := c.openDB(configure)
+	if err != nil {
+		return err
+	}
+	if err := fn(db); err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// 解析命令行中的key或value
+func (c *cli) decode(s string) ([]byte, error) {
+	if c.hex {
+		return hex.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// 格式化输出的key或value
+func (c *cli) encode(b []byte) string {
+	if c.hex {
+		return hex.EncodeToString(b)
+	}
+	return string(b)
+}
+
+// 解析子命令的参数，nargs为需要的位置参数个数，-1表示不限制
+func parseArgs(name string, fs *flag.FlagSet, args []string, nargs int) ([]string, error) {
+	fs.Init(name, flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if nargs >= 0 && fs.NArg() != nargs {
+		return nil, fmt.Errorf("%s: usage: %s %s", name, name, commands[name].usage)
+	}
+	return fs.Args(), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	bitcask "Bitcask_go"
+	"Bitcask_go/config"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runCommand(t *testing.T, dir string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(append([]string{"-dir", dir}, args...), &out, io.Discard)
+	return out.String(), err
+}
+
+func TestCli(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cli")
+	defer os.RemoveAll(dir)
+
+	_, err := runCommand(t, dir, "put", "user:1", "alice")
+	assert.Nil(t, err)
+	_, err = runCommand(t, dir, "put", "-ttl", "1h", "user:2", "bob")
+	assert.Nil(t, err)
+	_, err = runCommand(t, dir, "put", "name", "bitcask")
+	assert.Nil(t, err)
+
+	out, err := runCommand(t, dir, "get", "user:1")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice\n", out)
+
+	out, err = runCommand(t, dir, "-hex", "get", "6e616d65")
+	assert.Nil(t, err)
+	assert.Equal(t, "6269746361736b\n", out)
+
+	out, err = runCommand(t, dir, "scan", "-prefix", "user:", "-values")
+	assert.Nil(t, err)
+	assert.Equal(t, "user:1\talice\nuser:2\tbob\n", out)
+
+	out, err = runCommand(t, dir, "scan", "-reverse", "-limit", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "user:2\n", out)
+
+	_, err = runCommand(t, dir, "delete", "user:1")
+	assert.Nil(t, err)
+	_, err = runCommand(t, dir, "get", "user:1")
+	assert.NotNil(t, err)
+
+	out, err = runCommand(t, dir, "stat", "-format", "json")
+	assert.Nil(t, err)
+	stat := &bitcask.Stat{}
+	assert.Nil(t, json.Unmarshal([]byte(out), stat))
+	assert.Equal(t, uint(2), stat.KeyNum)
+
+	out, err = runCommand(t, dir, "dump")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, bytes.Count([]byte(out), []byte("\n")))
+
+	_, err = runCommand(t, dir, "merge", "-force")
+	assert.Nil(t, err)
+	out, err = runCommand(t, dir, "dump")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, bytes.Count([]byte(out), []byte("\n")))
+
+	//数据目录正在被使用
+	opts := config.DefaultOptions
+	opts.DataDir = dir
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	_, err = runCommand(t, dir, "get", "name")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "locked")
+	assert.Nil(t, db.Close())
+
+	_, err = runCommand(t, dir, "unknown")
+	assert.NotNil(t, err)
+	_, err = runCommand(t, dir, "get")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"Bitcask_go/redis"
+	"Bitcask_go/util"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errSyntax         = errors.New("ERR syntax error")
+	errNotInteger     = errors.New("ERR value is not an integer or out of range")
+	errInvalidExpire  = errors.New("ERR invalid expire time in 'set' command")
+	errNotFloat       = errors.New("ERR value is not a valid float")
+	errMinMaxNotFloat = errors.New("ERR min or max is not a float")
+	errNoProto        = errors.New("NOPROTO unsupported protocol version")
+	errClientQuitting = errors.New("client quit")
+)
+
+// cmdHandler 处理一条命令，args[0]为命令名称
+type cmdHandler func(s *server, c *client, args [][]byte) error
+
+type command struct {
+	handler cmdHandler
+	arity   int //参数个数(包含命令名), 负数表示至少 -arity 个
+}
+
+var commandTable = map[string]command{
+	"ping":          {ping, -1},
+	"echo":          {echo, 2},
+	"quit":          {quit, 1},
+	"hello":         {hello, -1},
+	"command":       {commandCmd, -1},
+	"select":        {selectCmd, 2},
+	"set":           {set, -3},
+	"get":           {get, 2},
+	"del":           {del, -2},
+	"type":          {typeCmd, 2},
+	"expire":        {expire, 3},
+	"hset":          {hset, -4},
+	"hget":          {hget, 3},
+	"hdel":          {hdel, -3},
+	"sadd":          {sadd, -3},
+	"sismember":     {sismember, 3},
+	"srem":          {srem, -3},
+	"lpush":         {lpush, -3},
+	"rpush":         {rpush, -3},
+	"lpop":          {lpop, 2},
+	"rpop":          {rpop, 2},
+	"zadd":          {zadd, -4},
+	"zscore":        {zscore, 3},
+	"zrem":          {zrem, -3},
+	"zcard":         {zcard, 2},
+	"zrank":         {zrank, 3},
+	"zrevrank":      {zrevrank, 3},
+	"zrange":        {zrange, -4},
+	"zrevrange":     {zrevrange, -4},
+	"zrangebyscore": {zrangebyscore, -4},
+}
+
+// 校验参数个数
+func (cmd command) checkArity(n int) bool {
+	if cmd.arity >= 0 {
+		return n == cmd.arity
+	}
+	return n >= -cmd.arity
+}
+
+// 将存储引擎返回的错误转换成RESP错误信息，没有错误码的统一加上ERR前缀
+func errorReply(err error) string {
+	msg := err.Error()
+	if err == redis.ErrWrongTypeOperation || err == errNoProto {
+		return msg
+	}
+	if strings.HasPrefix(msg, "ERR ") {
+		return msg
+	}
+	return "ERR " + msg
+}
+
+//==================== Connection ====================
+
+func ping(s *server, c *client, args [][]byte) error {
+	switch len(args) {
+	case 1:
+		c.w.WriteSimpleString("PONG")
+	case 2:
+		c.w.WriteBulk(args[1])
+	default:
+		return fmt.Errorf("ERR wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func echo(s *server, c *client, args [][]byte) error {
+	c.w.WriteBulk(args[1])
+	return nil
+}
+
+func quit(s *server, c *client, args [][]byte) error {
+	c.w.WriteSimpleString("OK")
+	return errClientQuitting
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(s *server, c *client, args [][]byte) error {
+	proto := c.w.proto
+	if len(args) >= 2 {
+		ver, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return errors.New("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != 2 && ver != 3 {
+			return errNoProto
+		}
+		proto = ver
+
+		for i := 2; i < len(args); i++ {
+			switch strings.ToLower(string(args[i])) {
+			case "auth":
+				//目前不支持鉴权，直接忽略用户名和密码
+				if i+2 >= len(args) {
+					return errSyntax
+				}
+				i += 2
+			case "setname":
+				if i+1 >= len(args) {
+					return errSyntax
+				}
+				c.name = string(args[i+1])
+				i++
+			default:
+				return errSyntax
+			}
+		}
+	}
+	c.w.proto = proto
+
+	c.w.WriteMapHeader(7)
+	c.w.WriteBulk([]byte("server"))
+	c.w.WriteBulk([]byte("redis"))
+	c.w.WriteBulk([]byte("version"))
+	c.w.WriteBulk([]byte(serverVersion))
+	c.w.WriteBulk([]byte("proto"))
+	c.w.WriteInteger(int64(proto))
+	c.w.WriteBulk([]byte("id"))
+	c.w.WriteInteger(c.id)
+	c.w.WriteBulk([]byte("mode"))
+	c.w.WriteBulk([]byte("standalone"))
+	c.w.WriteBulk([]byte("role"))
+	c.w.WriteBulk([]byte("master"))
+	c.w.WriteBulk([]byte("modules"))
+	c.w.WriteArrayHeader(0)
+	return nil
+}
+
+// redis-cli 启动时会发送 COMMAND DOCS，这里返回空数组即可
+func commandCmd(s *server, c *client, args [][]byte) error {
+	c.w.WriteArrayHeader(0)
+	return nil
+}
+
+// 只有一个db
+func selectCmd(s *server, c *client, args [][]byte) error {
+	if string(args[1]) != "0" {
+		return errors.New("ERR DB index is out of range")
+	}
+	c.w.WriteSimpleString("OK")
+	return nil
+}
+
+//==================== Generic ====================
+
+func del(s *server, c *client, args [][]byte) error {
+	var deleted int64
+	for _, key := range args[1:] {
+		if s.rdb.Type(key) == redis.RUnknown {
+			continue
+		}
+		if err := s.rdb.Del(key); err != nil {
+			return err
+		}
+		deleted++
+	}
+	c.w.WriteInteger(deleted)
+	return nil
+}
+
+func typeCmd(s *server, c *client, args [][]byte) error {
+	c.w.WriteSimpleString(s.rdb.Type(args[1]).String())
+	return nil
+}
+
+// EXPIRE key seconds
+func expire(s *server, c *client, args [][]byte) error {
+	seconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	ok, err := s.rdb.Expire(args[1], time.Duration(seconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	if ok {
+		c.w.WriteInteger(1)
+	} else {
+		c.w.WriteInteger(0)
+	}
+	return nil
+}
+
+//==================== String ====================
+
+// SET key value [EX seconds | PX milliseconds]
+func set(s *server, c *client, args [][]byte) error {
+	var ttl time.Duration
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if (opt != "ex" && opt != "px") || i+1 >= len(args) || ttl != 0 {
+			return errSyntax
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return errNotInteger
+		}
+		if n <= 0 {
+			return errInvalidExpire
+		}
+		if opt == "ex" {
+			ttl = time.Duration(n) * time.Second
+		} else {
+			ttl = time.Duration(n) * time.Millisecond
+		}
+		i++
+	}
+
+	if err := s.rdb.Set(args[1], ttl, args[2]); err != nil {
+		return err
+	}
+	c.w.WriteSimpleString("OK")
+	return nil
+}
+
+func get(s *server, c *client, args [][]byte) error {
+	value, err := s.rdb.Get(args[1])
+	if err == util.ErrKeyNotFound || err == redis.ErrKeyIsExpired {
+		c.w.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.WriteBulk(value)
+	return nil
+}
+
+//==================== Hash ====================
+
+// HSET key field value [field value ...]
+func hset(s *server, c *client, args [][]byte) error {
+	if len(args)%2 != 0 {
+		return fmt.Errorf("ERR wrong number of arguments for 'hset' command")
+	}
+
+	var added int64
+	for i := 2; i < len(args); i += 2 {
+		ok, err := s.rdb.HSet(args[1], args[i], args[i+1])
+		if err != nil {
+			return err
+		}
+		if ok {
+			added++
+		}
+	}
+	c.w.WriteInteger(added)
+	return nil
+}
+
+func hget(s *server, c *client, args [][]byte) error {
+	value, err := s.rdb.HGet(args[1], args[2])
+	if err == util.ErrKeyNotFound || (err == nil && value == nil) {
+		c.w.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.WriteBulk(value)
+	return nil
+}
+
+func hdel(s *server, c *client, args [][]byte) error {
+	var deleted int64
+	for _, field := range args[2:] {
+		ok, err := s.rdb.HDel(args[1], field)
+		if err != nil {
+			return err
+		}
+		if ok {
+			deleted++
+		}
+	}
+	c.w.WriteInteger(deleted)
+	return nil
+}
+
+//==================== Set ====================
+
+func sadd(s *server, c *client, args [][]byte) error {
+	var added int64
+	for _, member := range args[2:] {
+		ok, err := s.rdb.SAdd(args[1], member)
+		if err != nil {
+			return err
+		}
+		if ok {
+			added++
+		}
+	}
+	c.w.WriteInteger(added)
+	return nil
+}
+
+func sismember(s *server, c *client, args [][]byte) error {
+	ok, err := s.rdb.SIsMember(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	if ok {
+		c.w.WriteInteger(1)
+	} else {
+		c.w.WriteInteger(0)
+	}
+	return nil
+}
+
+func srem(s *server, c *client, args [][]byte) error {
+	var removed int64
+	for _, member := range args[2:] {
+		ok, err := s.rdb.SRem(args[1], member)
+		if err != nil {
+			return err
+		}
+		if ok {
+			removed++
+		}
+	}
+	c.w.WriteInteger(removed)
+	return nil
+}
+
+//==================== List ====================
+
+func lpush(s *server, c *client, args [][]byte) error {
+	return pushGeneric(s, c, args, true)
+}
+
+func rpush(s *server, c *client, args [][]byte) error {
+	return pushGeneric(s, c, args, false)
+}
+
+func pushGeneric(s *server, c *client, args [][]byte, isLeft bool) error {
+	var size uint32
+	for _, element := range args[2:] {
+		var err error
+		if isLeft {
+			size, err = s.rdb.LPush(args[1], element)
+		} else {
+			size, err = s.rdb.RPush(args[1], element)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.w.WriteInteger(int64(size))
+	return nil
+}
+
+func lpop(s *server, c *client, args [][]byte) error {
+	return popGeneric(s, c, args, true)
+}
+
+func rpop(s *server, c *client, args [][]byte) error {
+	return popGeneric(s, c, args, false)
+}
+
+func popGeneric(s *server, c *client, args [][]byte, isLeft bool) error {
+	var value []byte
+	var err error
+	if isLeft {
+		value, err = s.rdb.LPop(args[1])
+	} else {
+		value, err = s.rdb.RPop(args[1])
+	}
+	//列表为空时，对应位置的元素不存在
+	if err == util.ErrKeyNotFound || (err == nil && value == nil) {
+		c.w.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.WriteBulk(value)
+	return nil
+}
+
+//==================== ZSet ====================
+
+// ZADD key score member [score member ...]
+func zadd(s *server, c *client, args [][]byte) error {
+	if len(args)%2 != 0 {
+		return errSyntax
+	}
+
+	//先校验所有分数，避免只写入了一部分
+	scores := make([]float64, 0, (len(args)-2)/2)
+	for i := 2; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(string(args[i]), 64)
+		if err != nil || math.IsNaN(score) {
+			return errNotFloat
+		}
+		scores = append(scores, score)
+	}
+
+	var added int64
+	for i, score := range scores {
+		ok, err := s.rdb.ZAdd(args[1], score, args[3+2*i])
+		if err != nil {
+			return err
+		}
+		if ok {
+			added++
+		}
+	}
+	c.w.WriteInteger(added)
+	return nil
+}
+
+func zscore(s *server, c *client, args [][]byte) error {
+	score, err := s.rdb.ZScore(args[1], args[2])
+	if err == util.ErrKeyNotFound {
+		c.w.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.WriteBulk(formatScore(score))
+	return nil
+}
+
+func zrem(s *server, c *client, args [][]byte) error {
+	var removed int64
+	for _, member := range args[2:] {
+		ok, err := s.rdb.ZRem(args[1], member)
+		if err != nil {
+			return err
+		}
+		if ok {
+			removed++
+		}
+	}
+	c.w.WriteInteger(removed)
+	return nil
+}
+
+func zcard(s *server, c *client, args [][]byte) error {
+	size, err := s.rdb.ZCard(args[1])
+	if err != nil {
+		return err
+	}
+	c.w.WriteInteger(int64(size))
+	return nil
+}
+
+func zrank(s *server, c *client, args [][]byte) error {
+	return zrankGeneric(s, c, args, false)
+}
+
+func zrevrank(s *server, c *client, args [][]byte) error {
+	return zrankGeneric(s, c, args, true)
+}
+
+func zrankGeneric(s *server, c *client, args [][]byte, reverse bool) error {
+	var rank int
+	var err error
+	if reverse {
+		rank, err = s.rdb.ZRevRank(args[1], args[2])
+	} else {
+		rank, err = s.rdb.ZRank(args[1], args[2])
+	}
+	if err == util.ErrKeyNotFound {
+		c.w.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.w.WriteInteger(int64(rank))
+	return nil
+}
+
+// ZRANGE key start stop [WITHSCORES]
+func zrange(s *server, c *client, args [][]byte) error {
+	return zrangeGeneric(s, c, args, false)
+}
+
+// ZREVRANGE key start stop [WITHSCORES]
+func zrevrange(s *server, c *client, args [][]byte) error {
+	return zrangeGeneric(s, c, args, true)
+}
+
+func zrangeGeneric(s *server, c *client, args [][]byte, reverse bool) error {
+	withScores, err := parseWithScores(args[4:])
+	if err != nil {
+		return err
+	}
+	start, err1 := strconv.Atoi(string(args[2]))
+	stop, err2 := strconv.Atoi(string(args[3]))
+	if err1 != nil || err2 != nil {
+		return errNotInteger
+	}
+
+	var members []*redis.ZSetMember
+	if reverse {
+		members, err = s.rdb.ZRevRange(args[1], start, stop)
+	} else {
+		members, err = s.rdb.ZRange(args[1], start, stop)
+	}
+	if err != nil {
+		return err
+	}
+	writeZSetMembers(c, members, withScores)
+	return nil
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES], min和max支持 -inf/+inf 以及 ( 开头的开区间
+func zrangebyscore(s *server, c *client, args [][]byte) error {
+	withScores, err := parseWithScores(args[4:])
+	if err != nil {
+		return err
+	}
+	min, minExclusive, err1 := parseScoreBound(args[2])
+	max, maxExclusive, err2 := parseScoreBound(args[3])
+	if err1 != nil || err2 != nil {
+		return errMinMaxNotFloat
+	}
+
+	members, err := s.rdb.ZRangeByScore(args[1], min, max)
+	if err != nil {
+		return err
+	}
+
+	//过滤掉开区间的边界值
+	result := members[:0]
+	for _, m := range members {
+		if (minExclusive && m.Score == min) || (maxExclusive && m.Score == max) {
+			continue
+		}
+		result = append(result, m)
+	}
+	writeZSetMembers(c, result, withScores)
+	return nil
+}
+
+func parseWithScores(opts [][]byte) (bool, error) {
+	if len(opts) == 0 {
+		return false, nil
+	}
+	if len(opts) == 1 && strings.ToLower(string(opts[0])) == "withscores" {
+		return true, nil
+	}
+	return false, errSyntax
+}
+
+func parseScoreBound(arg []byte) (float64, bool, error) {
+	var exclusive bool
+	if len(arg) > 0 && arg[0] == '(' {
+		exclusive = true
+		arg = arg[1:]
+	}
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err == nil && math.IsNaN(score) {
+		err = errNotFloat
+	}
+	return score, exclusive, err
+}
+
+func writeZSetMembers(c *client, members []*redis.ZSetMember, withScores bool) {
+	if withScores {
+		c.w.WriteArrayHeader(len(members) * 2)
+	} else {
+		c.w.WriteArrayHeader(len(members))
+	}
+	for _, m := range members {
+		c.w.WriteBulk(m.Member)
+		if withScores {
+			c.w.WriteBulk(formatScore(m.Score))
+		}
+	}
+}
+
+func formatScore(score float64) []byte {
+	return []byte(strconv.FormatFloat(score, 'g', 17, 64))
+}
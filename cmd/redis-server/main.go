@@ -0,0 +1,152 @@
+package main
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/redis"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const serverVersion = "7.0.0"
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:6380", "address to listen on")
+	dataDir = flag.String("dir", "/tmp/bitcask-go-redis", "bitcask data directory")
+)
+
+// server 基于 RedisDB 的 RESP 协议服务
+type server struct {
+	rdb *redis.RedisDB
+	// RedisDB 中的命令是"读元数据-写数据"两步完成的，这里和redis一样串行执行命令
+	mu       sync.Mutex
+	clientId int64
+}
+
+// client 表示一个客户端连接
+type client struct {
+	id   int64
+	name string
+	conn net.Conn
+	r    *respReader
+	w    *respWriter
+}
+
+func newServer(rdb *redis.RedisDB) *server {
+	return &server{rdb: rdb}
+}
+
+func (s *server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *server) handleConn(conn net.Conn) {
+	c := &client{
+		id:   atomic.AddInt64(&s.clientId, 1),
+		conn: conn,
+		r:    newRespReader(conn),
+		w:    newRespWriter(conn),
+	}
+	defer conn.Close()
+
+	for {
+		args, err := c.r.ReadCommand()
+		if err != nil {
+			if err == errProtocol {
+				c.w.WriteError(err.Error())
+				_ = c.w.Flush()
+			} else if err != io.EOF {
+				log.Printf("failed to read command from %s: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.execute(c, args)
+
+		if err := c.w.Flush(); err != nil || quit {
+			return
+		}
+	}
+}
+
+// 执行一条命令，返回值表示是否需要关闭连接
+func (s *server) execute(c *client, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commandTable[name]
+	if !ok {
+		c.w.WriteError("ERR unknown command '" + string(args[0]) + "'")
+		return false
+	}
+	if !cmd.checkArity(len(args)) {
+		c.w.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+
+	s.mu.Lock()
+	err := cmd.handler(s, c, args)
+	s.mu.Unlock()
+
+	if err == errClientQuitting {
+		return true
+	}
+	if err != nil {
+		c.w.WriteError(errorReply(err))
+	}
+	return false
+}
+
+func main() {
+	flag.Parse()
+
+	cfg := config.DefaultOptions
+	cfg.DataDir = *dataDir
+	rdb, err := redis.NewRedisDB(cfg)
+	if err != nil {
+		log.Fatalf("failed to open redis db: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", *addr, err)
+	}
+
+	//收到退出信号后关闭DB，保证数据落盘
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		_ = listener.Close()
+		s := <-sig
+		log.Printf("received signal %v twice, exit now", s)
+		os.Exit(1)
+	}()
+
+	//后台清理过期的key
+	rdb.StartExpireReaper(config.DefaultExpireReaperOptions)
+
+	log.Printf("bitcask redis server is listening on %s\n", *addr)
+	srv := newServer(rdb)
+	_ = srv.Serve(listener)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if err := rdb.Close(); err != nil {
+		log.Fatalf("failed to close redis db: %v", err)
+	}
+}
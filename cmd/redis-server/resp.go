@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RESP 协议中各数据类型的前缀
+const (
+	respSimpleString = '+'
+	respError        = '-'
+	respInteger      = ':'
+	respBulkString   = '$'
+	respArray        = '*'
+	respNull         = '_' // RESP3
+	respMap          = '%' // RESP3
+)
+
+const (
+	maxBulkLen  = 512 * 1024 * 1024 //单个bulk string的最大长度，与redis保持一致
+	maxArrayLen = 1024 * 1024       //单条命令的最大参数个数
+)
+
+var (
+	errProtocol = errors.New("ERR Protocol error")
+)
+
+// respReader 从连接中解析客户端发来的命令
+type respReader struct {
+	rd *bufio.Reader
+}
+
+func newRespReader(r io.Reader) *respReader {
+	return &respReader{rd: bufio.NewReader(r)}
+}
+
+// ReadCommand 读取一条命令，支持标准的数组格式以及telnet使用的inline格式
+func (r *respReader) ReadCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	//inline 命令: PING\r\n
+	if line[0] != respArray {
+		fields := strings.Fields(string(line))
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = []byte(f)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArrayLen {
+		return nil, errProtocol
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	args := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		if args[i], err = r.readBulk(); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func (r *respReader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != respBulkString {
+		return nil, errProtocol
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxBulkLen {
+		return nil, errProtocol
+	}
+
+	//数据后面还有\r\n
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.rd, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, errProtocol
+	}
+	return buf[:n], nil
+}
+
+// 读取一行数据，去掉末尾的\r\n
+func (r *respReader) readLine() ([]byte, error) {
+	line, err := r.rd.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// respWriter 将回复按照RESP协议编码后写回客户端
+type respWriter struct {
+	wr    *bufio.Writer
+	proto int //协议版本，2或3，通过HELLO命令切换
+}
+
+func newRespWriter(w io.Writer) *respWriter {
+	return &respWriter{wr: bufio.NewWriter(w), proto: 2}
+}
+
+func (w *respWriter) WriteSimpleString(s string) {
+	w.wr.WriteByte(respSimpleString)
+	w.wr.WriteString(s)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *respWriter) WriteError(msg string) {
+	w.wr.WriteByte(respError)
+	w.wr.WriteString(msg)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *respWriter) WriteInteger(n int64) {
+	w.wr.WriteByte(respInteger)
+	w.wr.WriteString(strconv.FormatInt(n, 10))
+	w.wr.WriteString("\r\n")
+}
+
+func (w *respWriter) WriteBulk(b []byte) {
+	w.wr.WriteByte(respBulkString)
+	w.wr.WriteString(strconv.Itoa(len(b)))
+	w.wr.WriteString("\r\n")
+	w.wr.Write(b)
+	w.wr.WriteString("\r\n")
+}
+
+// WriteNull RESP2 中使用 $-1 表示空值，RESP3 有单独的 null 类型
+func (w *respWriter) WriteNull() {
+	if w.proto >= 3 {
+		w.wr.WriteString(string(respNull) + "\r\n")
+		return
+	}
+	w.wr.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) WriteArrayHeader(n int) {
+	w.wr.WriteByte(respArray)
+	w.wr.WriteString(strconv.Itoa(n))
+	w.wr.WriteString("\r\n")
+}
+
+// WriteMapHeader RESP2 不支持map类型，退化为长度为2n的数组
+func (w *respWriter) WriteMapHeader(n int) {
+	if w.proto >= 3 {
+		w.wr.WriteByte(respMap)
+		w.wr.WriteString(strconv.Itoa(n))
+		w.wr.WriteString("\r\n")
+		return
+	}
+	w.WriteArrayHeader(n * 2)
+}
+
+func (w *respWriter) Flush() error {
+	return w.wr.Flush()
+}
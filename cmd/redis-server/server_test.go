@@ -0,0 +1,124 @@
+package main
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/redis"
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startTestServer(t *testing.T) net.Conn {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-server")
+	opts.DataDir = dir
+	rdb, err := redis.NewRedisDB(opts)
+	assert.Nil(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go newServer(rdb).Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = listener.Close()
+		_ = rdb.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return conn
+}
+
+func sendCommand(t *testing.T, conn net.Conn, rd *bufio.Reader, raw string, expect string) {
+	_, err := conn.Write([]byte(raw))
+	assert.Nil(t, err)
+
+	buf := make([]byte, len(expect))
+	_, err = io.ReadFull(rd, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, expect, string(buf))
+}
+
+func TestServer_String(t *testing.T) {
+	conn := startTestServer(t)
+	rd := bufio.NewReader(conn)
+
+	sendCommand(t, conn, rd, "PING\r\n", "+PONG\r\n")
+	sendCommand(t, conn, rd, "*3\r\n$3\r\nSET\r\n$4\r\nname\r\n$7\r\nbitcask\r\n", "+OK\r\n")
+	sendCommand(t, conn, rd, "*2\r\n$3\r\nGET\r\n$4\r\nname\r\n", "$7\r\nbitcask\r\n")
+	sendCommand(t, conn, rd, "*2\r\n$4\r\nTYPE\r\n$4\r\nname\r\n", "+string\r\n")
+	sendCommand(t, conn, rd, "*2\r\n$3\r\nDEL\r\n$4\r\nname\r\n", ":1\r\n")
+	sendCommand(t, conn, rd, "*2\r\n$3\r\nGET\r\n$4\r\nname\r\n", "$-1\r\n")
+	sendCommand(t, conn, rd, "*1\r\n$3\r\nFOO\r\n", "-ERR unknown command 'FOO'\r\n")
+}
+
+func TestServer_ExpiredKey(t *testing.T) {
+	conn := startTestServer(t)
+	rd := bufio.NewReader(conn)
+
+	sendCommand(t, conn, rd, "SET temp value PX 10\r\n", "+OK\r\n")
+	sendCommand(t, conn, rd, "SET name bitcask\r\n", "+OK\r\n")
+	time.Sleep(20 * time.Millisecond)
+
+	//过期的key既没有类型，也不计入删除的数量
+	sendCommand(t, conn, rd, "TYPE temp\r\n", "+none\r\n")
+	sendCommand(t, conn, rd, "DEL temp name\r\n", ":1\r\n")
+}
+
+func TestServer_WrongType(t *testing.T) {
+	conn := startTestServer(t)
+	rd := bufio.NewReader(conn)
+
+	sendCommand(t, conn, rd, "*4\r\n$4\r\nHSET\r\n$1\r\nh\r\n$1\r\nf\r\n$1\r\nv\r\n", ":1\r\n")
+	sendCommand(t, conn, rd, "*3\r\n$4\r\nHGET\r\n$1\r\nh\r\n$1\r\nf\r\n", "$1\r\nv\r\n")
+	sendCommand(t, conn, rd, "*3\r\n$4\r\nSADD\r\n$1\r\nh\r\n$1\r\nm\r\n",
+		"-"+redis.ErrWrongTypeOperation.Error()+"\r\n")
+	sendCommand(t, conn, rd, "*3\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n$1\r\na\r\n", ":1\r\n")
+	sendCommand(t, conn, rd, "*2\r\n$4\r\nLPOP\r\n$1\r\nl\r\n", "$1\r\na\r\n")
+	sendCommand(t, conn, rd, "*2\r\n$4\r\nLPOP\r\n$1\r\nl\r\n", "$-1\r\n")
+}
+
+func TestServer_Hello(t *testing.T) {
+	conn := startTestServer(t)
+	rd := bufio.NewReader(conn)
+
+	sendCommand(t, conn, rd, "*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n", "-NOPROTO unsupported protocol version\r\n")
+
+	_, err := conn.Write([]byte("*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n"))
+	assert.Nil(t, err)
+	line, err := rd.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "%7\r\n", line)
+	//跳过HELLO剩余的回复内容: 11个bulk string(每个两行)，proto/id两个整数，modules空数组
+	for i := 0; i < 11*2+2+1; i++ {
+		_, err := rd.ReadString('\n')
+		assert.Nil(t, err)
+	}
+
+	//RESP3 下空值使用 null 类型
+	sendCommand(t, conn, rd, "*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n", "_\r\n")
+}
+
+func TestServer_ZSet(t *testing.T) {
+	conn := startTestServer(t)
+	rd := bufio.NewReader(conn)
+
+	sendCommand(t, conn, rd, "ZADD board 10 a 5 b 20 c\r\n", ":3\r\n")
+	sendCommand(t, conn, rd, "ZRANGE board 0 -1\r\n", "*3\r\n$1\r\nb\r\n$1\r\na\r\n$1\r\nc\r\n")
+	sendCommand(t, conn, rd, "ZREVRANGE board 0 0 WITHSCORES\r\n", "*2\r\n$1\r\nc\r\n$2\r\n20\r\n")
+	sendCommand(t, conn, rd, "ZRANGEBYSCORE board (5 +inf\r\n", "*2\r\n$1\r\na\r\n$1\r\nc\r\n")
+	sendCommand(t, conn, rd, "ZRANK board a\r\n", ":1\r\n")
+	sendCommand(t, conn, rd, "ZSCORE board missing\r\n", "$-1\r\n")
+	sendCommand(t, conn, rd, "TYPE board\r\n", "+zset\r\n")
+
+	//NaN 分数会被拒绝
+	sendCommand(t, conn, rd, "ZADD board nan d\r\n", "-ERR value is not a valid float\r\n")
+	sendCommand(t, conn, rd, "ZRANGEBYSCORE board nan +inf\r\n", "-ERR min or max is not a float\r\n")
+	sendCommand(t, conn, rd, "ZCARD board\r\n", ":3\r\n")
+}
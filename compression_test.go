@@ -0,0 +1,87 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/fio"
+	"Bitcask_go/util"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 统计数据文件中各种压缩算法的记录数量
+func countCompression(t *testing.T, db *DB) map[data.CompressionType]int {
+	counts := make(map[data.CompressionType]int)
+	for _, fid := range db.fds {
+		dataFile, err := data.OpenDataFile(db.configuration.DataDir, uint32(fid), fio.StandardFIO)
+		assert.Nil(t, err)
+		var offset int64
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			if logRecord.Type == data.LogRecordNormal {
+				counts[logRecord.Compression]++
+			}
+			offset += size
+		}
+		assert.Nil(t, dataFile.Close())
+	}
+	return counts
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DataDir = dir
+	opts.DataFileMergeRatio = 0
+	opts.Compression = config.Zstd
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","type":"kv"}`), 100)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), value))
+	}
+	//压缩之后占用的磁盘空间远小于原始数据
+	assert.True(t, db.activeFile.WriteOffset < int64(len(value)*100/5))
+
+	//换一种压缩算法重启，新旧数据可以共存
+	assert.Nil(t, db.Close())
+	opts.Compression = config.Snappy
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), value))
+	}
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(util.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	//关闭压缩，重启之后merge，旧数据按照新的配置重写
+	assert.Nil(t, db.Close())
+	opts.Compression = config.NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[data.CompressionType]int{data.ZstdCompression: 100, data.SnappyCompression: 100}, countCompression(t, db))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, map[data.CompressionType]int{data.NoCompression: 200}, countCompression(t, db))
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(util.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
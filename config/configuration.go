@@ -3,16 +3,27 @@ package config
 import (
 	"Bitcask_go/util"
 	"os"
+	"time"
 )
 
 type Configuration struct {
-	DataDir            string      //数据文件存放的目录
-	DataFileMaxSize    int64       //数据文件的最大大小，单位为字节
-	SyncWrites         bool        //是否同步写入数据到磁盘
-	IndexerType        IndexerType //索引的类型
-	BytesPerSync       uint        //累计写到多少byte后进行持久化
-	MMapAtStartup      bool        //DB启动时是否使用MMap进行加载
-	DataFileMergeRatio float32     //DB merge的阈值
+	DataDir            string          //数据文件存放的目录
+	DataFileMaxSize    int64           //数据文件的最大大小，单位为字节
+	SyncWrites         bool            //是否同步写入数据到磁盘
+	IndexerType        IndexerType     //索引的类型
+	BytesPerSync       uint            //累计写到多少byte后进行持久化
+	MMapAtStartup      bool            //DB启动时是否使用MMap进行加载
+	DataFileMergeRatio float32         //DB merge的阈值
+	WatchBufferSize    int             //每个Watch订阅者最多缓冲的事件数量
+	Compression        CompressionType //value的压缩算法，新旧压缩算法写入的数据可以共存
+	KeyProvider        KeyProvider     //加密数据使用的密钥，为空表示不加密
+	TruncateSealedFile bool            //旧数据文件损坏时默认打开失败，为true时截断损坏位置之后的数据，会丢失其后的有效记录
+	IndexLoadWorkers   int             //启动时并行扫描数据文件构建索引的协程数量，0表示使用CPU核数
+	IndexShards        int             //大于1时按key的哈希把索引分成多个子索引，减少并发读写的锁竞争，B+树索引不支持
+	FileIOType         FileIOType      //启动之后数据文件使用的IO类型
+	ValueCacheSize     int64           //按记录位置缓存读取到的value最多占用的字节数，0表示不缓存
+	SyncPolicy         SyncPolicy      //数据同步到磁盘的策略，默认按照SyncWrites和BytesPerSync决定
+	SyncInterval       time.Duration   //SyncPolicy为SyncEveryInterval时后台同步的时间间隔
 }
 
 func CheckCfg(cfg Configuration) error {
@@ -25,6 +36,27 @@ func CheckCfg(cfg Configuration) error {
 	if cfg.DataFileMergeRatio < 0 || cfg.DataFileMergeRatio > 1 {
 		return util.ErrDataMergeRatioInvlid
 	}
+	if cfg.Compression > CustomCompression {
+		return util.ErrUnknownCompression
+	}
+	if cfg.IndexShards > 1 && cfg.IndexerType == BPTree {
+		return util.ErrIndexShardUnsupported
+	}
+	if cfg.FileIOType > IOUringFIO {
+		return util.ErrUnknownFileIOType
+	}
+	if cfg.FileIOType == WritableMemoryMap && cfg.IndexerType == BPTree {
+		return util.ErrMMapWriteUnsupported
+	}
+	if cfg.SyncPolicy > SyncNever {
+		return util.ErrUnknownSyncPolicy
+	}
+	if cfg.SyncPolicy == SyncEveryInterval && cfg.SyncInterval <= 0 {
+		return util.ErrSyncIntervalInvalid
+	}
+	if cfg.SyncPolicy == SyncEveryBytes && cfg.BytesPerSync == 0 {
+		return util.ErrBytesPerSyncInvalid
+	}
 	return nil
 }
 
@@ -41,8 +73,77 @@ const (
 	ART
 	SkipListIndex
 	BPTree
+	//哈希索引内存占用小，但迭代器无序，Seek时需要先对所有key排序，不适合需要按顺序遍历key的场景(例如redis数据结构)
+	HashIndex
+)
+
+type FileIOType = byte
+
+const (
+	//所有数据文件都使用系统调用读写
+	StandardFIO FileIOType = iota
+	//旧数据文件使用只读的内存映射，活跃文件使用系统调用读写
+	MemoryMap
+	//旧数据文件使用只读的内存映射，活跃文件使用预先分配到DataFileMaxSize的可读写内存映射，读写都不需要系统调用
+	WritableMemoryMap
+	//读取时使用O_DIRECT绕过页缓存，启动时扫描数据文件也不会占用页缓存，文件系统不支持时使用标准文件IO
+	DirectFIO
+	//使用io_uring读取数据文件，内核不支持时使用标准文件IO
+	IOUringFIO
+)
+
+type SyncPolicy = byte
+
+const (
+	//每次写入时是否同步由SyncWrites决定，没有同步时累计写入BytesPerSync字节之后同步
+	SyncByWriteOptions SyncPolicy = iota
+	//每次写入都同步到磁盘，写入返回之后数据不会丢失
+	SyncAlways
+	//后台协程每隔SyncInterval同步一次，崩溃时最多丢失SyncInterval时间内写入的数据
+	SyncEveryInterval
+	//累计写入BytesPerSync字节之后同步，崩溃时最多丢失BytesPerSync字节，写入停止之后剩下的数据不会主动同步
+	SyncEveryBytes
+	//不主动同步，由操作系统决定什么时候写回磁盘，只有关闭DB、切换活跃文件以及调用Sync、SyncAll时同步
+	SyncNever
+)
+
+type CompressionType = byte
+
+const (
+	NoCompression CompressionType = iota
+	Snappy
+	Zstd
+	//通过 data.RegisterCompressor 注册的自定义压缩算法
+	CustomCompression
 )
 
+// KeyProvider 提供AES-GCM加密使用的密钥，密钥长度为16、24或32字节
+// 新写入的数据使用CurrentKeyId对应的密钥，旧数据根据记录中保存的密钥id获取密钥解密；
+// 轮换密钥时返回新的CurrentKeyId并保留旧的密钥，merge之后旧数据会使用新的密钥重新加密
+// Key 对于不存在的密钥id需要返回 util.ErrEncryptionKeyNotFound
+type KeyProvider interface {
+	CurrentKeyId() uint32
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider 使用固定的密钥集合
+type StaticKeyProvider struct {
+	CurrentId uint32
+	Keys      map[uint32][]byte
+}
+
+func (p *StaticKeyProvider) CurrentKeyId() uint32 {
+	return p.CurrentId
+}
+
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.Keys[id]
+	if !ok {
+		return nil, util.ErrEncryptionKeyNotFound
+	}
+	return key, nil
+}
+
 var DefaultOptions = Configuration{
 	DataDir:            os.TempDir(),
 	DataFileMaxSize:    256 * 1024 * 1024, //256MB
@@ -51,6 +152,15 @@ var DefaultOptions = Configuration{
 	BytesPerSync:       0,
 	MMapAtStartup:      true,
 	DataFileMergeRatio: 0.5,
+	WatchBufferSize:    1024,
+	Compression:        NoCompression,
+	TruncateSealedFile: false,
+	IndexLoadWorkers:   0,
+	IndexShards:        1,
+	FileIOType:         StandardFIO,
+	ValueCacheSize:     0,
+	SyncPolicy:         SyncByWriteOptions,
+	SyncInterval:       time.Second,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -67,3 +177,31 @@ var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrite:   true,
 }
+
+// ExpireReaperOptions redis数据结构过期key后台清理的配置项
+type ExpireReaperOptions struct {
+	Interval   time.Duration //两次清理之间的时间间隔
+	SampleSize int           //每次清理最多检查的key数量，包括元数据和数据部分
+}
+
+var DefaultExpireReaperOptions = ExpireReaperOptions{
+	Interval:   100 * time.Millisecond,
+	SampleSize: 200,
+}
+
+// ReplicationOptions 主从复制的配置项
+type ReplicationOptions struct {
+	PollInterval      time.Duration //leader追上最新写入后，检查是否有新数据的时间间隔
+	HeartbeatInterval time.Duration //leader没有数据可发送时，发送心跳的时间间隔
+	ReadTimeout       time.Duration //follower超过这个时间没有收到任何数据，认为连接已经断开
+	ReconnectInterval time.Duration //follower断开连接之后，重新连接的时间间隔
+	MaxBatchBytes     int           //leader每次从数据文件中读取的最大字节数
+}
+
+var DefaultReplicationOptions = ReplicationOptions{
+	PollInterval:      10 * time.Millisecond,
+	HeartbeatInterval: time.Second,
+	ReadTimeout:       5 * time.Second,
+	ReconnectInterval: time.Second,
+	MaxBatchBytes:     1024 * 1024, //1MB
+}
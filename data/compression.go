@@ -0,0 +1,116 @@
+package data
+
+import (
+	"Bitcask_go/util"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+type CompressionType = byte
+
+const (
+	NoCompression     CompressionType = iota //不压缩，旧格式的记录都是这种
+	SnappyCompression                        //snappy压缩，速度快
+	ZstdCompression                          //zstd压缩，压缩率高
+	CustomCompression                        //用户通过RegisterCompressor注册的压缩算法
+)
+
+// type 字节中第5、6位表示value使用的压缩算法，只有两位，所以最多支持4种
+const (
+	logRecordCompressionShift      = 5
+	logRecordCompressionMask  byte = 0x3 << logRecordCompressionShift
+)
+
+// Compressor 压缩算法
+type Compressor interface {
+	// Compress 压缩数据
+	Compress(src []byte) []byte
+
+	// Decompress 解压数据
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	compressorsLock = new(sync.RWMutex)
+	compressors     = map[CompressionType]Compressor{
+		SnappyCompression: snappyCompressor{},
+		ZstdCompression:   &zstdCompressor{},
+	}
+)
+
+// RegisterCompressor 注册自定义的压缩算法，使用 CustomCompression 类型
+// 写入过自定义压缩数据的DB，之后打开时也必须先注册同样的算法
+func RegisterCompressor(c Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	compressors[CustomCompression] = c
+}
+
+func getCompressor(tp CompressionType) Compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	return compressors[tp]
+}
+
+// 压缩value，压缩之后没有变小的数据不压缩，返回实际使用的压缩算法
+func compressValue(tp CompressionType, value []byte) ([]byte, CompressionType) {
+	if tp == NoCompression || len(value) == 0 {
+		return value, NoCompression
+	}
+	c := getCompressor(tp)
+	if c == nil {
+		return value, NoCompression
+	}
+	compressed := c.Compress(value)
+	if len(compressed) >= len(value) {
+		return value, NoCompression
+	}
+	return compressed, tp
+}
+
+func decompressValue(tp CompressionType, value []byte) ([]byte, error) {
+	if tp == NoCompression {
+		return value, nil
+	}
+	c := getCompressor(tp)
+	if c == nil {
+		return nil, util.ErrUnknownCompression
+	}
+	return c.Decompress(value)
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(src []byte) []byte {
+	return snappy.Encode(nil, src)
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// zstdCompressor 编码器和解码器创建的开销比较大，第一次使用时再创建，之后并发复用
+type zstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (z *zstdCompressor) init() {
+	z.once.Do(func() {
+		z.encoder, _ = zstd.NewWriter(nil)
+		z.decoder, _ = zstd.NewReader(nil)
+	})
+}
+
+func (z *zstdCompressor) Compress(src []byte) []byte {
+	z.init()
+	return z.encoder.EncodeAll(src, nil)
+}
+
+func (z *zstdCompressor) Decompress(src []byte) ([]byte, error) {
+	z.init()
+	return z.decoder.DecodeAll(src, nil)
+}
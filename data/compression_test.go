@@ -0,0 +1,80 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeLogRecord_Compression(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","type":"kv"}`), 100)
+
+	for _, tp := range []CompressionType{SnappyCompression, ZstdCompression} {
+		rec := &LogRecord{
+			Key:         []byte("name"),
+			Value:       value,
+			Type:        LogRecordNormal,
+			Expire:      1700000000000000000,
+			Compression: tp,
+		}
+		res, size := EncodeLogRecord(rec)
+		assert.True(t, size < int64(len(value)))
+
+		header, _ := DecodeLogRecordHeader(res)
+		assert.Equal(t, tp, header.compression)
+		assert.Equal(t, LogRecordNormal, header.recordType)
+		assert.Equal(t, rec.Expire, header.expire)
+
+		decoded, err := DecodeLogRecord(res)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decoded.Value)
+		assert.Equal(t, tp, decoded.Compression)
+	}
+
+	//压缩之后没有变小的数据不压缩
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("v"), Compression: ZstdCompression}
+	res, _ := EncodeLogRecord(rec)
+	header, _ := DecodeLogRecordHeader(res)
+	assert.Equal(t, NoCompression, header.compression)
+	decoded, err := DecodeLogRecord(res)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), decoded.Value)
+}
+
+type halfCompressor struct{}
+
+func (halfCompressor) Compress(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2)
+	for i := 0; i < len(src); i += 2 {
+		dst = append(dst, src[i])
+	}
+	return dst
+}
+
+func (halfCompressor) Decompress(src []byte) ([]byte, error) {
+	return nil, errors.New("cannot decompress")
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("aabbccdd"), Compression: CustomCompression}
+
+	//没有注册时不压缩
+	res, _ := EncodeLogRecord(rec)
+	decoded, err := DecodeLogRecord(res)
+	assert.Nil(t, err)
+	assert.Equal(t, NoCompression, decoded.Compression)
+
+	RegisterCompressor(halfCompressor{})
+	defer func() {
+		compressorsLock.Lock()
+		delete(compressors, CustomCompression)
+		compressorsLock.Unlock()
+	}()
+	res, _ = EncodeLogRecord(rec)
+	header, _ := DecodeLogRecordHeader(res)
+	assert.Equal(t, CustomCompression, header.compression)
+	_, err = DecodeLogRecord(res)
+	assert.NotNil(t, err)
+}
@@ -2,18 +2,19 @@ package data
 
 import (
 	"Bitcask_go/fio"
-	"Bitcask_go/util"
 	"fmt"
-	"hash/crc32"
 	"io"
 	"path/filepath"
 )
 
 const (
 	DataFileSuffix   = ".data"
+	HintFileSuffix   = ".hint" //每个封存的数据文件对应的hint文件
 	HintFileName     = "hint-index"
 	MergeFinFileName = "merge-finished"
 	SeqNoFileName    = "sequence-number"
+	//每个用过的密钥加密的一条已知记录，用于打开DB时校验密钥
+	EncryptionCheckFileName = "encryption-check"
 )
 
 // DataFile 数据文件
@@ -21,6 +22,7 @@ type DataFile struct {
 	Fid         uint32
 	WriteOffset int64
 	IOManager   fio.IOManager
+	Cipher      *Cipher //不为空时写入的hint记录会被加密，读取时用来解密加密的记录
 }
 
 // 打开指定路径的数据文件
@@ -29,6 +31,15 @@ func OpenDataFile(dirPath string, fid uint32, ioType fio.FileIOType) (*DataFile,
 	return NewDataFile(fileName, fid, ioType)
 }
 
+// OpenActiveDataFile 打开作为活跃文件写入的数据文件，可读写的内存映射会把文件预先分配到preallocSize
+func OpenActiveDataFile(dirPath string, fid uint32, ioType fio.FileIOType, preallocSize int64) (*DataFile, error) {
+	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, fid), ioType, preallocSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DataFile{Fid: fid, IOManager: ioManager}, nil
+}
+
 // 打开Hint索引文件
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
@@ -45,12 +56,22 @@ func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	return NewDataFile(fileName, 0, fio.StandardFIO)
 }
 
+func OpenEncryptionCheckFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, EncryptionCheckFileName)
+	return NewDataFile(fileName, 0, fio.StandardFIO)
+}
+
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileSuffix)
 }
 
+// GetHintFileName 数据文件对应的hint文件名
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileSuffix)
+}
+
 func NewDataFile(fileName string, fid uint32, ioType fio.FileIOType) (*DataFile, error) {
-	ioManager, err := fio.NewIOManager(fileName, ioType)
+	ioManager, err := fio.NewIOManager(fileName, ioType, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +89,10 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Value: EncodeLogRecordPos(pos),
 	}
 
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, _, err := EncodeLogRecordWithCipher(record, df.Cipher)
+	if err != nil {
+		return err
+	}
 	return df.Write(encRecord)
 }
 
@@ -123,40 +147,46 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	logRecordSize := headerSize + keySize + valueSize
+	//记录超出了文件末尾，可能是写入不完整，也可能是header已经损坏
+	if logRecordSize > fileSize-offset {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
-	logRecord := &LogRecord{Type: header.recordType}
-
+	var kvData []byte
 	if keySize > 0 || valueSize > 0 {
-		kvData, err := df.readBytes(keySize+valueSize, offset+headerSize)
+		kvData, err = df.readBytes(keySize+valueSize, offset+headerSize)
 		if err != nil {
 			return nil, 0, err
 		}
-
-		logRecord.Key = kvData[:keySize]
-		logRecord.Value = kvData[keySize:]
 	}
-	//fmt.Printf("[ReadLogRecord], header size:%d, keySize:%d, valueSize:%d, key:%s, value:%s\n", headerSize, keySize, valueSize, string(logRecord.Key), string(logRecord.Value))
-	//检验数据有效性, 注意headerBuf是按最大长度取得，所以我们这里要取有效部分
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
-	if crc != header.crc {
-		//fmt.Printf("crc:%d, header crc:%d", crc, header.crc)
-		return nil, 0, util.ErrInvalidCRC
+
+	//注意headerBuf是按最大长度取得，所以我们这里要取有效部分
+	//记录的长度已经确定，校验、解密失败时也返回长度，调用方可以选择跳过这条记录
+	logRecord, err := decodeLogRecordBody(header, headerBuf[:headerSize], kvData, df.Cipher)
+	if err != nil {
+		return nil, logRecordSize, err
 	}
 
 	return logRecord, logRecordSize, nil
 }
 
+// ReadRawLogRecord 读取一条记录编码之后的原始数据，不解密也不解压，size为 ReadLogRecord 返回的记录长度
+func (df *DataFile) ReadRawLogRecord(offset, size int64) ([]byte, error) {
+	return df.readBytes(size, offset)
+}
+
 func (df *DataFile) readBytes(n, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
 	_, err = df.IOManager.Read(b, offset)
 	return
 }
 
-func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
+// SetIOManager 关闭当前的IOManager，使用新的IO类型重新打开数据文件
+func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType, preallocSize int64) error {
 	if err := df.IOManager.Close(); err != nil {
 		return err
 	}
-	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.Fid), ioType)
+	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.Fid), ioType, preallocSize)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"Bitcask_go/util"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+)
+
+// type 字节中第4位表示记录的key和value是否加密，加密的记录在header中会多一个密钥id
+const logRecordEncryptedFlag byte = 1 << 4
+
+// 加密之后的数据格式为 nonce(12) + 密文 + tag(16)
+const (
+	encryptionNonceSize = 12
+	encryptionOverhead  = encryptionNonceSize + 16
+)
+
+// KeyProvider 提供加密使用的密钥，和 config.KeyProvider 一致
+type KeyProvider interface {
+	CurrentKeyId() uint32
+	Key(id uint32) ([]byte, error)
+}
+
+// Cipher 使用AES-GCM加密记录的key和value，header中的明文部分作为附加数据参与认证
+type Cipher struct {
+	provider KeyProvider
+	mu       *sync.RWMutex
+	aeads    map[uint32]cipher.AEAD //密钥id -> 创建好的AEAD
+}
+
+// NewCipher 创建Cipher，provider为空表示不加密，返回nil
+func NewCipher(provider KeyProvider) *Cipher {
+	if provider == nil {
+		return nil
+	}
+	return &Cipher{
+		provider: provider,
+		mu:       new(sync.RWMutex),
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+}
+
+// CurrentKeyId 新写入的数据使用的密钥id
+func (c *Cipher) CurrentKeyId() uint32 {
+	return c.provider.CurrentKeyId()
+}
+
+func (c *Cipher) aead(keyId uint32) (cipher.AEAD, error) {
+	c.mu.RLock()
+	aead, ok := c.aeads[keyId]
+	c.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := c.provider.Key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.aeads[keyId] = aead
+	c.mu.Unlock()
+	return aead, nil
+}
+
+// 加密明文，返回 nonce + 密文 + tag
+func (c *Cipher) seal(keyId uint32, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := c.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, encryptionNonceSize, encryptionOverhead+len(plaintext))
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *Cipher) open(keyId uint32, ciphertext, additionalData []byte) ([]byte, error) {
+	if c == nil {
+		return nil, util.ErrEncryptionKeyRequired
+	}
+	if len(ciphertext) < encryptionOverhead {
+		return nil, util.ErrWrongEncryptionKey
+	}
+	aead, err := c.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:encryptionNonceSize], ciphertext[encryptionNonceSize:], additionalData)
+	if err != nil {
+		return nil, util.ErrWrongEncryptionKey
+	}
+	return plaintext, nil
+}
+
+// Encrypt 使用当前密钥加密，返回 密钥id(4 bytes) + nonce + 密文 + tag，用于数据文件之外需要加密保存的数据
+func (c *Cipher) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	keyId := c.CurrentKeyId()
+	sealed, err := c.seal(keyId, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4+len(sealed))
+	binary.LittleEndian.PutUint32(buf, keyId)
+	copy(buf[4:], sealed)
+	return buf, nil
+}
+
+// Decrypt 解密 Encrypt 返回的数据
+func (c *Cipher) Decrypt(buf, additionalData []byte) ([]byte, error) {
+	if len(buf) < 4 {
+		return nil, util.ErrWrongEncryptionKey
+	}
+	return c.open(binary.LittleEndian.Uint32(buf), buf[4:], additionalData)
+}
+
+// EncryptedKeyId 返回 Encrypt 加密时使用的密钥id
+func EncryptedKeyId(buf []byte) uint32 {
+	if len(buf) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(buf)
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"Bitcask_go/fio"
+	"Bitcask_go/util"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (p *testKeyProvider) CurrentKeyId() uint32 {
+	return p.current
+}
+
+func (p *testKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, util.ErrEncryptionKeyNotFound
+	}
+	return key, nil
+}
+
+func TestEncodeLogRecord_Encryption(t *testing.T) {
+	provider := &testKeyProvider{current: 7, keys: map[uint32][]byte{7: bytes.Repeat([]byte("k"), 32)}}
+	c := NewCipher(provider)
+
+	rec := &LogRecord{
+		Key:         []byte("name"),
+		Value:       bytes.Repeat([]byte("bitcask-go"), 100),
+		Type:        LogRecordNormal,
+		Expire:      1700000000000000000,
+		Compression: SnappyCompression,
+	}
+	res, size, err := EncodeLogRecordWithCipher(rec, c)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(res)), size)
+	assert.False(t, bytes.Contains(res, []byte("name")))
+	assert.False(t, bytes.Contains(res, []byte("bitcask-go")))
+
+	header, _ := DecodeLogRecordHeader(res)
+	assert.True(t, header.encrypted)
+	assert.Equal(t, uint32(7), header.keyId)
+	assert.Equal(t, LogRecordNormal, header.recordType)
+	assert.Equal(t, rec.Expire, header.expire)
+	assert.Equal(t, SnappyCompression, header.compression)
+
+	decoded, err := DecodeLogRecordWithCipher(res, c)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Key, decoded.Key)
+	assert.Equal(t, rec.Value, decoded.Value)
+
+	//没有密钥
+	_, err = DecodeLogRecord(res)
+	assert.Equal(t, util.ErrEncryptionKeyRequired, err)
+
+	//密钥错误
+	wrong := NewCipher(&testKeyProvider{current: 7, keys: map[uint32][]byte{7: bytes.Repeat([]byte("x"), 32)}})
+	_, err = DecodeLogRecordWithCipher(res, wrong)
+	assert.Equal(t, util.ErrWrongEncryptionKey, err)
+
+	//未加密的记录不受cipher影响
+	plain, _ := EncodeLogRecord(rec)
+	decoded, err = DecodeLogRecordWithCipher(plain, c)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Value, decoded.Value)
+}
+
+func TestDataFile_ReadLogRecord_Encryption(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-encryption")
+	defer os.RemoveAll(dir)
+	provider := &testKeyProvider{current: 1, keys: map[uint32][]byte{1: bytes.Repeat([]byte("a"), 16)}}
+	c := NewCipher(provider)
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	dataFile.Cipher = c
+
+	rec1 := &LogRecord{Key: []byte("key-1"), Value: []byte("value-1")}
+	enc1, size1, err := EncodeLogRecordWithCipher(rec1, c)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(enc1))
+
+	//轮换密钥之后写入的记录使用新的密钥
+	provider.keys[2] = bytes.Repeat([]byte("b"), 16)
+	provider.current = 2
+	rec2 := &LogRecord{Key: []byte("key-2"), Value: []byte("value-2"), Type: LogRecordDeleted}
+	enc2, _, err := EncodeLogRecordWithCipher(rec2, c)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(enc2))
+
+	res1, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1.Value, res1.Value)
+	res2, _, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, rec2.Key, res2.Key)
+	assert.Equal(t, LogRecordDeleted, res2.Type)
+
+	//hint记录也会加密
+	hintFile, err := OpenHintFile(dir)
+	assert.Nil(t, err)
+	hintFile.Cipher = c
+	pos := &LogRecordPos{Fid: 3, Offset: 100, Size: 20}
+	assert.Nil(t, hintFile.WriteHintRecord([]byte("hint-key"), pos))
+	hint, _, err := hintFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, pos.Offset, DecodeLogRecordPos(hint.Value).Offset)
+	hintFile.Cipher = nil
+	_, _, err = hintFile.ReadLogRecord(0)
+	assert.Equal(t, util.ErrEncryptionKeyRequired, err)
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"Bitcask_go/util"
 	"encoding/binary"
 	"hash/crc32"
+	"time"
 )
 
 type LogRecordType = byte
@@ -13,21 +15,32 @@ const (
 	LogRecordFinished
 )
 
-// crc32(4) type(1) KeySize(5) ValueSize(5) = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 4 + 1
+// type 字节的最高位表示header中是否带有过期时间，没有过期时间的记录和旧格式保持一致
+const logRecordExpireFlag byte = 1 << 7
+
+// crc32(4) type(1) KeySize(5) ValueSize(5) Expire(10) KeyId(5) = 30
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*3 + 4 + 1 + binary.MaxVarintLen64
 
 // LogRecord 表示一次数据记录，采用追加写，类似日志
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 //过期时间(UnixNano)，0表示永不过期
+	//写入时表示value希望使用的压缩算法，读取时表示value在磁盘中实际使用的压缩算法
+	//Value本身始终是未压缩的数据
+	Compression CompressionType
 }
 
 type LogRecordHeader struct {
-	crc        uint32
-	recordType LogRecordType
-	keySize    uint32
-	valueSize  uint32
+	crc         uint32
+	recordType  LogRecordType
+	keySize     uint32
+	valueSize   uint32
+	expire      int64
+	compression CompressionType
+	encrypted   bool   //key和value是否加密
+	keyId       uint32 //加密使用的密钥id
 }
 
 // LogRecordPos 用于内存中的索引，可以用来索引到磁盘中具体的文件以及所在的文件的偏移位置
@@ -35,6 +48,17 @@ type LogRecordPos struct {
 	Fid    uint32 //表示数据存储在哪个文件中
 	Offset int64  //表示数据存储在文件中的偏移量
 	Size   uint32 //表示数据的大小
+	Expire int64  //过期时间(UnixNano)，0表示永不过期
+}
+
+// IsExpired 判断位置信息对应的数据是否已经过期
+func (pos *LogRecordPos) IsExpired() bool {
+	return IsExpired(pos.Expire)
+}
+
+// IsExpired 判断过期时间是否已经到达，0表示永不过期
+func IsExpired(expire int64) bool {
+	return expire > 0 && time.Now().UnixNano() >= expire
 }
 
 // TransactionRecord 用于在数据库启动后加载索引时暂存没有确定是否完成的事务项
@@ -44,27 +68,59 @@ type TransactionRecord struct {
 }
 
 // 将LogRecord序列化成字节数组
-// |<-------------Header(Max-20Bytes)------------------|
-// +------------+------------+------------+------------+------------+------------+
-// |    crc     |    type    |  key size  | value size |   key data | value data |
-// +------------+------------+------------+------------+------------+------------+
+// |<-------------------------Header(Max-30Bytes)----------------------------------|
+// +------------+------------+------------+------------+------------+------------+------------+------------+
+// |    crc     |    type    |  key size  | value size |   expire   |   key id   |   key data | value data |
+// +------------+------------+------------+------------+------------+------------+------------+------------+
 //
-//	4Bytes        1Bytes     Max-5Bytes  Max-5Bytes
+//	4Bytes        1Bytes     Max-5Bytes  Max-5Bytes   Max-10Bytes(可选) Max-5Bytes(可选)
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	//不加密时不会出错
+	encRecord, size, _ := EncodeLogRecordWithCipher(logRecord, nil)
+	return encRecord, size
+}
+
+// EncodeLogRecordWithCipher 将LogRecord序列化成字节数组，cipher不为空时加密key和value
+// 加密之后 key size 仍然是明文key的长度，value size 是剩下的密文长度
+func EncodeLogRecordWithCipher(logRecord *LogRecord, c *Cipher) ([]byte, int64, error) {
 	//由于key和value都是byte array，我们只用编码header即可
 	header := make([]byte, maxLogRecordHeaderSize)
 
+	//压缩value，value size记录的是压缩之后的大小
+	value, compression := compressValue(logRecord.Compression, logRecord.Value)
+	keySize, valueSize := len(logRecord.Key), len(value)
+
+	var keyId uint32
+	if c != nil {
+		keyId = c.CurrentKeyId()
+		valueSize += encryptionOverhead
+	}
+
 	var index int = 4
-	header[index] = logRecord.Type
+	header[index] = logRecord.Type | compression<<logRecordCompressionShift
+	if logRecord.Expire > 0 {
+		header[index] |= logRecordExpireFlag
+	}
+	if c != nil {
+		header[index] |= logRecordEncryptedFlag
+	}
 	index++
 
 	//放置key size
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
+	index += binary.PutVarint(header[index:], int64(keySize))
 	//放置value size
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	index += binary.PutVarint(header[index:], int64(valueSize))
+	//放置过期时间
+	if logRecord.Expire > 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+	//放置密钥id
+	if c != nil {
+		index += binary.PutUvarint(header[index:], uint64(keyId))
+	}
 
 	//到此，index就是header的长度
-	var totalSize = index + len(logRecord.Key) + len(logRecord.Value)
+	var totalSize = index + keySize + valueSize
 
 	encryptBytes := make([]byte, totalSize)
 
@@ -72,16 +128,28 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	//copy(dst, src)
 	copy(encryptBytes[:index], header[:index])
 
-	//将key和value分别放进去
-	copy(encryptBytes[index:], logRecord.Key)
-	copy(encryptBytes[index+len(logRecord.Key):], logRecord.Value)
+	if c != nil {
+		//key和value一起加密，header作为附加数据，被篡改之后无法解密
+		plaintext := make([]byte, keySize+len(value))
+		copy(plaintext, logRecord.Key)
+		copy(plaintext[keySize:], value)
+		sealed, err := c.seal(keyId, plaintext, header[4:index])
+		if err != nil {
+			return nil, 0, err
+		}
+		copy(encryptBytes[index:], sealed)
+	} else {
+		//将key和value分别放进去
+		copy(encryptBytes[index:], logRecord.Key)
+		copy(encryptBytes[index+keySize:], value)
+	}
 
 	//对整个数据进行crc校验，然后放到前四个字节中
 	crc := crc32.ChecksumIEEE(encryptBytes[4:])
 	binary.LittleEndian.PutUint32(encryptBytes[:4], crc)
 
 	//fmt.Printf("header size:%d, crc:%d, keySize:%d, valueSize:%d, key:%s, value:%s\n", index, crc, len(logRecord.Key), len(logRecord.Value), string(logRecord.Key), string(logRecord.Value))
-	return encryptBytes, int64(totalSize)
+	return encryptBytes, int64(totalSize), nil
 }
 
 // 解码LogRecord的头部
@@ -99,18 +167,108 @@ func DecodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 
 	//读取可变长度key size
 	keyLen, kSize := binary.Varint(buf[index:])
+	if kSize <= 0 {
+		return nil, 0
+	}
 	//读取可变长度value size
 	valueLen, vSize := binary.Varint(buf[index+uint32(kSize):])
+	//数据不完整或者已经损坏
+	if vSize <= 0 || keyLen < 0 || valueLen < 0 {
+		return nil, 0
+	}
 
 	var headerSize = int(index) + kSize + vSize
+
+	//读取可选的过期时间
+	var expire int64
+	if tp&logRecordExpireFlag != 0 {
+		var eSize int
+		expire, eSize = binary.Varint(buf[headerSize:])
+		if eSize <= 0 {
+			return nil, 0
+		}
+		headerSize += eSize
+	}
+
+	//读取可选的密钥id
+	var keyId uint64
+	if tp&logRecordEncryptedFlag != 0 {
+		var idSize int
+		keyId, idSize = binary.Uvarint(buf[headerSize:])
+		if idSize <= 0 {
+			return nil, 0
+		}
+		headerSize += idSize
+	}
+
 	return &LogRecordHeader{
-		crc:        crcVal,
-		recordType: tp,
-		keySize:    uint32(keyLen),
-		valueSize:  uint32(valueLen),
+		crc:         crcVal,
+		recordType:  tp &^ (logRecordExpireFlag | logRecordCompressionMask | logRecordEncryptedFlag),
+		keySize:     uint32(keyLen),
+		valueSize:   uint32(valueLen),
+		expire:      expire,
+		compression: (tp & logRecordCompressionMask) >> logRecordCompressionShift,
+		encrypted:   tp&logRecordEncryptedFlag != 0,
+		keyId:       uint32(keyId),
 	}, int64(headerSize)
 }
 
+// DecodeLogRecord 从一段完整的编码数据中解码出LogRecord，并校验crc
+func DecodeLogRecord(buf []byte) (*LogRecord, error) {
+	return DecodeLogRecordWithCipher(buf, nil)
+}
+
+// DecodeLogRecordWithCipher 解码一段完整的编码数据，加密的记录使用cipher解密
+func DecodeLogRecordWithCipher(buf []byte, c *Cipher) (*LogRecord, error) {
+	header, headerSize := DecodeLogRecordHeader(buf)
+	if header == nil || headerSize <= 5 || headerSize > int64(len(buf)) {
+		return nil, util.ErrInvalidCRC
+	}
+
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	if headerSize+keySize+valueSize != int64(len(buf)) {
+		return nil, util.ErrInvalidCRC
+	}
+
+	return decodeLogRecordBody(header, buf[:headerSize], buf[headerSize:], c)
+}
+
+// 校验crc之后依次解密、解压，得到原始的key和value
+// headerBuf是完整的header，kvData是header之后的key和value部分
+func decodeLogRecordBody(header *LogRecordHeader, headerBuf, kvData []byte, c *Cipher) (*LogRecord, error) {
+	keySize := int64(header.keySize)
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire}
+	if len(kvData) > 0 {
+		logRecord.Key = kvData[:keySize]
+		logRecord.Value = kvData[keySize:]
+	}
+
+	//检验数据有效性，crc校验的是压缩和加密之后的数据
+	if getLogRecordCRC(logRecord, headerBuf[crc32.Size:]) != header.crc {
+		return nil, util.ErrInvalidCRC
+	}
+
+	if header.encrypted {
+		plaintext, err := c.open(header.keyId, kvData, headerBuf[crc32.Size:])
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(plaintext)) < keySize {
+			return nil, util.ErrWrongEncryptionKey
+		}
+		logRecord.Key = plaintext[:keySize]
+		logRecord.Value = plaintext[keySize:]
+	}
+
+	value, err := decompressValue(header.compression, logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	logRecord.Value = value
+	logRecord.Compression = header.compression
+	return logRecord, nil
+}
+
 func getLogRecordCRC(logRecord *LogRecord, header []byte) uint32 {
 	if logRecord == nil {
 		return 0
@@ -124,12 +282,13 @@ func getLogRecordCRC(logRecord *LogRecord, header []byte) uint32 {
 
 // 对位置索引信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
-	return buf
+	index += binary.PutVarint(buf[index:], pos.Expire)
+	return buf[:index]
 }
 
 // 解码位置索引信息
@@ -139,11 +298,18 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
+	size, n := binary.Varint(buf[index:])
+	index += n
+	//旧版本编码的位置信息中没有过期时间
+	var expire int64
+	if index < len(buf) {
+		expire, _ = binary.Varint(buf[index:])
+	}
 	return &LogRecordPos{
 		Fid:    uint32(fid),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
 	}
 
 }
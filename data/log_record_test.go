@@ -111,3 +111,66 @@ func TestDecodeLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+
+	res, size := EncodeLogRecord(rec)
+	assert.Equal(t, int64(len(res)), size)
+
+	header, headerSize := DecodeLogRecordHeader(res)
+	assert.NotNil(t, header)
+	assert.Equal(t, LogRecordNormal, header.recordType)
+	assert.Equal(t, rec.Expire, header.expire)
+	assert.Equal(t, uint32(4), header.keySize)
+	assert.Equal(t, uint32(10), header.valueSize)
+	assert.Equal(t, size, headerSize+4+10)
+
+	crc := getLogRecordCRC(rec, res[crc32.Size:headerSize])
+	assert.Equal(t, header.crc, crc)
+}
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 3, Offset: 1024, Size: 88, Expire: 1700000000000000000}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+
+	//旧版本的位置信息没有过期时间
+	oldPos := DecodeLogRecordPos([]byte{6, 128, 16, 176, 1, 0, 0, 0})
+	assert.Equal(t, &LogRecordPos{Fid: 3, Offset: 1024, Size: 88}, oldPos)
+}
+
+func TestDecodeLogRecord(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, _ := EncodeLogRecord(rec)
+	decoded, err := DecodeLogRecord(res)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, decoded)
+
+	//删除记录没有value
+	rec = &LogRecord{Key: []byte("name"), Type: LogRecordDeleted}
+	res, _ = EncodeLogRecord(rec)
+	decoded, err = DecodeLogRecord(res)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Key, decoded.Key)
+	assert.Equal(t, 0, len(decoded.Value))
+	assert.Equal(t, LogRecordDeleted, decoded.Type)
+
+	//数据被篡改或者不完整
+	res[len(res)-1] ^= 0xff
+	_, err = DecodeLogRecord(res)
+	assert.NotNil(t, err)
+	_, err = DecodeLogRecord(res[:len(res)-1])
+	assert.NotNil(t, err)
+	_, err = DecodeLogRecord(nil)
+	assert.NotNil(t, err)
+}
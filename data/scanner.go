@@ -0,0 +1,144 @@
+package data
+
+import (
+	"Bitcask_go/fio"
+	"io"
+)
+
+// 支持批量读取时每个读取请求的大小
+const scanReadChunkSize = 256 * 1024
+
+// LogRecordScanner 按顺序遍历数据文件中的记录
+// 每次从文件中读取一大块数据再从内存中解码，避免逐条记录读取header和数据时的大量小IO
+type LogRecordScanner struct {
+	df        *DataFile
+	fileSize  int64
+	buf       []byte
+	bufOffset int64 //buf[0]在文件中的位置
+	bufLen    int   //buf中有效数据的长度
+	offset    int64 //下一条记录在文件中的位置
+}
+
+// NewScanner 创建从文件开头遍历的scanner，bufSize是每次读取的字节数
+func (df *DataFile) NewScanner(bufSize int) (*LogRecordScanner, error) {
+	fileSize, err := df.IOManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if bufSize < maxLogRecordHeaderSize {
+		bufSize = maxLogRecordHeaderSize
+	}
+	if int64(bufSize) > fileSize && fileSize > maxLogRecordHeaderSize {
+		bufSize = int(fileSize)
+	}
+	return &LogRecordScanner{df: df, fileSize: fileSize, buf: make([]byte, bufSize)}, nil
+}
+
+// Offset 下一条记录在文件中的位置，遇到错误时就是出错的记录所在的位置
+func (s *LogRecordScanner) Offset() int64 {
+	return s.offset
+}
+
+// Next 读取下一条记录，返回记录在文件中的位置和大小
+// 错误的含义和 ReadLogRecord 相同，读到文件末尾时返回io.EOF；返回的记录不会引用scanner内部的缓冲区
+func (s *LogRecordScanner) Next() (*LogRecord, int64, int64, error) {
+	offset := s.offset
+	if offset >= s.fileSize {
+		return nil, offset, 0, io.EOF
+	}
+
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if headerBytes > s.fileSize-offset {
+		headerBytes = s.fileSize - offset
+	}
+	headerBuf, err := s.peek(offset, headerBytes)
+	if err != nil {
+		return nil, offset, 0, err
+	}
+
+	header, headerSize := DecodeLogRecordHeader(headerBuf)
+	if header == nil {
+		return nil, offset, 0, io.EOF
+	}
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return nil, offset, 0, io.EOF
+	}
+
+	logRecordSize := headerSize + int64(header.keySize) + int64(header.valueSize)
+	if logRecordSize > s.fileSize-offset {
+		return nil, offset, 0, io.ErrUnexpectedEOF
+	}
+	recordBuf, err := s.peek(offset, logRecordSize)
+	if err != nil {
+		return nil, offset, 0, err
+	}
+
+	//解码之后的key和value会引用kvData，这里拷贝一份
+	kvData := make([]byte, logRecordSize-headerSize)
+	copy(kvData, recordBuf[headerSize:])
+	logRecord, err := decodeLogRecordBody(header, recordBuf[:headerSize], kvData, s.df.Cipher)
+	if err != nil {
+		return nil, offset, logRecordSize, err
+	}
+	s.offset += logRecordSize
+	return logRecord, offset, logRecordSize, nil
+}
+
+// 返回文件中从offset开始的n个字节，缓冲区中的数据不够时从文件中继续读取
+func (s *LogRecordScanner) peek(offset, n int64) ([]byte, error) {
+	start := offset - s.bufOffset
+	if start+n <= int64(s.bufLen) {
+		return s.buf[start : start+n], nil
+	}
+
+	//丢弃已经解码过的数据，剩下的移动到缓冲区开头
+	remain := copy(s.buf, s.buf[start:s.bufLen])
+	s.bufOffset = offset
+	s.bufLen = remain
+	if n > int64(len(s.buf)) {
+		buf := make([]byte, n)
+		copy(buf, s.buf[:remain])
+		s.buf = buf
+	}
+
+	readSize := int64(len(s.buf) - s.bufLen)
+	if left := s.fileSize - (s.bufOffset + int64(s.bufLen)); readSize > left {
+		readSize = left
+	}
+	if readSize > 0 {
+		readN, err := s.fill(s.buf[s.bufLen:int64(s.bufLen)+readSize], s.bufOffset+int64(s.bufLen))
+		s.bufLen += readN
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	if n > int64(s.bufLen) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return s.buf[:n], nil
+}
+
+// 从文件的offset位置读满b，支持批量读取的IOManager把b分成多段一次提交，由内核并行读取
+func (s *LogRecordScanner) fill(b []byte, offset int64) (int, error) {
+	if _, ok := s.df.IOManager.(fio.BatchReader); !ok || len(b) <= scanReadChunkSize {
+		return s.df.IOManager.Read(b, offset)
+	}
+
+	reqs := make([]fio.ReadRequest, 0, (len(b)+scanReadChunkSize-1)/scanReadChunkSize)
+	for start := 0; start < len(b); start += scanReadChunkSize {
+		end := min(start+scanReadChunkSize, len(b))
+		reqs = append(reqs, fio.ReadRequest{Buf: b[start:end], Offset: offset + int64(start)})
+	}
+	if err := fio.ReadBatch(s.df.IOManager, reqs); err != nil {
+		return 0, err
+	}
+	//只返回从开头连续读到的部分
+	var n int
+	for _, req := range reqs {
+		n += req.N
+		if req.Err != nil || req.N < len(req.Buf) {
+			return n, req.Err
+		}
+	}
+	return n, nil
+}
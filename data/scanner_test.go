@@ -0,0 +1,96 @@
+package data
+
+import (
+	"Bitcask_go/fio"
+	"Bitcask_go/util"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRecordScanner(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-scanner")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+
+	var records []*LogRecord
+	for i := 0; i < 200; i++ {
+		rec := &LogRecord{Key: util.GetTestKey(i), Value: util.RandomValue(i * 3)}
+		if i%10 == 0 {
+			rec.Type = LogRecordDeleted
+			rec.Value = nil
+		}
+		enc, _ := EncodeLogRecord(rec)
+		assert.Nil(t, dataFile.Write(enc))
+		records = append(records, rec)
+	}
+
+	//缓冲区比部分记录还小，记录会跨越多次读取
+	scanner, err := dataFile.NewScanner(64)
+	assert.Nil(t, err)
+	var offset int64
+	for i := 0; ; i++ {
+		rec, recOffset, size, err := scanner.Next()
+		if err == io.EOF {
+			assert.Equal(t, len(records), i)
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, offset, recOffset)
+		expected, expectedSize, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedSize, size)
+		assert.Equal(t, expected.Key, rec.Key)
+		assert.Equal(t, expected.Value, rec.Value)
+		assert.Equal(t, records[i].Type, rec.Type)
+		offset += size
+	}
+
+	//末尾不完整的记录
+	enc, _ := EncodeLogRecord(&LogRecord{Key: []byte("torn"), Value: util.RandomValue(100)})
+	assert.Nil(t, dataFile.Write(enc[:len(enc)-10]))
+	scanner, err = dataFile.NewScanner(4096)
+	assert.Nil(t, err)
+	for {
+		_, recOffset, _, err := scanner.Next()
+		if err != nil {
+			assert.Equal(t, io.ErrUnexpectedEOF, err)
+			assert.Equal(t, offset, recOffset)
+			assert.Equal(t, offset, scanner.Offset())
+			break
+		}
+	}
+}
+
+func TestLogRecordScanner_BatchRead(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-scanner-batch")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.IOUringFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	var records []*LogRecord
+	for i := 0; i < 100; i++ {
+		rec := &LogRecord{Key: util.GetTestKey(i), Value: util.RandomValue(40 * 1024)}
+		enc, _ := EncodeLogRecord(rec)
+		assert.Nil(t, dataFile.Write(enc))
+		records = append(records, rec)
+	}
+
+	//缓冲区超过单个读取请求的大小，每次读取分成多个请求
+	scanner, err := dataFile.NewScanner(4 * 1024 * 1024)
+	assert.Nil(t, err)
+	for i := 0; ; i++ {
+		rec, _, _, err := scanner.Next()
+		if err == io.EOF {
+			assert.Equal(t, len(records), i)
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, records[i].Key, rec.Key)
+		assert.Equal(t, records[i].Value, rec.Value)
+	}
+}
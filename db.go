@@ -1,19 +1,20 @@
 package Bitcask_go
 
 import (
+	"Bitcask_go/cache"
 	"Bitcask_go/config"
 	"Bitcask_go/data"
 	"Bitcask_go/fio"
 	"Bitcask_go/index"
 	"Bitcask_go/util"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/flock"
 )
@@ -37,14 +38,23 @@ type DB struct {
 	fileLock        *flock.Flock              //文件锁，保证当前数据
 	bytesWrite      uint                      //累计写了多少个字节
 	reclaimSize     int64                     //表示DB中有多少数据是无效的
+	oracle          *txnOracle                //记录最近被修改的key，用于乐观事务的冲突检测
+	watchers        *watchHub                 //通过Watch订阅数据变更的订阅者
+	cipher          *data.Cipher              //加密数据文件使用的cipher，为空表示不加密
+	hintWriters     *sync.WaitGroup           //后台为封存的数据文件生成hint文件的协程
+	commitQueue     *commitQueue              //等待group commit的写入
+	periodicSyncer  *periodicSyncer           //按照SyncInterval定期同步的后台协程，为空表示没有启动
+	valueCache      *cache.LRUCache           //按记录位置缓存读取到的value，为空表示不缓存
 }
 
 // Stat 存储引擎统计信息
 type Stat struct {
-	KeyNum          uint  //key的总数量
-	DataFileNum     uint  //数据文件的总数量
-	ReclaimableSize int64 //merge后可回收的数据大小，单位byte
-	DiskSize        int64 //数据引擎占据磁盘大小
+	KeyNum          uint   //key的总数量
+	DataFileNum     uint   //数据文件的总数量
+	ReclaimableSize int64  //merge后可回收的数据大小，单位byte
+	DiskSize        int64  //数据引擎占据磁盘大小
+	CacheHits       uint64 //value缓存命中的次数
+	CacheMisses     uint64 //value缓存没有命中的次数
 }
 
 // 通过配置项构造一个DB
@@ -78,8 +88,8 @@ func Open(cfg config.Configuration) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	//如果目录存在但里面为空，也视为第一次使用
-	if len(entries) == 0 {
+	//如果目录存在但里面为空(只有刚创建的文件锁)，也视为第一次使用
+	if len(entries) == 0 || (len(entries) == 1 && entries[0].Name() == fileLockName) {
 		isInitial = true
 	}
 
@@ -88,61 +98,123 @@ func Open(cfg config.Configuration) (*DB, error) {
 		mutex:         new(sync.RWMutex),
 		olderFiles:    make(map[uint32]*data.DataFile),
 		configuration: cfg,
-		index:         index.NewIndexer(cfg.IndexerType, cfg.DataDir, cfg.SyncWrites),
 		seqNo:         0,
 		isInitial:     isInitial,
 		fileLock:      fileLock,
+		oracle:        newTxnOracle(),
+		watchers:      newWatchHub(cfg.WatchBufferSize),
+		cipher:        data.NewCipher(cfg.KeyProvider),
+		hintWriters:   new(sync.WaitGroup),
+		commitQueue:   newCommitQueue(),
 	}
+	if cfg.ValueCacheSize > 0 {
+		db.valueCache = cache.NewLRUCache(cfg.ValueCacheSize)
+	}
+	//加密的B+树索引需要先校验密钥，在加载时再打开
+	if db.cipher == nil || cfg.IndexerType != config.BPTree {
+		db.index = index.NewShardedIndexer(cfg.IndexerType, cfg.IndexShards, cfg.DataDir, db.syncIndex())
+	}
+
+	//加载数据，失败时释放已经打开的资源，数据目录可以被再次打开
+	if err := db.load(); err != nil {
+		db.releaseOnOpenFailure()
+		return nil, err
+	}
+	db.startPeriodicSync()
+
+	return db, nil
+}
 
+// 加载merge结果、数据文件并构建索引
+func (db *DB) load() error {
 	// 加载merge数据目录
 	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+		return err
+	}
+
+	//校验配置的密钥是否能解密数据
+	if err := db.checkEncryptionKey(); err != nil {
+		return err
+	}
+	if db.index == nil {
+		bpt, err := index.NewEncryptedBPTree(db.configuration.DataDir, db.syncIndex(), db.cipher)
+		if err != nil {
+			return err
+		}
+		db.index = bpt
 	}
 
 	//从磁盘中加载所有的数据文件
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
 	}
 
 	//如果是B+树索引，不需要从数据文件加载索引了
-	if cfg.IndexerType != config.BPTree {
+	if db.configuration.IndexerType != config.BPTree {
 		//从hint文件中加载索引
 		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+			return err
 		}
 
 		//从数据文件构建索引
 		if err := db.LoadIndexFromDataFiles(); err != nil {
-			return nil, err
+			return err
 		}
 
-		//将文件IO类型重置为标准IO
-		if db.configuration.MMapAtStartup {
-			if err := db.resetIOType(); err != nil {
-				return nil, err
-			}
-		}
 	} else {
 		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+			return err
 		}
 
 		//更新当前活跃数据文件的WriteOffset
 		if db.activeFile != nil {
 			size, err := db.activeFile.IOManager.Size()
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			db.activeFile.WriteOffset = size
 		}
 	}
 
-	return db, nil
+	//将文件IO类型切换为运行时使用的IO类型
+	if db.configuration.MMapAtStartup || db.configuration.FileIOType != config.StandardFIO {
+		if err := db.resetIOType(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// B+树索引是否在每次写入之后同步到磁盘
+func (db *DB) syncIndex() bool {
+	return db.configuration.SyncWrites || db.configuration.SyncPolicy == config.SyncAlways
+}
+
+// Open失败时关闭已经打开的文件并释放文件锁
+func (db *DB) releaseOnOpenFailure() {
+	db.hintWriters.Wait()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, of := range db.olderFiles {
+		_ = of.Close()
+	}
+	if db.index != nil {
+		_ = db.index.Close()
+	}
+	_ = db.fileLock.Unlock()
 }
 
 // Close 关闭DB，释放资源
 func (db *DB) Close() error {
+	//关闭所有订阅者的事件channel
+	db.watchers.closeAll()
+
+	//停止后台同步，关闭活跃文件之前会再同步一次
+	db.periodicSyncer.Stop()
+
 	defer func() {
 		if err := db.fileLock.Unlock(); err != nil {
 			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
@@ -161,6 +233,9 @@ func (db *DB) Close() error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
+	//等待hint文件写完，之后才能关闭数据文件，写hint文件的协程不会获取锁
+	db.hintWriters.Wait()
+
 	//如果是B+树索引，需要将seqNo写入到文件中，后续加载数据文件的话可以从此文件中获取
 	seqNoFile, err := data.OpenSeqNoFile(db.configuration.DataDir)
 	if err != nil {
@@ -173,7 +248,10 @@ func (db *DB) Close() error {
 		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
 	}
 
-	encRecord, _ := data.EncodeLogRecord(record)
+	encRecord, _, err := data.EncodeLogRecordWithCipher(record, db.cipher)
+	if err != nil {
+		return err
+	}
 	if err := seqNoFile.Write(encRecord); err != nil {
 		return err
 	}
@@ -182,7 +260,10 @@ func (db *DB) Close() error {
 		return err
 	}
 
-	//关闭活跃文件
+	//同步并关闭活跃文件
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
 	if err := db.activeFile.Close(); err != nil {
 		return err
 	}
@@ -217,39 +298,112 @@ func (db *DB) Sync() error {
 
 // 写入数据到DB中， key不能为空
 func (db *DB) Put(key, value []byte) error {
+	return db.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL 写入一条带有过期时间的数据，ttl为0表示永不过期
+func (db *DB) PutWithTTL(key, value []byte, ttl time.Duration) error {
 	if len(key) == 0 {
 		return util.ErrKeyIsEmpty
 	}
+	if ttl < 0 {
+		return util.ErrInvalidTTL
+	}
+
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
 
+	//并发的写入合并成一组，由一个协程持有写锁一次写入；写数据文件和更新索引在同一个临界区内完成，保证事务冲突检测能看到这次修改
+	return db.commitWrite(db.syncEveryWrite(), func(g *writeGroup) (func() error, error) {
+		return db.stagePut(g, key, value, expire)
+	})
+}
+
+// 写入数据并更新索引，调用方需要持有写锁
+func (db *DB) putLocked(key, value []byte, expire int64) error {
+	return db.writeLocked(db.syncEveryWrite(), func(g *writeGroup) (func() error, error) {
+		return db.stagePut(g, key, value, expire)
+	})
+}
+
+// 把一条写入记录编码到g的缓冲区中，返回的函数在数据写入活跃文件之后更新内存索引
+func (db *DB) stagePut(g *writeGroup, key, value []byte, expire int64) (func() error, error) {
 	//构造一个LogRecord，准备写入到磁盘的数据文件中
 	log_record := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+
+	pos, err := db.bufferLogRecord(g, log_record)
+	if err != nil {
+		return nil, err
+	}
+	g.setExists(key, true)
+
+	return func() error {
+		//写入到磁盘中之后，更新内存中的索引
+		if oldPos := db.index.Put(key, pos); oldPos != nil {
+			db.reclaimSize += int64(oldPos.Size)
+		}
+		seqNo := db.oracle.recordWrites(key)
+		db.watchers.publish(seqNo, EventPut, key, value)
+		return nil
+	}, nil
+}
+
+// TTL 获取key剩余的存活时间，没有设置过期时间的key返回 -1
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	if len(key) == 0 {
+		return 0, util.ErrKeyIsEmpty
 	}
 
-	// db.mutex.Lock()   //写锁不可重入
-	// defer db.mutex.Unlock()
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
 
-	//追加写入到磁盘的活跃文件中
-	pos, err := db.appendLogRecordWithLock(log_record)
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return 0, util.ErrKeyNotFound
+	}
+	if pos.Expire == 0 {
+		return -1, nil
+	}
+	return time.Duration(pos.Expire - time.Now().UnixNano()), nil
+}
 
-	if err != nil {
-		return err
+// Persist 移除key的过期时间，使其永不过期
+func (db *DB) Persist(key []byte) error {
+	if len(key) == 0 {
+		return util.ErrKeyIsEmpty
 	}
 
-	//写入到磁盘中之后，更新内存中的索引(TODO, 这里会不会写磁盘成功，更新内存失败，造成数据不一致)
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return util.ErrKeyNotFound
+	}
+	if pos.Expire == 0 {
+		return nil
 	}
 
-	return nil
+	value, err := db.GetValueByPosition(pos)
+	if err != nil {
+		return err
+	}
+
+	//重新写入一条没有过期时间的记录
+	return db.putLocked(key, value, 0)
 }
 
 // 从DB中获取数据，key不能为空
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
 
 	if len(key) == 0 {
 		return nil, util.ErrKeyIsEmpty
@@ -262,7 +416,8 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 
 // 根据LogRecordPos获取对应的Value
 func (db *DB) GetValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
-	if pos == nil {
+	//过期的key视为不存在
+	if pos == nil || pos.IsExpired() {
 		return nil, util.ErrKeyNotFound
 	}
 
@@ -280,6 +435,15 @@ func (db *DB) GetValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 		return nil, util.ErrDataFileNotFound
 	}
 
+	//同一个位置的记录不会被修改，merge之后的文件只在打开DB时替换旧文件，这时缓存还是空的
+	//命中缓存时返回value的拷贝，调用方修改返回值不会影响缓存
+	cacheKey := cache.Key{Fid: pos.Fid, Offset: pos.Offset}
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.Get(cacheKey); ok {
+			return append([]byte(nil), value...), nil
+		}
+	}
+
 	log_record, _, err := data_file.ReadLogRecord(pos.Offset)
 	if err != nil {
 		return nil, err
@@ -290,19 +454,23 @@ func (db *DB) GetValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 		return nil, util.ErrKeyNotFound
 	}
 
+	if db.valueCache != nil {
+		db.valueCache.Put(cacheKey, append([]byte(nil), log_record.Value...))
+	}
 	return log_record.Value, nil
 }
 
-// ListKyes 获取所有的key
+// ListKyes 获取所有的key，已经过期的key不会返回
 func (db *DB) ListKeys() [][]byte {
-	keys := make([][]byte, db.index.Size())
+	keys := make([][]byte, 0, db.index.Size())
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 
-	var idx int
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if iterator.Value().IsExpired() {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -315,8 +483,12 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired() {
+			continue
+		}
 		key := iterator.Key()
-		value, err := db.GetValueByPosition(iterator.Value())
+		value, err := db.GetValueByPosition(pos)
 		if err != nil {
 			return err
 		}
@@ -325,7 +497,7 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 			break
 		}
 	}
-	return nil
+	return iterator.Err()
 }
 
 // 删除某条数据
@@ -334,9 +506,23 @@ func (db *DB) Delete(key []byte) error {
 		return util.ErrKeyIsEmpty
 	}
 
+	return db.commitWrite(db.syncEveryWrite(), func(g *writeGroup) (func() error, error) {
+		return db.stageDelete(g, key)
+	})
+}
+
+// 删除数据并更新索引，调用方需要持有写锁
+func (db *DB) deleteLocked(key []byte) error {
+	return db.writeLocked(db.syncEveryWrite(), func(g *writeGroup) (func() error, error) {
+		return db.stageDelete(g, key)
+	})
+}
+
+// 把一条删除记录编码到g的缓冲区中，key不存在时不需要写入，返回的函数为空
+func (db *DB) stageDelete(g *writeGroup, key []byte) (func() error, error) {
 	//如果key不存在，直接返回
-	if pos := db.index.Get(key); pos == nil {
-		return nil
+	if !g.exists(db, key) {
+		return nil, nil
 	}
 
 	//先构造一条删除记录，追加写入DB
@@ -344,35 +530,46 @@ func (db *DB) Delete(key []byte) error {
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted}
 
-	pos, err := db.appendLogRecordWithLock(&logRecord)
+	pos, err := db.bufferLogRecord(g, &logRecord)
 	if err != nil {
-		return util.ErrDataDeleteFailed
-	}
-	//delete这条记录本身也是可以删除的
-	db.reclaimSize += int64(pos.Size)
-
-	//然后删除内存索引中的记录
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
-		return util.ErrDataDeleteFailed
+		return nil, util.ErrDataDeleteFailed
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
-
-	return nil
-}
+	g.setExists(key, false)
 
-// 追加日志记录到活跃文件中 - 有锁版本
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	return func() error {
+		//delete这条记录本身也是可以删除的
+		db.reclaimSize += int64(pos.Size)
 
-	return db.appendLogRecord(logRecord)
+		//然后删除内存索引中的记录
+		oldPos, ok := db.index.Delete(key)
+		if !ok {
+			return util.ErrDataDeleteFailed
+		}
+		if oldPos != nil {
+			db.reclaimSize += int64(oldPos.Size)
+		}
+		seqNo := db.oracle.recordWrites(key)
+		db.watchers.publish(seqNo, EventDelete, key, nil)
+		return nil
+	}, nil
 }
 
 // 追加日志记录到活跃文件中 - 无锁版本
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	g := newWriteGroup()
+	pos, err := db.bufferLogRecord(g, logRecord)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.writeGroupBuffer(g, db.syncEveryWrite()); err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+// 编码日志记录并追加到g的缓冲区中，返回写入活跃文件之后的位置
+// 活跃文件放不下缓冲区中的数据和这条记录时，先把缓冲区中的数据写入当前的活跃文件，再切换到新的活跃文件
+func (db *DB) bufferLogRecord(g *writeGroup, logRecord *data.LogRecord) (*data.LogRecordPos, error) {
 	//判断当前是否有活跃文件，如果没有，则创建一个
 	if db.activeFile == nil {
 		if err := db.setActiveDataFile(); err != nil {
@@ -380,20 +577,34 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	//对数据进行编码，返回byte[]
-	encRecord, len := data.EncodeLogRecord(logRecord)
+	//使用配置的压缩算法压缩value，merge重写旧数据时也会按照当前的配置重新压缩
+	if logRecord.Type == data.LogRecordNormal {
+		logRecord.Compression = db.configuration.Compression
+	}
 
-	//.Print(encRecord) //Debug info
+	//对数据进行编码，返回byte[]，配置了密钥时同时加密
+	encRecord, len, err := data.EncodeLogRecordWithCipher(logRecord, db.cipher)
+	if err != nil {
+		return nil, err
+	}
 
 	//如果写入的文件已经不能够容纳新的记录，则将当前活跃文件关闭，并创建一个新的活跃文件
-	if db.activeFile.WriteOffset+len > db.configuration.DataFileMaxSize {
+	if db.activeFile.WriteOffset+int64(g.size())+len > db.configuration.DataFileMaxSize {
+		if err := db.writeGroupBuffer(g, false); err != nil {
+			return nil, err
+		}
+
 		//将当前活跃文件写入到磁盘中
 		if err := db.activeFile.Sync(); err != nil {
 			return nil, err
 		}
+		g.synced = g.flushes
 
 		//标记位旧文件
 		db.olderFiles[db.activeFile.Fid] = db.activeFile
+		if err := db.sealDataFile(db.activeFile); err != nil {
+			return nil, err
+		}
 
 		//创建新的活跃文件
 		if err := db.setActiveDataFile(); err != nil {
@@ -401,37 +612,42 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	//活跃文件的可写位置偏移
-	writeOff := db.activeFile.WriteOffset
+	//活跃文件的可写位置偏移，缓冲区中的数据会先写入
+	pos := &data.LogRecordPos{
+		Fid:    db.activeFile.Fid,
+		Offset: db.activeFile.WriteOffset + int64(g.size()),
+		Size:   uint32(len),
+		Expire: logRecord.Expire,
+	}
+	g.buf = append(g.buf, encRecord...)
+	return pos, nil
+}
 
-	//将我们编码后的字节数组写入到活跃文件中(不够放，怎么办？)
-	if err := db.activeFile.Write(encRecord); err != nil {
-		return nil, err
+// 把缓冲区中的数据一次写入活跃文件，sync为true或者累计写入的字节数达到阈值时同步到磁盘
+func (db *DB) writeGroupBuffer(g *writeGroup, sync bool) error {
+	if g.size() > 0 {
+		if err := db.activeFile.Write(g.buf); err != nil {
+			return err
+		}
+		db.bytesWrite += uint(g.size())
+		g.buf = g.buf[:0]
+		g.flushes++
 	}
 
-	db.bytesWrite += uint(len)
 	//如果配置过写同步磁盘，立即将缓冲区中的数据写入到磁盘中
-	var needsync = db.configuration.SyncWrites
-	if !needsync && db.configuration.BytesPerSync > 0 && db.bytesWrite >= db.configuration.BytesPerSync {
+	var needsync = sync
+	if bytesPerSync := db.bytesPerSync(); !needsync && bytesPerSync > 0 && db.bytesWrite >= bytesPerSync {
 		//判断当前已写入的字节数是否达到阈值
 		needsync = true
 	}
-	if needsync {
+	if needsync && db.activeFile != nil {
 		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-		if db.bytesWrite > 0 {
-			db.bytesWrite = 0
+			return err
 		}
+		db.bytesWrite = 0
+		g.synced = g.flushes
 	}
-
-	pos := &data.LogRecordPos{
-		Fid:    db.activeFile.Fid,
-		Offset: writeOff,
-		Size:   uint32(len),
-	}
-	//fmt.Printf("fid:%d, offset:%d\n", db.activeFile.Fid, writeOff)
-	return pos, nil
+	return nil
 }
 
 // 设置当前活跃的数据文件
@@ -444,11 +660,13 @@ func (db *DB) setActiveDataFile() error {
 	}
 
 	//打开活跃文件
-	dataFile, err := data.OpenDataFile(db.configuration.DataDir, initialFid, fio.StandardFIO)
+	activeIOType, _ := db.runtimeIOTypes()
+	dataFile, err := data.OpenActiveDataFile(db.configuration.DataDir, initialFid, activeIOType, db.configuration.DataFileMaxSize)
 
 	if err != nil {
 		return err
 	}
+	dataFile.Cipher = db.cipher
 
 	//标记新的活跃文件
 	db.activeFile = dataFile
@@ -490,11 +708,17 @@ func (db *DB) loadDataFiles() error {
 		ioType := fio.StandardFIO
 		if db.configuration.MMapAtStartup {
 			ioType = fio.MemoryMap
+		} else if db.configuration.FileIOType == config.DirectFIO {
+			ioType = fio.DirectFIO
+		} else if db.configuration.FileIOType == config.IOUringFIO {
+			//扫描数据文件时一次提交多个读取请求
+			ioType = fio.IOUringFIO
 		}
 		dataFile, err := data.OpenDataFile(db.configuration.DataDir, uint32(fd), ioType)
 		if err != nil {
 			return err
 		}
+		dataFile.Cipher = db.cipher
 
 		//最后一个文件是活跃文件
 		if i+1 == len(fds) {
@@ -527,10 +751,10 @@ func (db *DB) LoadIndexFromDataFiles() error {
 	}
 	var currentSeqNo = nonTransactionSeqNo
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var ok bool
 		//fmt.Println("LoadIndexFromDataFiles", typ)
 		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
+		//已经过期的数据和删除的数据一样，不需要加载到索引中
+		if typ == data.LogRecordDeleted || pos.IsExpired() {
 			oldPos, _ = db.index.Delete(key)
 			db.reclaimSize += int64(pos.Size)
 		} else {
@@ -539,15 +763,11 @@ func (db *DB) LoadIndexFromDataFiles() error {
 		if oldPos != nil {
 			db.reclaimSize += int64(oldPos.Size)
 		}
-		//fmt.Println("LoadIndexFromDataFiles", ok)
-		if !ok {
-			panic("failed to udpate index at startup")
-		}
 	}
 
 	transactionReocrds := make(map[uint64][]*data.TransactionRecord)
 
-	//严格按照时间顺序构建索引
+	var dataFiles []*data.DataFile
 	for _, _fid := range db.fds {
 		var fid = uint32(_fid)
 
@@ -555,48 +775,35 @@ func (db *DB) LoadIndexFromDataFiles() error {
 		if hasMerge && fid < nonMergeFileId {
 			continue
 		}
-		var dataFile *data.DataFile
-
 		if fid == db.activeFile.Fid {
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFiles[fid]
+			dataFiles = append(dataFiles, db.olderFiles[fid])
 		}
+	}
 
-		var offset int64 = 0
-		for {
-			//读取一条LogRecord，并得到其大小
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
-
-			//构造索引中的记录
-			logRecordPos := &data.LogRecordPos{Fid: fid, Offset: offset, Size: uint32(size)}
-
-			//解析key，拿到对应的事务序列号
-			realKey, seqNo := parseLogRecordKeyWithSeq(logRecord.Key)
-
-			//fmt.Printf("LoadIndexFromDataFiles, index size:%d, seqNo:%d, record type:%d\n", db.index.Size(), seqNo, logRecord.Type)
+	//并行扫描数据文件，但严格按照时间顺序构建索引
+	scanner := db.scanDataFilesForIndex(dataFiles)
+	defer scanner.Stop()
+	for _, dataFile := range dataFiles {
+		result := scanner.Next()
+		for _, entry := range result.entries {
+			logRecordPos, seqNo := entry.pos, entry.seqNo
 
 			if seqNo == nonTransactionSeqNo {
 				//如果是普通的插入删除(事务序列号为0)，直接处理即可
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+				updateIndex(entry.key, entry.typ, logRecordPos)
 			} else {
 				//如果事务完成， 对应的seqNo的数据都可以更新到索引中
-				if logRecord.Type == data.LogRecordFinished {
+				if entry.typ == data.LogRecordFinished {
 					for _, transReocrd := range transactionReocrds[seqNo] {
 						updateIndex(transReocrd.Record.Key, transReocrd.Record.Type, transReocrd.Pos)
 					}
 					delete(transactionReocrds, seqNo)
 				} else {
 					//待定，先暂存
-					logRecord.Key = realKey
 					transactionReocrds[seqNo] = append(transactionReocrds[seqNo], &data.TransactionRecord{
-						Record: logRecord,
+						Record: &data.LogRecord{Key: entry.key, Type: entry.typ},
 						Pos:    logRecordPos,
 					})
 				}
@@ -605,13 +812,21 @@ func (db *DB) LoadIndexFromDataFiles() error {
 			if seqNo > currentSeqNo {
 				currentSeqNo = seqNo
 			}
-			//偏移量移动当前LogRecord大小
-			offset += size
+		}
+
+		if result.err != nil {
+			//文件末尾有不完整或者损坏的记录，截断到最后一条有效记录
+			if err := db.truncateCorruptedData(dataFile, result.offset, result.fileSize, result.err); err != nil {
+				return err
+			}
 		}
 
 		//如果是活跃文件，因为我们追加写入需要文件当前的偏移量，这里更新一下
-		if fid == db.activeFile.Fid {
-			db.activeFile.WriteOffset = offset
+		if dataFile == db.activeFile {
+			db.activeFile.WriteOffset = result.offset
+		} else if !result.fromHint && result.err == nil {
+			//之前没有生成hint文件的旧数据文件，使用这次扫描的结果补上
+			db.writeDataFileHintAsync(dataFile, result.entries)
 		}
 	}
 
@@ -630,6 +845,7 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
+	seqNoFile.Cipher = db.cipher
 	record, _, err := seqNoFile.ReadLogRecord(0)
 	if err != nil {
 		return err
@@ -645,18 +861,35 @@ func (db *DB) loadSeqNo() error {
 	return os.Remove(fileName)
 }
 
-// 将数据文件的IO类型设置为标准文件IO
+// 运行时活跃文件和旧数据文件分别使用的IO类型
+func (db *DB) runtimeIOTypes() (fio.FileIOType, fio.FileIOType) {
+	switch db.configuration.FileIOType {
+	case config.MemoryMap:
+		return fio.StandardFIO, fio.MemoryMap
+	case config.WritableMemoryMap:
+		return fio.WritableMemoryMap, fio.MemoryMap
+	case config.DirectFIO:
+		return fio.DirectFIO, fio.DirectFIO
+	case config.IOUringFIO:
+		return fio.IOUringFIO, fio.IOUringFIO
+	default:
+		return fio.StandardFIO, fio.StandardFIO
+	}
+}
+
+// 启动时加载完数据之后，将数据文件的IO类型设置为运行时使用的IO类型
 func (db *DB) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
 
-	if err := db.activeFile.SetIOManager(db.configuration.DataDir, fio.StandardFIO); err != nil {
+	activeIOType, sealedIOType := db.runtimeIOTypes()
+	if err := db.activeFile.SetIOManager(db.configuration.DataDir, activeIOType, db.configuration.DataFileMaxSize); err != nil {
 		return err
 	}
 
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.configuration.DataDir, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(db.configuration.DataDir, sealedIOType, 0); err != nil {
 			return err
 		}
 	}
@@ -676,12 +909,16 @@ func (db *DB) Stat() *Stat {
 	if err != nil {
 		panic(fmt.Sprintf("failed to get dir size : %v", err))
 	}
-	return &Stat{
+	stat := &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimSize,
 		DiskSize:        dirSize,
 	}
+	if db.valueCache != nil {
+		stat.CacheHits, stat.CacheMisses = db.valueCache.Stats()
+	}
+	return stat
 }
 
 // 备份数据库， 将数据文件拷贝到新的目录中
@@ -2,8 +2,10 @@ package Bitcask_go
 
 import (
 	"Bitcask_go/config"
+	"Bitcask_go/data"
 	"Bitcask_go/util"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 // 测试完成之后销毁 DB 数据目录
 func destroyDB(db *DB) {
 	if db != nil {
+		db.hintWriters.Wait()
 		if db.activeFile != nil {
 			_ = db.activeFile.Close()
 		}
@@ -42,7 +45,7 @@ func TestDB_Put(t *testing.T) {
 	opts := config.DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-put")
 	opts.DataDir = dir
-	opts.DataFileMaxSize = 64 * 1024 * 1024
+	opts.DataFileMaxSize = 8 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -81,14 +84,8 @@ func TestDB_Put(t *testing.T) {
 	assert.Equal(t, 2, len(db.olderFiles))
 
 	// 6.重启后再 Put 数据
-	if db.activeFile != nil {
-		_ = db.activeFile.Close()
-	}
-	for _, of := range db.olderFiles {
-		if of != nil {
-			_ = of.Close()
-		}
-	}
+	err = db.Close()
+	assert.Nil(t, err)
 
 	// 重启数据库
 	db2, err := Open(opts)
@@ -431,3 +428,384 @@ func TestDB_OpenMMap(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.ttl 为负数
+	err = db.PutWithTTL(util.GetTestKey(1), util.RandomValue(24), -time.Second)
+	assert.Equal(t, util.ErrInvalidTTL, err)
+
+	// 2.没有过期时间的 key
+	err = db.Put(util.GetTestKey(1), util.RandomValue(24))
+	assert.Nil(t, err)
+	ttl, err := db.TTL(util.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// 3.过期之后 Get、ListKeys、Fold、Iterator 都看不到
+	err = db.PutWithTTL(util.GetTestKey(2), util.RandomValue(24), time.Millisecond*100)
+	assert.Nil(t, err)
+	ttl, err = db.TTL(util.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Millisecond*100)
+
+	time.Sleep(time.Millisecond * 200)
+	_, err = db.Get(util.GetTestKey(2))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+	_, err = db.TTL(util.GetTestKey(2))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+	assert.Equal(t, 1, len(db.ListKeys()))
+
+	var foldCount int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		foldCount++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, foldCount)
+
+	iter := db.NewIterator(config.DefaultIteratorOptions)
+	var iterCount int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, util.GetTestKey(1), iter.Key())
+		iterCount++
+	}
+	iter.Close()
+	assert.Equal(t, 1, iterCount)
+
+	// 4.Persist 之后不再过期
+	err = db.PutWithTTL(util.GetTestKey(3), util.RandomValue(24), time.Millisecond*300)
+	assert.Nil(t, err)
+	err = db.Persist(util.GetTestKey(3))
+	assert.Nil(t, err)
+	err = db.Persist(util.GetTestKey(2))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	// 5.重启之后过期时间依然有效
+	err = db.PutWithTTL(util.GetTestKey(4), util.RandomValue(24), time.Millisecond*300)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 400)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(util.GetTestKey(3))
+	assert.Nil(t, err)
+	_, err = db2.Get(util.GetTestKey(4))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+	assert.Equal(t, 2, db2.index.Size())
+}
+
+func TestDB_LoadIndexFromDataFiles_Parallel(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-parallel-load")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 8 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	//覆盖写、删除以及跨越多个文件的事务
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i%300), util.RandomValue(16)))
+		if i%7 == 0 {
+			assert.Nil(t, db.Delete(util.GetTestKey((i+1)%300)))
+		}
+		if i%100 == 0 {
+			wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+			for j := 0; j < 50; j++ {
+				assert.Nil(t, wb.Put(util.GetTestKey(j*5), util.RandomValue(16)))
+			}
+			assert.Nil(t, wb.Delete(util.GetTestKey(i%300)))
+			assert.Nil(t, wb.Commit())
+		}
+	}
+	expected := make(map[string][]byte)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		expected[string(key)] = value
+		return true
+	}))
+	assert.Nil(t, db.Close())
+
+	for _, workers := range []int{1, 3, 16} {
+		opts.IndexLoadWorkers = workers
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.True(t, len(db.olderFiles) > 10)
+		actual := make(map[string][]byte)
+		assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+			actual[string(key)] = value
+			return true
+		}))
+		assert.Equal(t, expected, actual)
+		assert.Nil(t, db.Close())
+	}
+	_ = os.RemoveAll(dir)
+}
+
+func TestDB_ShardedIndex(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-index")
+	opts.DataDir = dir
+	opts.IndexShards = 8
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Delete(util.GetTestKey(10)))
+
+	//并发读取
+	wg := new(sync.WaitGroup)
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				val, err := db.Get(util.GetTestKey(i))
+				if i == 10 {
+					assert.Equal(t, util.ErrKeyNotFound, err)
+					continue
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, util.GetTestKey(i), val)
+			}
+		}()
+	}
+	wg.Wait()
+
+	//迭代器按全局顺序返回所有的key
+	keys := db.ListKeys()
+	assert.Equal(t, 999, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, string(keys[i-1]) < string(keys[i]))
+	}
+	iter := db.NewIterator(config.IteratorOptions{Reverse: true})
+	iter.Rewind()
+	assert.Equal(t, util.GetTestKey(999), iter.Key())
+	iter.Close()
+
+	//重启之后索引仍然可以正常加载
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(999), db.Stat().KeyNum)
+
+	opts.IndexerType = config.BPTree
+	_, err = Open(opts)
+	assert.Equal(t, util.ErrIndexShardUnsupported, err)
+}
+
+func TestDB_HashIndex(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hash-index")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 64 * 1024
+	opts.IndexerType = config.HashIndex
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.GetTestKey(i)))
+	}
+	for i := 0; i < 2000; i += 3 {
+		assert.Nil(t, db.Delete(util.GetTestKey(i)))
+	}
+
+	//merge和重启之后的索引
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		val, err := db.Get(util.GetTestKey(i))
+		if i%3 == 0 {
+			assert.Equal(t, util.ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, util.GetTestKey(i), val)
+	}
+	//迭代器是无序的，但是包含所有的key
+	assert.Equal(t, 1333, len(db.ListKeys()))
+	var count int
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	}))
+	assert.Equal(t, 1333, count)
+
+	//Seek之后按key的顺序遍历
+	iter := db.NewIterator(config.DefaultIteratorOptions)
+	defer iter.Close()
+	iter.Seek(util.GetTestKey(1990))
+	for i := 1990; i < 2000; i++ {
+		if i%3 == 0 {
+			continue
+		}
+		assert.True(t, iter.Valid())
+		assert.Equal(t, util.GetTestKey(i), iter.Key())
+		iter.Next()
+	}
+	assert.False(t, iter.Valid())
+}
+
+func TestDB_WritableMMap(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap-rw")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 64 * 1024
+	opts.FileIOType = config.WritableMemoryMap
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+	}
+	assert.Nil(t, db.Delete(util.GetTestKey(0)))
+	assert.Nil(t, db.Sync())
+	assert.True(t, len(db.olderFiles) > 1)
+
+	//活跃文件预先分配了空间，封存的数据文件已经截断到实际大小
+	stat, err := os.Stat(data.GetDataFileName(dir, db.activeFile.Fid))
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileMaxSize, stat.Size())
+	for fid, dataFile := range db.olderFiles {
+		stat, err := os.Stat(data.GetDataFileName(dir, fid))
+		assert.Nil(t, err)
+		size, _ := dataFile.IOManager.Size()
+		assert.Equal(t, size, stat.Size())
+	}
+
+	//模拟进程崩溃：在DB没有关闭时拷贝数据目录，活跃文件末尾留有预分配的空间
+	crashDir, _ := os.MkdirTemp("", "bitcask-go-mmap-rw-crash")
+	defer os.RemoveAll(crashDir)
+	assert.Nil(t, util.CopyDir(dir, crashDir, []string{fileLockName}))
+
+	report, err := Fsck(crashDir, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+
+	crashOpts := opts
+	crashOpts.DataDir = crashDir
+	crashDB, err := Open(crashOpts)
+	assert.Nil(t, err)
+	_, err = crashDB.Get(util.GetTestKey(0))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+	for i := 1; i < 2000; i++ {
+		_, err := crashDB.Get(util.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	//恢复之后继续写入
+	assert.Nil(t, crashDB.Put(util.GetTestKey(0), []byte("after-crash")))
+	assert.Nil(t, crashDB.Close())
+	crashDB, err = Open(crashOpts)
+	assert.Nil(t, err)
+	val, err := crashDB.Get(util.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after-crash"), val)
+	assert.Nil(t, crashDB.Close())
+
+	//正常关闭之后活跃文件截断到实际大小，使用标准IO也可以打开
+	activeFid := db.activeFile.Fid
+	writeOffset := db.activeFile.WriteOffset
+	assert.Nil(t, db.Close())
+	stat, err = os.Stat(data.GetDataFileName(dir, activeFid))
+	assert.Nil(t, err)
+	assert.Equal(t, writeOffset, stat.Size())
+
+	opts.FileIOType = config.StandardFIO
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1999), db.Stat().KeyNum)
+}
+
+func TestDB_DirectIOAndIOUring(t *testing.T) {
+	for _, ioType := range []config.FileIOType{config.DirectFIO, config.IOUringFIO} {
+		opts := config.DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-direct-io")
+		opts.DataDir = dir
+		opts.DataFileMaxSize = 64 * 1024
+		opts.DataFileMergeRatio = 0
+		opts.FileIOType = ioType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for i := 0; i < 2000; i++ {
+			assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+		}
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Delete(util.GetTestKey(i)))
+		}
+		assert.True(t, len(db.olderFiles) > 1)
+		val, err := db.Get(util.GetTestKey(1500))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+
+		//merge读取旧数据文件，重启之后从数据文件和hint文件加载索引
+		assert.Nil(t, db.Merge())
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 1000, len(db.ListKeys()))
+		val2, err := db.Get(util.GetTestKey(1500))
+		assert.Nil(t, err)
+		assert.Equal(t, val, val2)
+		_, err = db.Get(util.GetTestKey(0))
+		assert.Equal(t, util.ErrKeyNotFound, err)
+		destroyDB(db)
+	}
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-cache")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 64 * 1024
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+	}
+
+	val, err := db.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	stat := db.Stat()
+	assert.Equal(t, uint64(0), stat.CacheHits)
+	assert.Equal(t, uint64(1), stat.CacheMisses)
+
+	//修改返回的value不会影响缓存
+	val2, err := db.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val, val2)
+	val2[0] ^= 0xff
+	val3, err := db.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val, val3)
+	stat = db.Stat()
+	assert.Equal(t, uint64(2), stat.CacheHits)
+
+	//更新之后的key在新的位置，不会读到旧的value
+	assert.Nil(t, db.Put(util.GetTestKey(1), []byte("new-value")))
+	val, err = db.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+}
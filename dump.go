@@ -0,0 +1,67 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/data"
+	"io"
+	"sort"
+)
+
+// DumpRecord 数据文件中的一条原始记录
+type DumpRecord struct {
+	Fid    uint32
+	Offset int64
+	Size   int64
+	SeqNo  uint64 //事务序列号，非事务写入为0
+	Type   data.LogRecordType
+	Key    []byte //去掉事务序列号之后的key
+	Value  []byte
+	Expire int64
+}
+
+// DumpLogRecords 按写入顺序遍历所有数据文件中的原始记录，包括已经被覆盖、删除的旧数据和事务完成标记
+// 遍历期间持有读锁，fn中不能调用DB的写操作；fn返回false时停止遍历
+func (db *DB) DumpLogRecords(fn func(record *DumpRecord) bool) error {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	dataFiles := make([]*data.DataFile, 0, len(db.olderFiles)+1)
+	for _, dataFile := range db.olderFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	sort.Slice(dataFiles, func(i, j int) bool {
+		return dataFiles[i].Fid < dataFiles[j].Fid
+	})
+	if db.activeFile != nil {
+		dataFiles = append(dataFiles, db.activeFile)
+	}
+
+	for _, dataFile := range dataFiles {
+		var offset int64
+		for dataFile != db.activeFile || offset < dataFile.WriteOffset {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			key, seqNo := parseLogRecordKeyWithSeq(logRecord.Key)
+			record := &DumpRecord{
+				Fid:    dataFile.Fid,
+				Offset: offset,
+				Size:   size,
+				SeqNo:  seqNo,
+				Type:   logRecord.Type,
+				Key:    key,
+				Value:  logRecord.Value,
+				Expire: logRecord.Expire,
+			}
+			if !fn(record) {
+				return nil
+			}
+			offset += size
+		}
+	}
+	return nil
+}
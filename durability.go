@@ -0,0 +1,107 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"sync"
+	"time"
+)
+
+// 每次写入是否需要同步到磁盘
+func (db *DB) syncEveryWrite() bool {
+	switch db.configuration.SyncPolicy {
+	case config.SyncAlways:
+		return true
+	case config.SyncByWriteOptions:
+		return db.configuration.SyncWrites
+	default:
+		return false
+	}
+}
+
+// 累计写入多少字节之后同步到磁盘，0表示不按照写入的字节数同步
+func (db *DB) bytesPerSync() uint {
+	switch db.configuration.SyncPolicy {
+	case config.SyncByWriteOptions, config.SyncEveryBytes:
+		return db.configuration.BytesPerSync
+	default:
+		return 0
+	}
+}
+
+// periodicSyncer 按照SyncInterval定期同步活跃文件的后台协程
+type periodicSyncer struct {
+	stop    chan struct{}
+	running *sync.WaitGroup
+	once    *sync.Once
+}
+
+// 同步策略是SyncEveryInterval时启动后台同步的协程
+func (db *DB) startPeriodicSync() {
+	if db.configuration.SyncPolicy != config.SyncEveryInterval {
+		return
+	}
+
+	s := &periodicSyncer{
+		stop:    make(chan struct{}),
+		running: new(sync.WaitGroup),
+		once:    new(sync.Once),
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		ticker := time.NewTicker(db.configuration.SyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				//同步失败时在下一个周期重试
+				_ = db.syncIfDirty()
+			}
+		}
+	}()
+	db.periodicSyncer = s
+}
+
+// Stop 停止后台同步的协程，等待正在进行的同步完成
+func (s *periodicSyncer) Stop() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.running.Wait()
+}
+
+// 上次同步之后有新的写入时同步活跃文件
+func (db *DB) syncIfDirty() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.activeFile == nil || db.bytesWrite == 0 {
+		return nil
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+	return nil
+}
+
+// SyncAll 把活跃文件和数据目录同步到磁盘
+// 旧数据文件在切换活跃文件时已经同步，同步数据目录保证新创建的数据文件、hint文件以及merge之后替换的文件在崩溃之后仍然存在
+func (db *DB) SyncAll() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+		db.bytesWrite = 0
+	}
+	return util.SyncDir(db.configuration.DataDir)
+}
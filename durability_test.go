@@ -0,0 +1,102 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 读取上次同步之后写入的字节数
+func unsyncedBytes(db *DB) uint {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.bytesWrite
+}
+
+func TestDB_SyncPolicy_CheckCfg(t *testing.T) {
+	opts := config.DefaultOptions
+	opts.SyncPolicy = config.SyncNever + 1
+	assert.Equal(t, util.ErrUnknownSyncPolicy, config.CheckCfg(opts))
+
+	opts.SyncPolicy = config.SyncEveryInterval
+	opts.SyncInterval = 0
+	assert.Equal(t, util.ErrSyncIntervalInvalid, config.CheckCfg(opts))
+
+	opts = config.DefaultOptions
+	opts.SyncPolicy = config.SyncEveryBytes
+	assert.Equal(t, util.ErrBytesPerSyncInvalid, config.CheckCfg(opts))
+	opts.BytesPerSync = 1024
+	assert.Nil(t, config.CheckCfg(opts))
+}
+
+func TestDB_SyncPolicy(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy config.SyncPolicy
+		synced func(i int) bool //第i次写入之后是否已经同步
+	}{
+		{"always", config.SyncAlways, func(i int) bool { return true }},
+		{"bytes", config.SyncEveryBytes, func(i int) bool { return (i+1)%4 == 0 }},
+		{"never", config.SyncNever, func(i int) bool { return false }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := config.DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-sync-policy")
+			opts.DataDir = dir
+			opts.SyncPolicy = c.policy
+			//SyncNever和SyncAlways不受BytesPerSync的影响，SyncEveryBytes每写入4条记录同步一次
+			value := util.RandomValue(100)
+			encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+				Key:   logRecordKeyWithSeq(util.GetTestKey(0), nonTransactionSeqNo),
+				Value: value,
+			})
+			opts.BytesPerSync = uint(4 * len(encRecord))
+			db, err := Open(opts)
+			defer destroyDB(db)
+			assert.Nil(t, err)
+
+			for i := 0; i < 8; i++ {
+				assert.Nil(t, db.Put(util.GetTestKey(i), value))
+				assert.Equal(t, c.synced(i), unsyncedBytes(db) == 0, "write %d", i)
+			}
+
+			assert.Nil(t, db.SyncAll())
+			assert.Equal(t, uint(0), unsyncedBytes(db))
+		})
+	}
+}
+
+func TestDB_SyncPolicy_Interval(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sync-interval")
+	opts.DataDir = dir
+	opts.SyncPolicy = config.SyncEveryInterval
+	opts.SyncInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db.periodicSyncer)
+
+	assert.Nil(t, db.Put(util.GetTestKey(1), util.RandomValue(100)))
+	assert.True(t, unsyncedBytes(db) > 0)
+
+	//写入停止之后后台协程也会同步剩下的数据
+	deadline := time.Now().Add(5 * time.Second)
+	for unsyncedBytes(db) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, uint(0), unsyncedBytes(db))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	_, err = db.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	db.periodicSyncer.Stop()
+}
@@ -0,0 +1,96 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	encryptionCheckKey         = "encryption.check"
+	encryptionCheckValuePrefix = "bitcask-encryption-check:"
+)
+
+// 打开DB时校验配置的密钥
+// 校验文件中保存了每个用过的密钥加密的一条已知记录，任何一条记录解密失败都说明密钥不对；
+// KeyProvider中已经不存在的旧密钥会跳过，但至少要有一个密钥能通过校验
+func (db *DB) checkEncryptionKey() error {
+	fileName := filepath.Join(db.configuration.DataDir, data.EncryptionCheckFileName)
+	_, err := os.Stat(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+
+	if db.cipher == nil {
+		//数据是加密的，但是没有配置密钥
+		if exists {
+			return util.ErrEncryptionKeyRequired
+		}
+		return nil
+	}
+
+	checkFile, err := data.OpenEncryptionCheckFile(db.configuration.DataDir)
+	if err != nil {
+		return err
+	}
+	defer checkFile.Close()
+	checkFile.Cipher = db.cipher
+
+	currentKeyId := db.cipher.CurrentKeyId()
+	var recordNum, verifiedNum int
+	var hasCurrentKey bool
+	var offset int64
+	for {
+		record, size, err := checkFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		offset += size
+		recordNum++
+		//KeyProvider已经不提供这个密钥了，使用它加密的数据应该已经被merge重写
+		if err == util.ErrEncryptionKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		value := string(record.Value)
+		if !strings.HasPrefix(value, encryptionCheckValuePrefix) {
+			return util.ErrWrongEncryptionKey
+		}
+		keyId, err := strconv.ParseUint(strings.TrimPrefix(value, encryptionCheckValuePrefix), 10, 32)
+		if err != nil {
+			return util.ErrWrongEncryptionKey
+		}
+		verifiedNum++
+		if uint32(keyId) == currentKeyId {
+			hasCurrentKey = true
+		}
+	}
+	if recordNum > 0 && verifiedNum == 0 {
+		return util.ErrWrongEncryptionKey
+	}
+	if hasCurrentKey {
+		return nil
+	}
+
+	//第一次使用当前的密钥，追加一条校验记录
+	record := &data.LogRecord{
+		Key:   []byte(encryptionCheckKey),
+		Value: []byte(encryptionCheckValuePrefix + strconv.FormatUint(uint64(currentKeyId), 10)),
+	}
+	encRecord, _, err := data.EncodeLogRecordWithCipher(record, db.cipher)
+	if err != nil {
+		return err
+	}
+	if err := checkFile.Write(encRecord); err != nil {
+		return err
+	}
+	return checkFile.Sync()
+}
@@ -0,0 +1,228 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 检查数据目录中的文件是否包含明文
+func dirContains(t *testing.T, dir string, content []byte) bool {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		buf, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		if bytes.Contains(buf, content) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DataDir = dir
+	opts.KeyProvider = &config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)},
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), []byte("secret-value")))
+	}
+	wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("secret-value")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Close())
+
+	assert.False(t, dirContains(t, dir, []byte("secret-value")))
+	assert.False(t, dirContains(t, dir, util.GetTestKey(10)))
+
+	//没有配置密钥
+	plainOpts := opts
+	plainOpts.KeyProvider = nil
+	_, err = Open(plainOpts)
+	assert.Equal(t, util.ErrEncryptionKeyRequired, err)
+
+	//密钥错误
+	wrongOpts := opts
+	wrongOpts.KeyProvider = &config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("2"), 32)},
+	}
+	_, err = Open(wrongOpts)
+	assert.Equal(t, util.ErrWrongEncryptionKey, err)
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, len(db.ListKeys()))
+	val, err := db.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value"), val)
+}
+
+func TestDB_Encryption_KeyRotation(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-rotation")
+	opts.DataDir = dir
+	opts.DataFileMergeRatio = 0
+	provider := &config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("1"), 16)},
+	}
+	opts.KeyProvider = provider
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	//轮换密钥，旧数据仍然可以使用旧密钥读取
+	provider.Keys[2] = bytes.Repeat([]byte("2"), 16)
+	provider.CurrentId = 2
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 1000; i < 1100; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(util.GetTestKey(i)))
+	}
+	values := make(map[string][]byte)
+	for _, key := range db.ListKeys() {
+		val, err := db.Get(key)
+		assert.Nil(t, err)
+		values[string(key)] = val
+	}
+
+	//merge之后所有数据都使用新的密钥加密，hint文件也是加密的
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	delete(provider.Keys, 1)
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), len(db.ListKeys()))
+	for key, expected := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+}
+
+func TestDB_Encryption_BPTree(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-bptree")
+	opts.DataDir = dir
+	opts.IndexerType = config.BPTree
+	indexFile := filepath.Join(dir, "bptree-index")
+
+	//先不加密写入一部分数据
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), []byte("plain-value")))
+	}
+	assert.Nil(t, db.Close())
+	buf, err := os.ReadFile(indexFile)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(buf, util.GetTestKey(10)))
+
+	//开启加密之后，已有的索引也会被加密
+	provider := &config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)},
+	}
+	opts.KeyProvider = provider
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), []byte("secret-value")))
+	}
+	assert.Nil(t, db.Delete(util.GetTestKey(0)))
+	assert.Nil(t, db.Close())
+	buf, err = os.ReadFile(indexFile)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(buf, util.GetTestKey(10)))
+	assert.False(t, bytes.Contains(buf, util.GetTestKey(60)))
+
+	//密钥错误
+	wrongOpts := opts
+	wrongOpts.KeyProvider = &config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("2"), 32)},
+	}
+	_, err = Open(wrongOpts)
+	assert.Equal(t, util.ErrWrongEncryptionKey, err)
+
+	//轮换密钥之后索引使用新的密钥重新加密，迭代器Seek之后按key排序
+	provider.Keys[2] = bytes.Repeat([]byte("2"), 32)
+	provider.CurrentId = 2
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 99, len(db.ListKeys()))
+	_, err = db.Get(util.GetTestKey(0))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+	val, err := db.Get(util.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain-value"), val)
+
+	iter := db.NewIterator(config.DefaultIteratorOptions)
+	i := 1
+	for iter.Seek(util.GetTestKey(1)); iter.Valid(); iter.Next() {
+		assert.Equal(t, util.GetTestKey(i), iter.Key())
+		i++
+	}
+	iter.Close()
+	assert.Equal(t, 100, i)
+}
+
+func TestRestoreWithKeyProvider(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-restore")
+	opts.DataDir = dir
+	opts.KeyProvider = &config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)},
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for _, key := range []string{"batch-1", "batch-2"} {
+		wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put([]byte(key), []byte("batch")))
+		assert.Nil(t, wb.Commit())
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-encryption-restore-dest")
+	defer os.RemoveAll(backupDir)
+	_, err = db.IncrementalBackup(backupDir)
+	assert.Nil(t, err)
+
+	restoreDir := filepath.Join(os.TempDir(), "bitcask-go-encryption-restore-target")
+	_ = os.RemoveAll(restoreDir)
+	assert.Equal(t, util.ErrEncryptionKeyRequired, Restore(backupDir, restoreDir, 1))
+
+	_ = os.RemoveAll(restoreDir)
+	assert.Nil(t, RestoreWithKeyProvider(backupDir, restoreDir, 1, opts.KeyProvider))
+	restoreOpts := config.DefaultOptions
+	restoreOpts.DataDir = restoreDir
+	restoreOpts.KeyProvider = opts.KeyProvider
+	db2, err := Open(restoreOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("batch-1")}, db2.ListKeys())
+}
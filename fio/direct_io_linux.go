@@ -0,0 +1,135 @@
+//go:build linux
+
+package fio
+
+import (
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	//O_DIRECT要求读取的偏移、长度和内存地址都按块对齐，4KB可以满足常见的文件系统
+	directIOAlignment = 4096
+
+	//不超过这个大小的读取复用缓冲池中的对齐内存
+	directIOPoolBufSize = 64 * 1024
+)
+
+var directIOBufPool = sync.Pool{
+	New: func() any {
+		return alignedBlock(directIOPoolBufSize)
+	},
+}
+
+// 分配起始地址按directIOAlignment对齐的内存，size需要是directIOAlignment的倍数
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	shift := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		shift = directIOAlignment - rem
+	}
+	return buf[shift : shift+size : shift+size]
+}
+
+// DirectIO 读取时绕过页缓存的文件IO，大量扫描数据文件时不会把其他服务使用的页缓存挤出去
+// 读取使用O_DIRECT打开的文件描述符，把请求扩大到对齐的范围读到对齐的缓冲区之后再拷贝；
+// 追加写入的长度不固定，无法满足对齐的要求，仍然写入页缓存，Sync和Close之后通过fadvise丢弃这部分缓存
+type DirectIO struct {
+	fd       *os.File //写入使用的文件描述符
+	directFd *os.File //O_DIRECT打开的文件描述符，只用于读取
+}
+
+// NewDirectIOManager 创建一个DirectIO实例，文件系统不支持O_DIRECT时返回错误
+func NewDirectIOManager(fileName string) (*DirectIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, FilePermission)
+	if err != nil {
+		return nil, err
+	}
+	directFd, err := os.OpenFile(fileName, os.O_RDONLY|unix.O_DIRECT, FilePermission)
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &DirectIO{fd: fd, directFd: directFd}, nil
+}
+
+// Read 从文件的指定位置读取数据
+func (dio *DirectIO) Read(b []byte, offset int64) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	start := offset &^ (directIOAlignment - 1)
+	end := (offset + int64(len(b)) + directIOAlignment - 1) &^ (directIOAlignment - 1)
+	size := int(end - start)
+
+	var buf []byte
+	if size <= directIOPoolBufSize {
+		buf = directIOBufPool.Get().([]byte)
+		defer directIOBufPool.Put(buf)
+		buf = buf[:size]
+	} else {
+		buf = alignedBlock(size)
+	}
+
+	//O_DIRECT的读取在文件末尾会返回较少的字节，需要循环读取直到读满或者遇到文件末尾
+	var read int
+	for read < size {
+		n, err := unix.Pread(int(dio.directFd.Fd()), buf[read:], start+int64(read))
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return 0, &os.PathError{Op: "read", Path: dio.directFd.Name(), Err: err}
+		}
+		if n == 0 {
+			break
+		}
+		read += n
+	}
+
+	skip := int(offset - start)
+	if read <= skip {
+		return 0, io.EOF
+	}
+	n := copy(b, buf[skip:read])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 追加写入数据
+func (dio *DirectIO) Write(b []byte) (int, error) {
+	return dio.fd.Write(b)
+}
+
+// Sync 把数据同步到磁盘，然后丢弃文件在页缓存中已经写回的部分
+func (dio *DirectIO) Sync() error {
+	if err := dio.fd.Sync(); err != nil {
+		return err
+	}
+	return unix.Fadvise(int(dio.fd.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
+
+// Close 关闭文件，没有同步的数据在写回之前仍然会留在页缓存中
+func (dio *DirectIO) Close() error {
+	_ = unix.Fadvise(int(dio.fd.Fd()), 0, 0, unix.FADV_DONTNEED)
+	if err := dio.directFd.Close(); err != nil {
+		_ = dio.fd.Close()
+		return err
+	}
+	return dio.fd.Close()
+}
+
+// Size 返回文件大小
+func (dio *DirectIO) Size() (int64, error) {
+	stat, err := dio.fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fio
+
+import "errors"
+
+// DirectIO 当前平台不支持O_DIRECT，NewIOManager会使用标准文件IO
+type DirectIO struct {
+	IOManager
+}
+
+func NewDirectIOManager(fileName string) (*DirectIO, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// 为文件的[0, size)分配磁盘空间，文件小于size时扩大到size，磁盘空间不够时返回ENOSPC
+func allocate(fd *os.File, size int64) error {
+	for {
+		err := unix.Fallocate(int(fd.Fd()), 0, 0, size)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return &os.PathError{Op: "fallocate", Path: fd.Name(), Err: err}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,13 @@
+//go:build unix && !linux
+
+package fio
+
+import (
+	"errors"
+	"os"
+)
+
+// 当前平台没有fallocate，不能保证映射的范围在磁盘上有空间，NewIOManager会使用标准文件IO
+func allocate(fd *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -1,5 +1,10 @@
 package fio
 
+import (
+	"errors"
+	"syscall"
+)
+
 const FilePermission = 0644
 
 type FileIOType = byte
@@ -10,6 +15,15 @@ const (
 
 	//MemoryMap 内存文件映射
 	MemoryMap
+
+	//WritableMemoryMap 可读写的内存文件映射，可以用于活跃文件
+	WritableMemoryMap
+
+	//DirectFIO 读取时使用O_DIRECT绕过页缓存
+	DirectFIO
+
+	//IOUringFIO 使用io_uring读取，支持批量读取
+	IOUringFIO
 )
 
 // IOManager， 文件IO的接口
@@ -30,14 +44,73 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
-// 初始化一个IOManager， 目前只有FileIO
-func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+// ReadRequest 批量读取中的一个请求，N和Err是读取的结果
+type ReadRequest struct {
+	Buf    []byte
+	Offset int64
+	N      int
+	Err    error
+}
+
+// BatchReader 可以一次提交多个读取请求的IOManager
+type BatchReader interface {
+	//ReadBatch 执行所有的读取请求，返回的错误表示整批读取失败，单个请求的错误记录在请求中
+	ReadBatch(reqs []ReadRequest) error
+}
+
+// ReadBatch IOManager支持批量读取时一次提交所有请求，否则逐个读取
+func ReadBatch(ioManager IOManager, reqs []ReadRequest) error {
+	if br, ok := ioManager.(BatchReader); ok {
+		return br.ReadBatch(reqs)
+	}
+	for i := range reqs {
+		reqs[i].N, reqs[i].Err = ioManager.Read(reqs[i].Buf, reqs[i].Offset)
+	}
+	return nil
+}
+
+// 初始化一个IOManager，preallocSize只对可读写的内存映射有效，表示预先分配的文件大小
+// 内核或者文件系统不支持O_DIRECT、io_uring、fallocate时使用标准文件IO
+func NewIOManager(fileName string, ioType FileIOType, preallocSize int64) (IOManager, error) {
 	switch ioType {
 	case StandardFIO:
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case WritableMemoryMap:
+		mio, err := NewWritableMMapIOManager(fileName, preallocSize)
+		if err != nil {
+			if isUnsupported(err) {
+				return NewFileIOManager(fileName)
+			}
+			return nil, err
+		}
+		return mio, nil
+	case DirectFIO:
+		dio, err := NewDirectIOManager(fileName)
+		if err != nil {
+			if isUnsupported(err) {
+				return NewFileIOManager(fileName)
+			}
+			return nil, err
+		}
+		return dio, nil
+	case IOUringFIO:
+		uio, err := NewIOUringManager(fileName)
+		if err != nil {
+			if isUnsupported(err) {
+				return NewFileIOManager(fileName)
+			}
+			return nil, err
+		}
+		return uio, nil
 	default:
 		panic("unsupported io type")
 	}
 }
+
+// 判断错误是否表示当前的内核或者文件系统不支持这种IO方式，io_uring被seccomp禁止时返回EPERM
+func isUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSYS) ||
+		errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,366 @@
+//go:build linux
+
+package fio
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	//io_uring提交队列的长度，一次批量读取超过这个数量时分多次提交
+	uringEntries = 128
+
+	uringOpReadv        = 1
+	uringEnterGetEvents = 1
+	uringFeatSingleMMap = 1
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+)
+
+// 以下结构和内核中io_uring的定义保持一致
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode, flags         uint8
+	ioprio                uint16
+	fd                    int32
+	off, addr             uint64
+	len, rwFlags          uint32
+	userData              uint64
+	bufIndex, personality uint16
+	spliceFdIn            int32
+	addr3, pad            uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uring 一个io_uring实例，同一时间只被一个批量读取使用，返回之前会收割所有提交的请求
+type uring struct {
+	fd     int
+	sqRing []byte
+	cqRing []byte
+	sqeMem []byte
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                []uint32
+	sqes                   []uringSQE
+	cqHead, cqTail, cqMask *uint32
+	cqes                   []uringCQE
+
+	iovecs []unix.Iovec //每个提交队列位置对应的iovec，内核在请求完成之前可能访问
+}
+
+// 空闲的io_uring实例，并发的批量读取各自使用一个实例，不需要互相等待
+var idleRings = make(chan *uring, 4*runtime.GOMAXPROCS(0))
+
+// 取一个空闲的io_uring实例，没有时新建一个，内核不支持时返回错误
+func getRing() (*uring, error) {
+	select {
+	case r := <-idleRings:
+		return r, nil
+	default:
+		return newURing(uringEntries)
+	}
+}
+
+// 归还io_uring实例，空闲的实例已经足够多时直接关闭
+func putRing(r *uring) {
+	select {
+	case idleRings <- r:
+	default:
+		r.close()
+	}
+}
+
+func newURing(entries uint32) (*uring, error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+
+	r := &uring{fd: int(fd), iovecs: make([]unix.Iovec, p.sqEntries)}
+	if err := r.mmapRings(&p); err != nil {
+		r.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *uring) mmapRings(p *uringParams) error {
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	singleMMap := p.features&uringFeatSingleMMap != 0
+	if singleMMap && cqSize > sqSize {
+		sqSize = cqSize
+	}
+
+	var err error
+	prot, flags := unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE
+	if r.sqRing, err = unix.Mmap(r.fd, uringOffSQRing, sqSize, prot, flags); err != nil {
+		return err
+	}
+	r.cqRing = r.sqRing
+	if !singleMMap {
+		if r.cqRing, err = unix.Mmap(r.fd, uringOffCQRing, cqSize, prot, flags); err != nil {
+			return err
+		}
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if r.sqeMem, err = unix.Mmap(r.fd, uringOffSQEs, sqeSize, prot, flags); err != nil {
+		return err
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+	return nil
+}
+
+func (r *uring) close() {
+	if r.sqeMem != nil {
+		_ = unix.Munmap(r.sqeMem)
+	}
+	if r.cqRing != nil && &r.cqRing[0] != &r.sqRing[0] {
+		_ = unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		_ = unix.Munmap(r.sqRing)
+	}
+	_ = unix.Close(r.fd)
+}
+
+func (r *uring) enter(toSubmit, minComplete uint32) (int, error) {
+	for {
+		n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete),
+			uringEnterGetEvents, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return 0, os.NewSyscallError("io_uring_enter", errno)
+		}
+		return int(n), nil
+	}
+}
+
+// 提交一批读取请求并等待全部完成，reqs中的缓冲区都不为空，数量不能超过提交队列的长度
+// 每个请求完成时读取到的字节数或者负的错误码写入res
+//
+// 返回之前一定会收割所有已经被内核接收的请求，之后才能解除缓冲区的固定，
+// 提交失败时没有被内核接收的请求会从提交队列中撤回，不会在下一次使用时被提交
+func (r *uring) readv(fd int, reqs []ReadRequest, res []int32) error {
+	//内核在请求完成之前会访问iovec和缓冲区，需要固定它们的地址
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	pinner.Pin(&r.iovecs[0])
+
+	head := atomic.LoadUint32(r.sqHead)
+	mask := atomic.LoadUint32(r.sqMask)
+	for i := range reqs {
+		buf := reqs[i].Buf
+		pinner.Pin(&buf[0])
+		idx := (head + uint32(i)) & mask
+		r.iovecs[idx].Base = &buf[0]
+		r.iovecs[idx].SetLen(len(buf))
+		r.sqes[idx] = uringSQE{
+			opcode:   uringOpReadv,
+			fd:       int32(fd),
+			off:      uint64(reqs[i].Offset),
+			addr:     uint64(uintptr(unsafe.Pointer(&r.iovecs[idx]))),
+			len:      1,
+			userData: uint64(i),
+		}
+		r.sqArray[idx] = idx
+	}
+	atomic.StoreUint32(r.sqTail, head+uint32(len(reqs)))
+
+	//io_uring_enter只有在一个请求都没有提交时才返回错误
+	var submitted int
+	var submitErr error
+	for submitted < len(reqs) {
+		n, err := r.enter(uint32(len(reqs)-submitted), 0)
+		if err != nil {
+			submitErr = err
+			//撤回还没有被内核接收的请求，内核只在io_uring_enter时读取提交队列
+			atomic.StoreUint32(r.sqTail, atomic.LoadUint32(r.sqHead))
+			break
+		}
+		submitted += n
+	}
+
+	for completed := 0; completed < submitted; {
+		n := r.reap(res)
+		if n == 0 {
+			if _, err := r.enter(0, 1); err != nil {
+				//内核还可能写入已经解除固定的缓冲区，不能继续运行
+				panic(fmt.Sprintf("io_uring: wait for %d in-flight reads: %v", submitted-completed, err))
+			}
+		}
+		completed += n
+	}
+	return submitErr
+}
+
+// 收割完成队列中的所有请求，返回收割的数量
+func (r *uring) reap(res []int32) int {
+	head, tail := atomic.LoadUint32(r.cqHead), atomic.LoadUint32(r.cqTail)
+	mask := atomic.LoadUint32(r.cqMask)
+	n := int(tail - head)
+	for ; head != tail; head++ {
+		cqe := r.cqes[head&mask]
+		res[cqe.userData] = cqe.res
+	}
+	atomic.StoreUint32(r.cqHead, head)
+	return n
+}
+
+// IOUring 使用io_uring读取的文件IO，ReadBatch 把多个读取请求一次提交给内核，减少系统调用的次数
+// 单个读取没有可以合并的系统调用，和写入、同步一样使用标准的文件IO
+type IOUring struct {
+	fd *os.File
+}
+
+// NewIOUringManager 创建一个IOUring实例，内核不支持io_uring时返回错误
+func NewIOUringManager(fileName string) (*IOUring, error) {
+	ring, err := getRing()
+	if err != nil {
+		return nil, err
+	}
+	putRing(ring)
+
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, FilePermission)
+	if err != nil {
+		return nil, err
+	}
+	return &IOUring{fd: fd}, nil
+}
+
+// Read 从文件的指定位置读取数据
+func (u *IOUring) Read(b []byte, offset int64) (int, error) {
+	return u.fd.ReadAt(b, offset)
+}
+
+// ReadBatch 批量读取，每个请求的结果写入请求的N和Err，读到文件末尾时Err是io.EOF
+// 每次最多提交 uringEntries 个请求，超过时分多次提交
+func (u *IOUring) ReadBatch(reqs []ReadRequest) error {
+	if len(reqs) == 1 {
+		reqs[0].N, reqs[0].Err = u.Read(reqs[0].Buf, reqs[0].Offset)
+		return nil
+	}
+
+	ring, err := getRing()
+	if err != nil {
+		return err
+	}
+	//readv返回时所有提交的请求都已经完成，出错时也可以继续使用
+	defer putRing(ring)
+
+	var res [uringEntries]int32
+	var batch [uringEntries]int
+	pending := make([]ReadRequest, 0, min(len(reqs), uringEntries))
+	submit := func() error {
+		if err := ring.readv(int(u.fd.Fd()), pending, res[:len(pending)]); err != nil {
+			return err
+		}
+		for i := range pending {
+			u.complete(&reqs[batch[i]], res[i])
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for i := range reqs {
+		reqs[i].N, reqs[i].Err = 0, nil
+		if len(reqs[i].Buf) == 0 {
+			continue
+		}
+		batch[len(pending)] = i
+		pending = append(pending, reqs[i])
+		if len(pending) == uringEntries {
+			if err := submit(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(pending) > 0 {
+		if err := submit(); err != nil {
+			return err
+		}
+	}
+	runtime.KeepAlive(u.fd)
+	return nil
+}
+
+// 根据io_uring返回的结果设置请求的N和Err
+func (u *IOUring) complete(req *ReadRequest, n int32) {
+	if n < 0 && syscall.Errno(-n) != unix.EINTR && syscall.Errno(-n) != unix.EAGAIN {
+		req.Err = &os.PathError{Op: "read", Path: u.fd.Name(), Err: syscall.Errno(-n)}
+		return
+	}
+	req.N = max(int(n), 0)
+	if req.N < len(req.Buf) {
+		//没有读满时使用普通的读取补齐剩下的部分，读不到数据说明到了文件末尾
+		n, err := u.fd.ReadAt(req.Buf[req.N:], req.Offset+int64(req.N))
+		req.N += n
+		req.Err = err
+	}
+}
+
+// Write 追加写入数据
+func (u *IOUring) Write(b []byte) (int, error) {
+	return u.fd.Write(b)
+}
+
+// Sync 将文件同步到磁盘
+func (u *IOUring) Sync() error {
+	return u.fd.Sync()
+}
+
+// Close 关闭文件，io_uring实例归还之后由其他文件继续使用
+func (u *IOUring) Close() error {
+	return u.fd.Close()
+}
+
+// Size 返回文件大小
+func (u *IOUring) Size() (int64, error) {
+	stat, err := u.fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
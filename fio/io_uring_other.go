@@ -0,0 +1,18 @@
+//go:build !linux
+
+package fio
+
+import "errors"
+
+// IOUring 当前平台不支持io_uring，NewIOManager会使用标准文件IO
+type IOUring struct {
+	IOManager
+}
+
+func NewIOUringManager(fileName string) (*IOUring, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (u *IOUring) ReadBatch(reqs []ReadRequest) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,172 @@
+//go:build unix
+
+package fio
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// 没有预分配大小时，每次扩大映射的最小字节数
+const minMMapGrowSize = 1024 * 1024
+
+// WritableMMap 可读写的内存映射文件，读写直接访问映射的内存，不需要系统调用
+// 文件预先分配磁盘空间并整体映射，写入超过映射的大小时扩大文件并重新映射；
+// 已经写入的逻辑大小和文件大小分开记录，文件末尾预分配的部分全部是0，关闭时截断到逻辑大小
+type WritableMMap struct {
+	fd     *os.File
+	data   []byte //映射的内存，长度等于文件大小
+	size   int64  //逻辑大小
+	synced int64  //已经同步到磁盘的位置
+	lock   *sync.RWMutex
+}
+
+// NewWritableMMapIOManager 打开文件并映射到内存，文件小于preallocSize时扩大到preallocSize
+// 打开之前文件的大小就是逻辑大小，调用方需要保证文件末尾没有预分配留下的空间
+func NewWritableMMapIOManager(fileName string, preallocSize int64) (*WritableMMap, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, FilePermission)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	m := &WritableMMap{
+		fd:     fd,
+		size:   stat.Size(),
+		synced: stat.Size(),
+		lock:   new(sync.RWMutex),
+	}
+	capacity := stat.Size()
+	if preallocSize > capacity {
+		capacity = preallocSize
+	}
+	if err := m.remap(capacity); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// 把文件扩大到capacity并重新映射
+// 先用fallocate为整个范围分配磁盘空间再映射，磁盘已满时返回ENOSPC，
+// 只用Truncate扩大得到的是稀疏文件，写入映射时才分配空间，磁盘已满会收到SIGBUS导致进程退出
+func (m *WritableMMap) remap(capacity int64) error {
+	if capacity > 0 {
+		stat, err := m.fd.Stat()
+		if err != nil {
+			return err
+		}
+		if err := allocate(m.fd, capacity); err != nil {
+			return err
+		}
+		//文件大小的变化也需要持久化，否则崩溃之后映射中写入的数据会丢失
+		if stat.Size() < capacity {
+			if err := m.fd.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.data != nil {
+		if err := unix.Munmap(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+	if capacity == 0 {
+		return nil
+	}
+
+	data, err := unix.Mmap(int(m.fd.Fd()), 0, int(capacity), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+// Read 从文件的指定位置读取数据，不会读到逻辑大小之后预分配的部分
+func (m *WritableMMap) Read(b []byte, offset int64) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if offset >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[offset:m.size])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 在逻辑大小的位置追加数据，映射的空间不够时扩大
+func (m *WritableMMap) Write(b []byte) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	end := m.size + int64(len(b))
+	if end > int64(len(m.data)) {
+		capacity := int64(len(m.data)) * 2
+		if capacity < minMMapGrowSize {
+			capacity = minMMapGrowSize
+		}
+		if capacity < end {
+			capacity = end
+		}
+		if err := m.remap(capacity); err != nil {
+			return 0, err
+		}
+	}
+	copy(m.data[m.size:end], b)
+	m.size = end
+	return len(b), nil
+}
+
+// Sync 使用msync把上次同步之后写入的数据刷到磁盘
+func (m *WritableMMap) Sync() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.synced >= m.size {
+		return nil
+	}
+	//msync的起始地址需要按页对齐
+	start := m.synced &^ int64(os.Getpagesize()-1)
+	if err := unix.Msync(m.data[start:m.size], unix.MS_SYNC); err != nil {
+		return err
+	}
+	m.synced = m.size
+	return nil
+}
+
+// Close 解除映射，把文件截断到逻辑大小后关闭
+func (m *WritableMMap) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.data != nil {
+		if err := unix.Munmap(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+	if err := m.fd.Truncate(m.size); err != nil {
+		return err
+	}
+	return m.fd.Close()
+}
+
+// Size 返回逻辑大小
+func (m *WritableMMap) Size() (int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.size, nil
+}
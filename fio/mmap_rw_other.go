@@ -0,0 +1,14 @@
+//go:build !unix
+
+package fio
+
+import "errors"
+
+// WritableMMap 当前平台不支持可读写的内存映射，NewIOManager会使用标准文件IO
+type WritableMMap struct {
+	IOManager
+}
+
+func NewWritableMMapIOManager(fileName string, preallocSize int64) (*WritableMMap, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,389 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/fio"
+	"Bitcask_go/util"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// FsckReport 离线检查数据目录的结果
+type FsckReport struct {
+	Files          []*FsckFile        //每个数据文件的检查结果，按文件id递增排列
+	OrphanedTxns   []*FsckOrphanedTxn //没有完成标记的事务，打开DB时会被丢弃
+	HintMismatches []*FsckHintMismatch
+	MergeLeftovers []string //merge目录中残留的文件
+	MergeFinished  bool     //merge目录中的merge已经完成，下次打开DB时会替换旧的数据文件
+}
+
+// FsckFile 一个数据文件的检查结果
+type FsckFile struct {
+	Fid         uint32
+	Size        int64
+	Records     int //有效的记录数量
+	Corruptions []*FsckCorruption
+}
+
+// FsckCorruption 数据文件中一段无法解析的数据
+type FsckCorruption struct {
+	Offset int64 //损坏数据的起始位置
+	Size   int64 //到下一条有效记录之间的字节数，这部分数据已经丢失
+	Err    error //读取这个位置时的错误
+}
+
+// FsckOrphanedTxn 只写入了部分记录，没有写入完成标记的事务
+type FsckOrphanedTxn struct {
+	SeqNo   uint64
+	Records int    //已经写入的记录数量
+	Fid     uint32 //第一条记录所在的文件
+	Offset  int64  //第一条记录的位置
+}
+
+// FsckHintMismatch hint文件中和数据文件对应不上的索引
+type FsckHintMismatch struct {
+	Key    []byte
+	Pos    *data.LogRecordPos
+	Reason string
+}
+
+// Healthy 数据文件和hint文件是否都没有损坏
+func (r *FsckReport) Healthy() bool {
+	return r.LostBytes() == 0 && len(r.HintMismatches) == 0
+}
+
+// LostBytes 数据文件中所有损坏数据的大小
+func (r *FsckReport) LostBytes() int64 {
+	var lost int64
+	for _, file := range r.Files {
+		for _, c := range file.Corruptions {
+			lost += c.Size
+		}
+	}
+	return lost
+}
+
+// Fsck 离线检查数据目录，目录不能被其他DB实例使用
+// 检查所有数据文件中的记录、没有完成的事务、hint文件中的索引以及merge目录中的残留文件
+func Fsck(dirPath string, provider config.KeyProvider) (*FsckReport, error) {
+	return fsck(dirPath, data.NewCipher(provider), nil)
+}
+
+// Repair 检查dirPath，同时把所有能读出来的有效数据写入到cfg.DataDir这个新的数据目录中
+// 已经提交的事务会被写成普通记录，没有完成的事务会被丢弃；原来的数据目录不会被修改
+// cfg.KeyProvider 同时用于读取原来的数据和加密新的数据
+func Repair(dirPath string, cfg config.Configuration) (*FsckReport, error) {
+	entries, err := os.ReadDir(cfg.DataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, util.ErrRepairDirNotEmpty
+	}
+
+	target, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	report, err := fsck(dirPath, data.NewCipher(cfg.KeyProvider), target.salvageRecord)
+	if err != nil {
+		_ = target.Close()
+		return nil, err
+	}
+	if err := target.Sync(); err != nil {
+		_ = target.Close()
+		return nil, err
+	}
+	return report, target.Close()
+}
+
+// 把检查时读到的一条有效数据写入修复之后的数据目录
+func (db *DB) salvageRecord(key []byte, logRecord *data.LogRecord) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if logRecord.Type == data.LogRecordDeleted || (logRecord.Expire > 0 && logRecord.Expire <= time.Now().UnixNano()) {
+		return db.deleteLocked(key)
+	}
+	return db.putLocked(key, logRecord.Value, logRecord.Expire)
+}
+
+// 没有完成的事务中暂存的记录
+type fsckPendingTxn struct {
+	orphan  *FsckOrphanedTxn
+	keys    [][]byte
+	records []*data.LogRecord
+}
+
+// apply 不为空时，按照打开DB时的规则把生效的记录依次交给apply处理
+func fsck(dirPath string, cipher *data.Cipher, apply func(key []byte, logRecord *data.LogRecord) error) (*FsckReport, error) {
+	if _, err := os.Stat(dirPath); err != nil {
+		return nil, err
+	}
+
+	//正在使用的数据目录还在写入，检查结果没有意义
+	fileLock := flock.New(filepath.Join(dirPath, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, util.ErrDatabaseIsUsing
+	}
+	defer fileLock.Unlock()
+
+	fids, err := listDataFileIds(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FsckReport{}
+	pendingTxns := make(map[uint64]*fsckPendingTxn)
+	for _, fid := range fids {
+		dataFile, err := data.OpenDataFile(dirPath, fid, fio.StandardFIO)
+		if err != nil {
+			return nil, err
+		}
+		dataFile.Cipher = cipher
+
+		file, err := scanDataFile(dataFile, func(logRecord *data.LogRecord, offset int64) error {
+			realKey, seqNo := parseLogRecordKeyWithSeq(logRecord.Key)
+			if seqNo == nonTransactionSeqNo {
+				if apply == nil {
+					return nil
+				}
+				return apply(realKey, logRecord)
+			}
+
+			txn := pendingTxns[seqNo]
+			if logRecord.Type == data.LogRecordFinished {
+				delete(pendingTxns, seqNo)
+				if txn == nil || apply == nil {
+					return nil
+				}
+				for i, record := range txn.records {
+					if err := apply(txn.keys[i], record); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			if txn == nil {
+				txn = &fsckPendingTxn{orphan: &FsckOrphanedTxn{SeqNo: seqNo, Fid: fid, Offset: offset}}
+				pendingTxns[seqNo] = txn
+			}
+			txn.orphan.Records++
+			if apply != nil {
+				txn.keys = append(txn.keys, realKey)
+				txn.records = append(txn.records, logRecord)
+			}
+			return nil
+		})
+		_ = dataFile.Close()
+		if err != nil {
+			return nil, err
+		}
+		report.Files = append(report.Files, file)
+	}
+
+	for _, txn := range pendingTxns {
+		report.OrphanedTxns = append(report.OrphanedTxns, txn.orphan)
+	}
+	sort.Slice(report.OrphanedTxns, func(i, j int) bool {
+		return report.OrphanedTxns[i].SeqNo < report.OrphanedTxns[j].SeqNo
+	})
+
+	if err := checkHintFile(dirPath, cipher, report); err != nil {
+		return nil, err
+	}
+
+	//merge目录中的文件
+	entries, err := os.ReadDir(mergeDirPath(dirPath))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name() == fileLockName {
+			continue
+		}
+		if entry.Name() == data.MergeFinFileName {
+			report.MergeFinished = true
+		}
+		report.MergeLeftovers = append(report.MergeLeftovers, entry.Name())
+	}
+	return report, nil
+}
+
+// 扫描数据文件中的所有记录，遇到损坏的数据时逐字节向后查找下一条完整的记录
+func scanDataFile(dataFile *data.DataFile, fn func(logRecord *data.LogRecord, offset int64) error) (*FsckFile, error) {
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	file := &FsckFile{Fid: dataFile.Fid, Size: size}
+
+	var offset int64
+	for offset < size {
+		logRecord, recordSize, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			file.Records++
+			if err := fn(logRecord, offset); err != nil {
+				return nil, err
+			}
+			offset += recordSize
+			continue
+		}
+		if !isCorruptedRecordErr(err) {
+			return nil, err
+		}
+		//可读写的内存映射预先分配的空间
+		if err == io.EOF {
+			zero, zeroErr := isZeroTail(dataFile, offset, size)
+			if zeroErr != nil {
+				return nil, zeroErr
+			}
+			if zero {
+				break
+			}
+		}
+
+		next := offset + 1
+		for ; next < size; next++ {
+			if _, _, err := dataFile.ReadLogRecord(next); err == nil {
+				break
+			}
+		}
+		file.Corruptions = append(file.Corruptions, &FsckCorruption{Offset: offset, Size: next - offset, Err: err})
+		offset = next
+	}
+	return file, nil
+}
+
+// 检查hint文件中的每条索引是否指向数据文件中同一个key的记录
+func checkHintFile(dirPath string, cipher *data.Cipher, report *FsckReport) error {
+	if _, err := os.Stat(filepath.Join(dirPath, data.HintFileName)); os.IsNotExist(err) {
+		return nil
+	}
+
+	hasMerge, nonMergeFileId := false, uint32(0)
+	if _, err := os.Stat(filepath.Join(dirPath, data.MergeFinFileName)); err == nil {
+		fid, err := readNonMergeFileId(dirPath)
+		if err != nil {
+			return err
+		}
+		hasMerge, nonMergeFileId = true, fid
+	}
+
+	hintFile, err := data.OpenHintFile(dirPath)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	hintFile.Cipher = cipher
+
+	dataFiles := make(map[uint32]*data.DataFile)
+	defer func() {
+		for _, dataFile := range dataFiles {
+			if dataFile != nil {
+				_ = dataFile.Close()
+			}
+		}
+	}()
+	getDataFile := func(fid uint32) (*data.DataFile, error) {
+		if dataFile, ok := dataFiles[fid]; ok {
+			return dataFile, nil
+		}
+		if _, err := os.Stat(data.GetDataFileName(dirPath, fid)); os.IsNotExist(err) {
+			dataFiles[fid] = nil
+			return nil, nil
+		}
+		dataFile, err := data.OpenDataFile(dirPath, fid, fio.StandardFIO)
+		if err != nil {
+			return nil, err
+		}
+		dataFile.Cipher = cipher
+		dataFiles[fid] = dataFile
+		return dataFile, nil
+	}
+
+	var offset int64
+	for {
+		hintRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			report.HintMismatches = append(report.HintMismatches, &FsckHintMismatch{
+				Reason: fmt.Sprintf("hint file is corrupted at offset %d: %v", offset, err),
+			})
+			return nil
+		}
+		offset += size
+
+		pos := data.DecodeLogRecordPos(hintRecord.Value)
+		mismatch := &FsckHintMismatch{Key: hintRecord.Key, Pos: pos}
+		if hasMerge && pos.Fid >= nonMergeFileId {
+			mismatch.Reason = fmt.Sprintf("data file %d is not produced by merge", pos.Fid)
+			report.HintMismatches = append(report.HintMismatches, mismatch)
+			continue
+		}
+		dataFile, err := getDataFile(pos.Fid)
+		if err != nil {
+			return err
+		}
+		if dataFile == nil {
+			mismatch.Reason = fmt.Sprintf("data file %d not found", pos.Fid)
+			report.HintMismatches = append(report.HintMismatches, mismatch)
+			continue
+		}
+
+		logRecord, recordSize, err := dataFile.ReadLogRecord(pos.Offset)
+		switch {
+		case err != nil:
+			mismatch.Reason = fmt.Sprintf("failed to read the record: %v", err)
+		case recordSize != int64(pos.Size):
+			mismatch.Reason = fmt.Sprintf("record size is %d, but %d in hint file", recordSize, pos.Size)
+		default:
+			if realKey, _ := parseLogRecordKeyWithSeq(logRecord.Key); !bytes.Equal(realKey, hintRecord.Key) {
+				mismatch.Reason = "the record belongs to another key"
+			}
+		}
+		if mismatch.Reason != "" {
+			report.HintMismatches = append(report.HintMismatches, mismatch)
+		}
+	}
+}
+
+// 列出目录中所有数据文件的id，按递增排列
+func listDataFileIds(dirPath string) ([]uint32, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fids []uint32
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileSuffix))
+		if err != nil {
+			return nil, util.ErrDataDirCorrupted
+		}
+		fids = append(fids, uint32(fid))
+	}
+	sort.Slice(fids, func(i, j int) bool {
+		return fids[i] < fids[j]
+	})
+	return fids, nil
+}
@@ -0,0 +1,127 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsck(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 16 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+	}
+	wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+
+	//只写入了一半的事务
+	_, err = db.appendLogRecord(&data.LogRecord{Key: logRecordKeyWithSeq([]byte("orphan"), 99), Value: []byte("v")})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	report, err := Fsck(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+	assert.True(t, len(report.Files) > 1)
+	assert.Equal(t, 1, len(report.OrphanedTxns))
+	assert.Equal(t, uint64(99), report.OrphanedTxns[0].SeqNo)
+
+	//打开中的数据目录不能检查
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = Fsck(dir, nil)
+	assert.Equal(t, util.ErrDatabaseIsUsing, err)
+	assert.Nil(t, db.Close())
+
+	//损坏第一个数据文件中间的数据
+	fileName := data.GetDataFileName(dir, 0)
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	for i := len(buf) / 2; i < len(buf)/2+10; i++ {
+		buf[i] ^= 0xff
+	}
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+
+	report, err = Fsck(dir, nil)
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy())
+	assert.Equal(t, 1, len(report.Files[0].Corruptions))
+	corruption := report.Files[0].Corruptions[0]
+	assert.True(t, corruption.Offset <= int64(len(buf)/2))
+	//损坏位置之后的记录可以被找回
+	assert.True(t, corruption.Offset+corruption.Size < int64(len(buf)))
+	assert.True(t, report.LostBytes() > 0)
+
+	//修复到新的目录
+	repairOpts := config.DefaultOptions
+	repairOpts.DataDir = filepath.Join(os.TempDir(), "bitcask-go-fsck-repair")
+	_ = os.RemoveAll(repairOpts.DataDir)
+	report, err = Repair(dir, repairOpts)
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy())
+	_, err = Repair(dir, repairOpts)
+	assert.Equal(t, util.ErrRepairDirNotEmpty, err)
+
+	repaired, err := Open(repairOpts)
+	defer destroyDB(repaired)
+	assert.Nil(t, err)
+	keys := repaired.ListKeys()
+	assert.True(t, len(keys) > 490)
+	assert.True(t, len(keys) < 501)
+	val, err := repaired.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), val)
+	_, err = repaired.Get([]byte("orphan"))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	_ = os.RemoveAll(dir)
+}
+
+func TestFsck_HintMismatch(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck-hint")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 16 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	//merge完成但是还没有生效
+	report, err := Fsck(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.MergeFinished)
+	assert.True(t, len(report.MergeLeftovers) > 0)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	report, err = Fsck(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+	assert.Equal(t, 0, len(report.MergeLeftovers))
+
+	//删除merge产生的一个数据文件
+	assert.Nil(t, os.Remove(data.GetDataFileName(dir, 0)))
+	report, err = Fsck(dir, nil)
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy())
+	assert.True(t, len(report.HintMismatches) > 0)
+
+	_ = os.RemoveAll(dir)
+}
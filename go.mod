@@ -4,11 +4,14 @@ go 1.24
 
 require (
 	github.com/gofrs/flock v0.12.1
+	github.com/golang/snappy v1.0.0
 	github.com/google/btree v1.1.3
+	github.com/klauspost/compress v1.18.0
 	github.com/plar/go-adaptive-radix-tree v1.0.7
 	github.com/stretchr/testify v1.10.0
 	go.etcd.io/bbolt v1.4.2
 	golang.org/x/exp v0.0.0-20250808145144-a408d31f581a
+	golang.org/x/sys v0.29.0
 )
 
 require (
@@ -16,6 +19,5 @@ require (
 	github.com/kr/text v0.2.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
-	golang.org/x/sys v0.29.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
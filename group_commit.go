@@ -0,0 +1,172 @@
+package Bitcask_go
+
+import "sync"
+
+// writeGroup 一组写入共用的缓冲区，组内所有的记录编码之后一次写入活跃文件
+type writeGroup struct {
+	buf  []byte          //已经编码还没有写入活跃文件的数据
+	keys map[string]bool //组内已经写入的key在写入之后是否存在，组内的索引更新在写入活跃文件之后才执行
+
+	//缓冲区写入数据文件的次数，以及最近一次同步到磁盘时的写入次数，
+	//组内切换活跃文件时会先写入缓冲区，之后的写入失败时用来判断之前的请求是否已经写入
+	flushes int
+	synced  int
+}
+
+func newWriteGroup() *writeGroup {
+	return &writeGroup{}
+}
+
+func (g *writeGroup) size() int {
+	return len(g.buf)
+}
+
+func (g *writeGroup) setExists(key []byte, exists bool) {
+	if g.keys == nil {
+		g.keys = make(map[string]bool)
+	}
+	g.keys[string(key)] = exists
+}
+
+// 判断key是否存在，组内之前的写入还没有更新索引，需要先检查组内的写入
+func (g *writeGroup) exists(db *DB, key []byte) bool {
+	if exists, ok := g.keys[string(key)]; ok {
+		return exists
+	}
+	return db.index.Get(key) != nil
+}
+
+// stageFunc 把一次写入的记录编码到写入组的缓冲区中，返回的函数在数据写入活跃文件之后执行，用来更新内存索引
+type stageFunc func(g *writeGroup) (func() error, error)
+
+// writeRequest 等待group commit的一次写入
+type writeRequest struct {
+	stage stageFunc
+	sync  bool      //这次写入返回之前是否需要同步到磁盘
+	err   error     //写入的结果，done收到false之后有效
+	done  chan bool //收到true表示成为新的leader，需要负责写入队列中的请求；收到false表示已经写入完成
+}
+
+// commitQueue group commit的等待队列
+// 第一个到达的写入成为leader，获取写锁之后把队列中所有的请求编码到同一个缓冲区，一次写入活跃文件，
+// 其中任意一个请求需要同步时只执行一次fsync，然后依次更新内存索引并唤醒其他请求；
+// leader写入期间到达的请求继续排队，由leader唤醒下一个请求成为新的leader
+type commitQueue struct {
+	lock    *sync.Mutex
+	pending []*writeRequest
+	leading bool //是否有leader正在写入
+}
+
+func newCommitQueue() *commitQueue {
+	return &commitQueue{lock: new(sync.Mutex)}
+}
+
+// 通过group commit执行一次写入，返回时数据已经写入活跃文件并更新了索引，sync为true时已经同步到磁盘
+func (db *DB) commitWrite(sync bool, stage stageFunc) error {
+	req := &writeRequest{stage: stage, sync: sync, done: make(chan bool, 1)}
+
+	q := db.commitQueue
+	q.lock.Lock()
+	q.pending = append(q.pending, req)
+	if q.leading {
+		q.lock.Unlock()
+		if lead := <-req.done; !lead {
+			return req.err
+		}
+	} else {
+		q.leading = true
+		q.lock.Unlock()
+	}
+
+	//成为leader，在写锁内取出队列中所有的请求，等待写锁期间到达的请求也可以合并到这一组
+	db.mutex.Lock()
+	q.lock.Lock()
+	group := q.pending
+	q.pending = nil
+	q.lock.Unlock()
+
+	db.writeGroupLocked(group)
+	db.mutex.Unlock()
+
+	//把leader交给下一个请求，然后唤醒这一组中的其他请求
+	q.lock.Lock()
+	if len(q.pending) > 0 {
+		q.pending[0].done <- true
+	} else {
+		q.leading = false
+	}
+	q.lock.Unlock()
+
+	for _, r := range group {
+		if r != req {
+			r.done <- false
+		}
+	}
+	return req.err
+}
+
+// 把一组请求编码到同一个缓冲区中一次写入，调用方需要持有写锁
+func (db *DB) writeGroupLocked(group []*writeRequest) {
+	g := newWriteGroup()
+	applies := make([]func() error, len(group))
+	stagedAt := make([]int, len(group)) //编码完成时缓冲区写入数据文件的次数
+	var syncWrite bool
+	for i, r := range group {
+		//编码失败的请求不能在缓冲区中留下部分记录，组内key的状态只在编码成功之后修改
+		mark, fid := g.size(), db.activeFileId()
+		apply, err := r.stage(g)
+		if err != nil {
+			if db.activeFileId() == fid && g.size() >= mark {
+				g.buf = g.buf[:mark]
+			}
+			r.err = err
+			continue
+		}
+		applies[i] = apply
+		stagedAt[i] = g.flushes
+		syncWrite = syncWrite || r.sync
+	}
+
+	//写入失败时，编码之后又写入过一次缓冲区的请求已经在数据文件中，仍然要更新索引，
+	//否则重启之后这些数据会重新出现；只有还在缓冲区中的请求返回错误
+	err := db.writeGroupBuffer(g, syncWrite)
+
+	//按照请求的顺序更新内存索引，没有需要写入的数据的请求直接成功
+	for i, r := range group {
+		if r.err != nil || applies[i] == nil {
+			continue
+		}
+		if err != nil && g.flushes <= stagedAt[i] {
+			r.err = err
+			continue
+		}
+		r.err = applies[i]()
+		//已经写入但是没有同步到磁盘
+		if err != nil && r.err == nil && r.sync && g.synced <= stagedAt[i] {
+			r.err = err
+		}
+	}
+}
+
+// 只有一个写入时不需要排队，调用方需要持有写锁
+func (db *DB) writeLocked(sync bool, stage stageFunc) error {
+	g := newWriteGroup()
+	apply, err := stage(g)
+	if err != nil {
+		return err
+	}
+	if err := db.writeGroupBuffer(g, sync); err != nil {
+		return err
+	}
+	if apply == nil {
+		return nil
+	}
+	return apply()
+}
+
+func (db *DB) activeFileId() int64 {
+	if db.activeFile == nil {
+		return -1
+	}
+	return int64(db.activeFile.Fid)
+}
@@ -0,0 +1,179 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/fio"
+	"Bitcask_go/util"
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 64 * 1024
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	//并发的Put、Delete和WriteBatch合并成组写入
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := util.GetTestKey(w*1000 + i)
+				switch i % 10 {
+				case 9:
+					//删除同一个协程刚写入的key
+					assert.Nil(t, db.Delete(util.GetTestKey(w*1000+i-1)))
+				case 5:
+					wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+					assert.Nil(t, wb.Put(key, key))
+					assert.Nil(t, wb.Put(util.GetTestKey(w*1000+500+i), key))
+					assert.Nil(t, wb.Commit())
+				default:
+					assert.Nil(t, db.Put(key, key))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	check := func(db *DB) {
+		for w := 0; w < 16; w++ {
+			for i := 0; i < 100; i++ {
+				key := util.GetTestKey(w*1000 + i)
+				val, err := db.Get(key)
+				if i%10 == 8 || i%10 == 9 {
+					assert.Equal(t, util.ErrKeyNotFound, err)
+					continue
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, key, val)
+				if i%10 == 5 {
+					val, err = db.Get(util.GetTestKey(w*1000 + 500 + i))
+					assert.Nil(t, err)
+					assert.Equal(t, key, val)
+				}
+			}
+		}
+	}
+	check(db)
+	assert.Equal(t, 16*90, len(db.ListKeys()))
+
+	//重启之后从数据文件中恢复同样的数据
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_GroupCommit_DeleteInGroup(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-delete")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	//同一组中先写入再删除，删除时索引还没有更新，需要看到组内之前的写入
+	db.mutex.Lock()
+	errs := make(chan error, 3)
+	go func() { errs <- db.Put([]byte("a"), []byte("1")) }()
+	waitPending(db, 1)
+	go func() { errs <- db.Delete([]byte("a")) }()
+	waitPending(db, 2)
+	go func() { errs <- db.Delete([]byte("b")) }()
+	waitPending(db, 3)
+	db.mutex.Unlock()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, <-errs)
+	}
+
+	_, err = db.Get([]byte("a"))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+	assert.Equal(t, 0, len(db.ListKeys()))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("a"))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+}
+
+// 写入总是失败的IOManager
+type failingWriteIO struct {
+	fio.IOManager
+}
+
+func (f failingWriteIO) Write([]byte) (int, error) {
+	return 0, errFailingWrite
+}
+
+var errFailingWrite = errors.New("write failed")
+
+func TestDB_GroupCommit_PartialFailure(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-failure")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	put := func(key []byte, size int) *writeRequest {
+		return &writeRequest{stage: func(g *writeGroup) (func() error, error) {
+			return db.stagePut(g, key, util.RandomValue(size), 0)
+		}}
+	}
+	//第二个请求放不下时切换活跃文件，第一个请求在切换之前已经写入旧的文件，
+	//之后新的活跃文件写入失败，只有第二个请求失败
+	var activeIO fio.IOManager
+	breakActive := &writeRequest{stage: func(g *writeGroup) (func() error, error) {
+		activeIO = db.activeFile.IOManager
+		db.activeFile.IOManager = failingWriteIO{activeIO}
+		return nil, nil
+	}}
+	group := []*writeRequest{put([]byte("a"), 40*1024), put([]byte("b"), 40*1024), breakActive}
+
+	db.mutex.Lock()
+	db.writeGroupLocked(group)
+	db.activeFile.IOManager = activeIO
+	db.mutex.Unlock()
+
+	assert.Nil(t, group[0].err)
+	assert.Equal(t, errFailingWrite, group[1].err)
+	assert.Nil(t, group[2].err)
+	_, err = db.Get([]byte("a"))
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("b"))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	//重启之后和写入时的结果一致
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, db.ListKeys())
+}
+
+// 等待队列中有n个请求，第一个请求是正在等待写锁的leader
+func waitPending(db *DB, n int) {
+	for {
+		db.commitQueue.lock.Lock()
+		pending := len(db.commitQueue.pending)
+		db.commitQueue.lock.Unlock()
+		if pending >= n {
+			return
+		}
+		runtime.Gosched()
+	}
+}
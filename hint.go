@@ -0,0 +1,143 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/fio"
+	"io"
+	"log"
+	"os"
+)
+
+// 每个数据文件被封存之后，在后台为它生成一个hint文件，记录其中每条记录的key、类型和位置
+// 打开DB时直接从hint文件加载索引，只需要完整读取活跃文件
+
+// 活跃文件写满被封存，切换为旧数据文件使用的IO类型并生成hint文件
+// B+树索引不需要从数据文件加载索引，也就不需要hint文件
+func (db *DB) sealDataFile(dataFile *data.DataFile) error {
+	if activeIOType, sealedIOType := db.runtimeIOTypes(); activeIOType != sealedIOType {
+		//可读写的内存映射关闭时会截断预分配的空间
+		if err := dataFile.SetIOManager(db.configuration.DataDir, sealedIOType, 0); err != nil {
+			return err
+		}
+	}
+	if db.configuration.IndexerType == config.BPTree {
+		return nil
+	}
+	db.writeDataFileHintAsync(dataFile, nil)
+	return nil
+}
+
+// 为封存的数据文件生成hint文件，entries为空时先扫描数据文件
+func (db *DB) writeDataFileHintAsync(dataFile *data.DataFile, entries []indexEntry) {
+	db.hintWriters.Add(1)
+	go func() {
+		defer db.hintWriters.Done()
+		if entries == nil {
+			result := db.scanDataFileForIndex(dataFile, false)
+			if result.err != nil {
+				log.Printf("bitcask: failed to scan data file %d for hint: %v\n", dataFile.Fid, result.err)
+				return
+			}
+			entries = result.entries
+		}
+		if err := db.writeDataFileHint(dataFile.Fid, entries); err != nil {
+			log.Printf("bitcask: failed to write hint file for data file %d: %v\n", dataFile.Fid, err)
+		}
+	}()
+}
+
+// 先写入临时文件，完成之后再重命名，避免留下不完整的hint文件
+func (db *DB) writeDataFileHint(fid uint32, entries []indexEntry) (err error) {
+	fileName := data.GetHintFileName(db.configuration.DataDir, fid)
+	tmpFileName := fileName + ".tmp"
+	if err := os.Remove(tmpFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	hintFile, err := data.NewDataFile(tmpFileName, fid, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = hintFile.Close()
+			_ = os.Remove(tmpFileName)
+		}
+	}()
+
+	//攒够一批之后再写入文件，减少系统调用
+	buf := make([]byte, 0, indexLoadBufferSize)
+	for _, entry := range entries {
+		record := &data.LogRecord{
+			Key:   logRecordKeyWithSeq(entry.key, entry.seqNo),
+			Value: data.EncodeLogRecordPos(entry.pos),
+			Type:  entry.typ,
+		}
+		encRecord, _, err := data.EncodeLogRecordWithCipher(record, db.cipher)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, encRecord...)
+		if len(buf) >= indexLoadBufferSize {
+			if err := hintFile.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if err := hintFile.Write(buf); err != nil {
+		return err
+	}
+	if err := hintFile.Sync(); err != nil {
+		return err
+	}
+	if err := hintFile.Close(); err != nil {
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// 从数据文件对应的hint文件中加载索引记录
+// hint文件中的记录必须首尾相接地覆盖整个数据文件，否则说明数据文件已经变化，返回false
+func (db *DB) loadDataFileHint(fid uint32, dataFileSize int64) ([]indexEntry, bool) {
+	fileName := data.GetHintFileName(db.configuration.DataDir, fid)
+	if _, err := os.Stat(fileName); err != nil {
+		return nil, false
+	}
+	hintFile, err := data.NewDataFile(fileName, fid, fio.StandardFIO)
+	if err != nil {
+		return nil, false
+	}
+	defer hintFile.Close()
+	hintFile.Cipher = db.cipher
+
+	scanner, err := hintFile.NewScanner(indexLoadBufferSize)
+	if err != nil {
+		return nil, false
+	}
+	var entries []indexEntry
+	var offset int64
+	for {
+		logRecord, _, _, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false
+		}
+
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if pos.Fid != fid || pos.Offset != offset {
+			return nil, false
+		}
+		offset += int64(pos.Size)
+
+		key, seqNo := parseLogRecordKeyWithSeq(logRecord.Key)
+		entries = append(entries, indexEntry{key: key, seqNo: seqNo, typ: logRecord.Type, pos: pos})
+	}
+	if offset != dataFileSize {
+		return nil, false
+	}
+	return entries, true
+}
@@ -0,0 +1,109 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listHintFiles(t *testing.T, dir string) []string {
+	hintFiles, err := filepath.Glob(filepath.Join(dir, "*"+data.HintFileSuffix))
+	assert.Nil(t, err)
+	return hintFiles
+}
+
+func TestDB_DataFileHint(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-hint")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 8 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i%200), util.RandomValue(32)))
+		if i%9 == 0 {
+			assert.Nil(t, db.Delete(util.GetTestKey((i+3)%200)))
+		}
+		if i%50 == 0 {
+			wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+			for j := 0; j < 20; j++ {
+				assert.Nil(t, wb.Put(util.GetTestKey(j), util.RandomValue(32)))
+			}
+			assert.Nil(t, wb.Commit())
+		}
+	}
+	keys := db.ListKeys()
+	sealedNum := len(db.olderFiles)
+	assert.Nil(t, db.Close())
+
+	//每个封存的数据文件都有hint文件
+	assert.Equal(t, sealedNum, len(listHintFiles(t, dir)))
+
+	//旧数据文件的内容被破坏之后仍然可以从hint文件加载出完整的索引，说明没有读取数据文件
+	fileName := data.GetDataFileName(dir, 1)
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(fileName, make([]byte, info.Size()), 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db.ListKeys())
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+
+	//hint文件和数据文件对应不上时扫描数据文件
+	hintFileName := data.GetHintFileName(dir, 2)
+	hintBuf, err := os.ReadFile(hintFileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(hintFileName, hintBuf[:len(hintBuf)/2], 0644))
+	assert.Nil(t, os.Remove(data.GetHintFileName(dir, 3)))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db.ListKeys())
+	assert.Nil(t, db.Close())
+
+	//重新扫描之后补上hint文件
+	assert.Equal(t, sealedNum, len(listHintFiles(t, dir)))
+	rebuilt, err := os.ReadFile(hintFileName)
+	assert.Nil(t, err)
+	assert.Equal(t, len(hintBuf), len(rebuilt))
+
+	_ = os.RemoveAll(dir)
+}
+
+func TestDB_DataFileHint_Merge(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-hint-merge")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 8 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(32)))
+	}
+	for i := 0; i < 900; i++ {
+		assert.Nil(t, db.Delete(util.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+
+	//merge之后的数据文件使用hint-index加载，没有单独的hint文件
+	nonMergeFileId, err := db.getNonMergeFileId(dir)
+	assert.Nil(t, err)
+	for fid := uint32(0); fid < nonMergeFileId; fid++ {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
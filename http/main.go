@@ -107,6 +107,67 @@ func handleStat(writer http.ResponseWriter, request *http.Request) {
 	_ = json.NewEncoder(writer).Encode(stat)
 }
 
+// watchEvent 通过SSE推送给客户端的数据变更事件
+type watchEvent struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+	SeqNo uint64 `json:"seq"`
+}
+
+// handleWatch 以Server-Sent Events的形式推送key以prefix开头的数据变更
+func handleWatch(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	prefix := request.URL.Query().Get("prefix")
+	events, cancel := db.Watch([]byte(prefix))
+	defer cancel()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			var name string
+			switch event.Type {
+			case bitcask.EventPut:
+				name = "put"
+			case bitcask.EventDelete:
+				name = "delete"
+			case bitcask.EventOverflow:
+				//客户端需要重新同步数据
+				name = "overflow"
+			}
+			payload, _ := json.Marshal(watchEvent{
+				Key:   string(event.Key),
+				Value: string(event.Value),
+				SeqNo: event.SeqNo,
+			})
+			if _, err := fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", name, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
 func main() {
 	//register func
 	http.HandleFunc("/bitcask/put", handlePut)
@@ -114,6 +175,7 @@ func main() {
 	http.HandleFunc("/bitcask/delete", handleDelete)
 	http.HandleFunc("/bitcask/listkeys", handleListKeys)
 	http.HandleFunc("/bitcask/stat", handleStat)
+	http.HandleFunc("/bitcask/watch", handleWatch)
 
 	// start http service
 	http.ListenAndServe("localhost:8080", nil)
@@ -27,6 +27,9 @@ func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogR
 	art.lock.Lock()
 	oldItem, _ := art.tree.Insert(key, pos)
 	art.lock.Unlock()
+	if oldItem == nil {
+		return nil
+	}
 	return oldItem.(*data.LogRecordPos)
 }
 
@@ -46,6 +49,9 @@ func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
 	oldItem, ok := art.tree.Delete(key)
 	art.lock.Unlock()
+	if oldItem == nil {
+		return nil, false
+	}
 	return oldItem.(*data.LogRecordPos), ok
 }
 
@@ -64,6 +70,19 @@ func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	return newARTIterator(art.tree, reverse)
 }
 
+// Snapshot 拷贝一份完整的基数树
+func (art *AdaptiveRadixTree) Snapshot() (Indexer, error) {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	snapshot := NewART()
+	art.tree.ForEach(func(node goart.Node) bool {
+		snapshot.tree.Insert(node.Key(), node.Value())
+		return true
+	})
+	return snapshot, nil
+}
+
 func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
@@ -75,10 +94,6 @@ type artIterator struct {
 }
 
 func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	if tree.Size() == 0 {
-		return nil
-	}
-
 	var idx int
 	if reverse {
 		idx = tree.Size() - 1
@@ -149,6 +164,10 @@ func (art *artIterator) Value() *data.LogRecordPos {
 	}
 	return nil
 }
+
+func (art *artIterator) Err() error {
+	return nil
+}
 func (art *artIterator) Close() {
 	art.values = nil // 清理迭代器中的数据
 	art.currIndex = 0
@@ -2,30 +2,46 @@ package index
 
 import (
 	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
 	"path/filepath"
+	"sort"
 
 	"go.etcd.io/bbolt"
 )
 
 const bptreeIndexFileName = "bptree-index"
 
-var indexBucketName = []byte("bitcask-index")
+var (
+	indexBucketName          = []byte("bitcask-index")
+	encryptedIndexBucketName = []byte("bitcask-encrypted-index")
+	indexMetaBucketName      = []byte("bitcask-index-meta")
+	lookupSecretKey          = []byte("lookup-secret")
+)
+
+// 加密时bbolt中的key是原始key的HMAC，value是加密之后的 原始key + 位置信息
+const lookupSecretSize = 32
 
 type BPlusTree struct {
-	tree *bbolt.DB //already concurrent access
+	tree   *bbolt.DB    //already concurrent access
+	cipher *data.Cipher //不为空时加密保存key和位置信息
+	secret []byte       //计算HMAC使用的密钥，使用cipher加密之后保存在bbolt中
 }
 
 // 初始化B+ 树索引
 func NewBPTree(dirPath string, syncWrites bool) *BPlusTree {
-	opts := bbolt.DefaultOptions
-	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := openBPTree(dirPath, syncWrites)
 	if err != nil {
 		panic("failed to open bptree")
 	}
 	//创建对应的bucket
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		tx.CreateBucketIfNotExists(indexBucketName)
+		_, err := tx.CreateBucketIfNotExists(indexBucketName)
 		return err
 	}); err != nil {
 		panic("failed to create bucket in bptree")
@@ -33,32 +49,239 @@ func NewBPTree(dirPath string, syncWrites bool) *BPlusTree {
 	return &BPlusTree{tree: bptree}
 }
 
+// NewEncryptedBPTree 初始化加密的B+树索引，之前没有加密的索引会被加密，
+// 更换密钥之后所有的索引会使用新的密钥重新加密
+func NewEncryptedBPTree(dirPath string, syncWrites bool, cipher *data.Cipher) (*BPlusTree, error) {
+	bptree, err := openBPTree(dirPath, syncWrites)
+	if err != nil {
+		return nil, err
+	}
+	bpt := &BPlusTree{tree: bptree, cipher: cipher}
+
+	var hasPlaintext bool
+	if err := bptree.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(indexMetaBucketName)
+		if err != nil {
+			return err
+		}
+		if err := bpt.loadSecret(meta); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(encryptedIndexBucketName)
+		if err != nil {
+			return err
+		}
+		if err := bpt.reencrypt(bucket); err != nil {
+			return err
+		}
+
+		//加密之前写入的明文索引
+		plainBucket := tx.Bucket(indexBucketName)
+		if plainBucket == nil {
+			return nil
+		}
+		hasPlaintext = plainBucket.Stats().KeyN > 0
+		if err := plainBucket.ForEach(func(k, v []byte) error {
+			lookupKey := bpt.lookupKey(k)
+			value, err := bpt.encodeEntry(lookupKey, k, data.DecodeLogRecordPos(v))
+			if err != nil {
+				return err
+			}
+			return bucket.Put(lookupKey, value)
+		}); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(indexBucketName)
+	}); err != nil {
+		_ = bptree.Close()
+		return nil, err
+	}
+
+	//bbolt不会清空释放的页，重写一遍索引文件，确保文件中不再有明文
+	if hasPlaintext {
+		if bpt.tree, err = compactBPTree(dirPath, syncWrites, bptree); err != nil {
+			return nil, err
+		}
+	}
+	return bpt, nil
+}
+
+func openBPTree(dirPath string, syncWrites bool) (*bbolt.DB, error) {
+	opts := *bbolt.DefaultOptions
+	opts.NoSync = !syncWrites
+	return bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, &opts)
+}
+
+// 将索引拷贝到一个新的文件中，替换原来的索引文件
+func compactBPTree(dirPath string, syncWrites bool, src *bbolt.DB) (*bbolt.DB, error) {
+	fileName := filepath.Join(dirPath, bptreeIndexFileName)
+	compactFileName := fileName + ".compact"
+	_ = os.Remove(compactFileName)
+
+	dst, err := bbolt.Open(compactFileName, 0644, nil)
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	err = bbolt.Compact(dst, src, 0)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := src.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(compactFileName)
+		return nil, err
+	}
+	if err := os.Rename(compactFileName, fileName); err != nil {
+		return nil, err
+	}
+	return openBPTree(dirPath, syncWrites)
+}
+
+// 读取HMAC密钥，第一次使用时随机生成；当前密钥变化时使用新的密钥重新加密
+func (bpt *BPlusTree) loadSecret(meta *bbolt.Bucket) error {
+	sealed := meta.Get(lookupSecretKey)
+	if sealed == nil {
+		bpt.secret = make([]byte, lookupSecretSize)
+		if _, err := rand.Read(bpt.secret); err != nil {
+			return err
+		}
+	} else {
+		secret, err := bpt.cipher.Decrypt(sealed, lookupSecretKey)
+		if err != nil {
+			return err
+		}
+		bpt.secret = secret
+		if data.EncryptedKeyId(sealed) == bpt.cipher.CurrentKeyId() {
+			return nil
+		}
+	}
+
+	sealed, err := bpt.cipher.Encrypt(bpt.secret, lookupSecretKey)
+	if err != nil {
+		return err
+	}
+	return meta.Put(lookupSecretKey, sealed)
+}
+
+// 使用当前密钥重新加密旧密钥加密的索引
+func (bpt *BPlusTree) reencrypt(bucket *bbolt.Bucket) error {
+	currentKeyId := bpt.cipher.CurrentKeyId()
+	var lookupKeys [][]byte
+	if err := bucket.ForEach(func(k, v []byte) error {
+		if data.EncryptedKeyId(v) != currentKeyId {
+			lookupKeys = append(lookupKeys, append([]byte{}, k...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, lookupKey := range lookupKeys {
+		key, pos, err := bpt.decodeEntry(lookupKey, bucket.Get(lookupKey))
+		if err != nil {
+			return err
+		}
+		value, err := bpt.encodeEntry(lookupKey, key, pos)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(lookupKey, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bpt *BPlusTree) bucketName() []byte {
+	if bpt.cipher != nil {
+		return encryptedIndexBucketName
+	}
+	return indexBucketName
+}
+
+// 索引在bbolt中的key，加密时使用HMAC，不会暴露原始key
+func (bpt *BPlusTree) lookupKey(key []byte) []byte {
+	if bpt.cipher == nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, bpt.secret)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+// 索引在bbolt中的value，加密时为 原始key的长度 + 原始key + 位置信息 加密之后的数据
+func (bpt *BPlusTree) encodeEntry(lookupKey, key []byte, pos *data.LogRecordPos) ([]byte, error) {
+	if bpt.cipher == nil {
+		return data.EncodeLogRecordPos(pos), nil
+	}
+	encPos := data.EncodeLogRecordPos(pos)
+	buf := make([]byte, binary.MaxVarintLen32+len(key)+len(encPos))
+	var index = binary.PutUvarint(buf, uint64(len(key)))
+	index += copy(buf[index:], key)
+	index += copy(buf[index:], encPos)
+	return bpt.cipher.Encrypt(buf[:index], lookupKey)
+}
+
+func (bpt *BPlusTree) decodeEntry(lookupKey, value []byte) ([]byte, *data.LogRecordPos, error) {
+	if bpt.cipher == nil {
+		key := make([]byte, len(lookupKey))
+		copy(key, lookupKey)
+		return key, data.DecodeLogRecordPos(value), nil
+	}
+	buf, err := bpt.cipher.Decrypt(value, lookupKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keySize, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < keySize {
+		return nil, nil, util.ErrDataFileCorrupted
+	}
+	key := buf[n : n+int(keySize)]
+	return key, data.DecodeLogRecordPos(buf[n+int(keySize):]), nil
+}
+
+// 解码位置信息，value为空表示key不存在
+func (bpt *BPlusTree) decodePos(lookupKey, value []byte) (*data.LogRecordPos, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	_, pos, err := bpt.decodeEntry(lookupKey, value)
+	return pos, err
+}
+
 // Put 向索引中添加key对应的位置信息
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
+	lookupKey := bpt.lookupKey(key)
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
-		oldVal = bucket.Get(key)
-		return bucket.Put(key, data.EncodeLogRecordPos(pos))
+		bucket := tx.Bucket(bpt.bucketName())
+		var err error
+		if oldPos, err = bpt.decodePos(lookupKey, bucket.Get(lookupKey)); err != nil {
+			return err
+		}
+		value, err := bpt.encodeEntry(lookupKey, key, pos)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(lookupKey, value)
 	}); err != nil {
 		panic("failed to put value in bptree")
 	}
-	if len(oldVal) == 0 {
-		return nil
-	}
-	return data.DecodeLogRecordPos(oldVal)
+	return oldPos
 }
 
 // Get 根据key获取索引中对应的位置信息
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 	var pos *data.LogRecordPos
+	lookupKey := bpt.lookupKey(key)
 	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
-		value := bucket.Get(key)
-		if len(value) != 0 {
-			pos = data.DecodeLogRecordPos(value)
-		}
-		return nil
+		bucket := tx.Bucket(bpt.bucketName())
+		var err error
+		pos, err = bpt.decodePos(lookupKey, bucket.Get(lookupKey))
+		return err
 	}); err != nil {
 		panic("failed to get value in bptree")
 	}
@@ -67,29 +290,26 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 
 // Delete 删除索引中key对应的位置信息
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	var ok bool
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
+	lookupKey := bpt.lookupKey(key)
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
-		if oldVal = bucket.Get(key); len(oldVal) != 0 {
-			ok = true
-			return bucket.Delete(key)
+		bucket := tx.Bucket(bpt.bucketName())
+		var err error
+		if oldPos, err = bpt.decodePos(lookupKey, bucket.Get(lookupKey)); err != nil || oldPos == nil {
+			return err
 		}
-		return nil
+		return bucket.Delete(lookupKey)
 	}); err != nil {
 		panic("failed to delete key in bptree")
 	}
-	if len(oldVal) == 0 {
-		return nil, false
-	}
-	return data.DecodeLogRecordPos(oldVal), ok
+	return oldPos, oldPos != nil
 }
 
 // Size 获取索引中元素的数量
 func (bpt *BPlusTree) Size() int {
 	var size int
 	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
+		bucket := tx.Bucket(bpt.bucketName())
 		size = bucket.Stats().KeyN
 		return nil
 	}); err != nil {
@@ -98,9 +318,25 @@ func (bpt *BPlusTree) Size() int {
 	return size
 }
 
-// Iterator 获取索引迭代器
+// Iterator 获取索引迭代器，加密之后bbolt中的key是按HMAC排序的，遍历时逐个解密，
+// 只有Seek时才需要解密所有的元素按key排序
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+	return newBptreeIterator(bpt, reverse)
+}
+
+// Snapshot 将磁盘上的B+树索引拷贝到内存中的BTree，加密的索引无法解密时返回错误
+func (bpt *BPlusTree) Snapshot() (Indexer, error) {
+	snapshot := NewBTree(32)
+	iterator := newBptreeIterator(bpt, false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		//没有加密时key指向bbolt的内存，只在事务内有效
+		snapshot.Put(append([]byte(nil), iterator.Key()...), iterator.Value())
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
 }
 
 func (bpt *BPlusTree) Close() error {
@@ -109,48 +345,118 @@ func (bpt *BPlusTree) Close() error {
 
 // B+树迭代器
 type bptreeIterator struct {
-	tx        *bbolt.Tx
-	cursor    *bbolt.Cursor
-	reverse   bool
-	currKey   []byte
-	currValue []byte
+	bpt     *BPlusTree
+	tx      *bbolt.Tx
+	cursor  *bbolt.Cursor
+	reverse bool
+	currKey []byte
+	currPos *data.LogRecordPos
+	err     error //解码失败之后迭代器不再有效
+
+	//加密时第一次Seek之后解密所有的元素并按key排序，之后在sorted上遍历
+	sorted    []*Item
+	currIndex int
 }
 
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
-	tx, err := tree.Begin(false)
+func newBptreeIterator(bpt *BPlusTree, reverse bool) *bptreeIterator {
+	tx, err := bpt.tree.Begin(false)
 	if err != nil {
 		panic("failed to begin a transaction")
 	}
 
 	bpi := bptreeIterator{
+		bpt:     bpt,
 		tx:      tx,
-		cursor:  tx.Bucket(indexBucketName).Cursor(),
+		cursor:  tx.Bucket(bpt.bucketName()).Cursor(),
 		reverse: reverse,
 	}
 	bpi.Rewind()
 	return &bpi
 }
 
+// 解码游标当前指向的元素，加密时在这里解密
+func (bpi *bptreeIterator) set(key, value []byte) {
+	bpi.currKey, bpi.currPos = nil, nil
+	if key == nil || bpi.err != nil {
+		return
+	}
+	if bpi.bpt.cipher == nil {
+		bpi.currKey, bpi.currPos = key, data.DecodeLogRecordPos(value)
+		return
+	}
+	bpi.currKey, bpi.currPos, bpi.err = bpi.bpt.decodeEntry(key, value)
+	if bpi.err != nil {
+		bpi.currKey, bpi.currPos = nil, nil
+	}
+}
+
+// 排序之后遍历sorted
+func (bpi *bptreeIterator) setIndex(i int) {
+	bpi.currIndex = i
+	if i < len(bpi.sorted) {
+		bpi.currKey, bpi.currPos = bpi.sorted[i].key, bpi.sorted[i].pos
+	} else {
+		bpi.currKey, bpi.currPos = nil, nil
+	}
+}
+
 // Rewind 重置迭代器
 func (bpi *bptreeIterator) Rewind() {
-	if bpi.reverse {
-		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+	if bpi.sorted != nil {
+		bpi.setIndex(0)
+	} else if bpi.reverse {
+		bpi.set(bpi.cursor.Last())
 	} else {
-		bpi.currKey, bpi.currValue = bpi.cursor.First()
+		bpi.set(bpi.cursor.First())
 	}
 }
 
 // Seek 根据key查找第一个大于(或小于)等于key的元素
 func (bpi *bptreeIterator) Seek(key []byte) {
-	bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	if bpi.bpt.cipher == nil {
+		bpi.set(bpi.cursor.Seek(key))
+		return
+	}
+	if bpi.sorted == nil && !bpi.sort() {
+		return
+	}
+	bpi.setIndex(sort.Search(len(bpi.sorted), func(i int) bool {
+		cmp := bytes.Compare(bpi.sorted[i].key, key)
+		if bpi.reverse {
+			return cmp <= 0
+		}
+		return cmp >= 0
+	}))
+}
+
+// 解密所有的元素并按key排序，解密失败时返回false
+func (bpi *bptreeIterator) sort() bool {
+	sorted := make([]*Item, 0, bpi.tx.Bucket(bpi.bpt.bucketName()).Stats().KeyN)
+	for bpi.set(bpi.cursor.First()); bpi.currKey != nil; bpi.set(bpi.cursor.Next()) {
+		sorted = append(sorted, &Item{key: bpi.currKey, pos: bpi.currPos})
+	}
+	if bpi.err != nil {
+		return false
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		cmp := bytes.Compare(sorted[i].key, sorted[j].key)
+		if bpi.reverse {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	bpi.sorted = sorted
+	return true
 }
 
 // Next 移动到下一个元素
 func (bpi *bptreeIterator) Next() {
-	if bpi.reverse {
-		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+	if bpi.sorted != nil {
+		bpi.setIndex(bpi.currIndex + 1)
+	} else if bpi.reverse {
+		bpi.set(bpi.cursor.Prev())
 	} else {
-		bpi.currKey, bpi.currValue = bpi.cursor.Next()
+		bpi.set(bpi.cursor.Next())
 	}
 }
 
@@ -166,7 +472,12 @@ func (bpi *bptreeIterator) Key() []byte {
 
 // Value 获取当前元素的位置信息
 func (bpi *bptreeIterator) Value() *data.LogRecordPos {
-	return data.DecodeLogRecordPos(bpi.currValue)
+	return bpi.currPos
+}
+
+// Err 加密的索引无法解密时返回错误
+func (bpi *bptreeIterator) Err() error {
+	return bpi.err
 }
 
 // Close 关闭迭代器
@@ -3,7 +3,6 @@ package index
 import (
 	"Bitcask_go/data"
 	"bytes"
-	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -50,73 +49,101 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	btree_item := bt.tree.Delete(it)
 	bt.lock.Unlock()
 
-	return btree_item.(*Item).pos, btree_item != nil
+	if btree_item == nil {
+		return nil, false
+	}
+	return btree_item.(*Item).pos, true
 }
 
 func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+// Iterator 基于写时复制克隆一份只读的BTree，迭代时按批次从副本中读取，不需要拷贝整个索引
 func (bt *BTree) Iterator(reverse bool) Iterator {
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return newBTreeIterator(bt.tree.Clone(), reverse)
+}
+
+// Snapshot 基于写时复制的克隆，只有被修改的节点才会被复制
+func (bt *BTree) Snapshot() (Indexer, error) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}, nil
 }
 
 func (bt *BTree) Close() error {
 	return nil
 }
 
+// 迭代器每次从BTree中读取的元素数量
+const btreeIteratorBatchSize = 128
+
 type btreeIterator struct {
-	currIndex int     //当前索引的下标
-	reverse   bool    //是否反向迭代
-	values    []*Item //存储迭代器位置对应的 Item
+	tree      *btree.BTree //创建迭代器时克隆的只读副本
+	currIndex int          //当前元素在values中的下标
+	reverse   bool         //是否反向迭代
+	values    []*Item      //当前批次的 Item
 }
 
 func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	// 遍历 BTree 并将所有元素存储到 values 中
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
+	it := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
+		values:  make([]*Item, 0, btreeIteratorBatchSize),
 	}
+	it.Rewind()
+	return it
+}
 
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
+// 从pivot开始读取一批元素，pivot为空时从头(或尾)开始，skipPivot表示跳过和pivot相等的元素
+func (it *btreeIterator) load(pivot *Item, skipPivot bool) {
+	it.currIndex = 0
+	it.values = it.values[:0]
+
+	saveValues := func(bi btree.Item) bool {
+		item := bi.(*Item)
+		if skipPivot && bytes.Equal(item.key, pivot.key) {
+			return true
+		}
+		it.values = append(it.values, item)
+		return len(it.values) < btreeIteratorBatchSize
 	}
 
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values[:idx], // 截取有效部分
+	switch {
+	case pivot == nil && it.reverse:
+		it.tree.Descend(saveValues)
+	case pivot == nil:
+		it.tree.Ascend(saveValues)
+	case it.reverse:
+		it.tree.DescendLessOrEqual(pivot, saveValues)
+	default:
+		it.tree.AscendGreaterOrEqual(pivot, saveValues)
 	}
 }
 
 func (it *btreeIterator) Rewind() {
-	it.currIndex = 0
+	it.load(nil, false)
 }
 
+// Seek 正向查找第一个大于等于 key 的元素，反向查找第一个小于等于 key 的元素
 func (it *btreeIterator) Seek(key []byte) {
-	if it.reverse {
-		// 反向查找第一个小于等于 key 的元素
-		it.currIndex = sort.Search(len(it.values), func(i int) bool {
-			return bytes.Compare(it.values[i].key, key) <= 0
-		})
-	} else {
-		// 正向查找第一个大于等于 key 的元素
-		it.currIndex = sort.Search(len(it.values), func(i int) bool {
-			return bytes.Compare(it.values[i].key, key) >= 0
-		})
-	}
+	it.load(&Item{key: key}, false)
 }
 
 func (it *btreeIterator) Next() {
+	if !it.Valid() {
+		return
+	}
 	it.currIndex += 1
+	//当前批次已经读完，从最后一个元素之后继续读取下一批
+	if it.currIndex == len(it.values) && len(it.values) == btreeIteratorBatchSize {
+		it.load(it.values[len(it.values)-1], true)
+	}
 }
 
 func (it *btreeIterator) Valid() bool {
@@ -136,7 +163,12 @@ func (it *btreeIterator) Value() *data.LogRecordPos {
 	}
 	return nil
 }
+func (it *btreeIterator) Err() error {
+	return nil
+}
+
 func (it *btreeIterator) Close() {
+	it.tree = nil
 	it.values = nil // 清理迭代器中的数据
 	it.currIndex = 0
 }
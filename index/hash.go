@@ -0,0 +1,391 @@
+package index
+
+import (
+	"Bitcask_go/data"
+	"bytes"
+	"encoding/binary"
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+const (
+	//哈希表初始的槽位数量，必须是2的幂
+	hashIndexInitSlots = 1024
+
+	//每个entry块包含 1<<hashEntryChunkBits 个entry
+	hashEntryChunkBits = 12
+
+	//key存储区的块从64KB开始倍增，最大4MB
+	hashArenaMinChunkSize = 64 * 1024
+	hashArenaMaxChunkSize = 4 * 1024 * 1024
+)
+
+// hashSlot 哈希表的槽位，只保存指纹和entry的下标，空槽位的开销很小
+type hashSlot struct {
+	fingerprint uint32 //key哈希值的高32位，0表示空槽位
+	entry       uint32
+}
+
+// hashEntry 一个key的位置信息，直接展开存储，不单独分配LogRecordPos
+type hashEntry struct {
+	keyRef uint64 //key在存储区中的位置
+	offset int64
+	expire int64
+	fid    uint32
+	size   uint32
+}
+
+func (e *hashEntry) pos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: e.fid, Offset: e.offset, Size: e.size, Expire: e.expire}
+}
+
+func (e *hashEntry) setPos(pos *data.LogRecordPos) {
+	e.fid, e.offset, e.size, e.expire = pos.Fid, pos.Offset, pos.Size, pos.Expire
+}
+
+// keyArena 只追加的key存储区，每个key前面是varint编码的长度，避免每个key单独分配内存
+// 已经写入的字节不会被修改，迭代器和快照可以直接引用；删除的key在整理时回收
+type keyArena struct {
+	chunks  [][]byte
+	size    int64 //写入的总字节数
+	garbage int64 //已经删除的key占用的字节数
+}
+
+// 写入key，返回的位置中高32位是块的下标，低32位是块内的偏移
+func (a *keyArena) add(key []byte) uint64 {
+	need := len(key) + binary.MaxVarintLen32
+	n := len(a.chunks)
+	if n == 0 || cap(a.chunks[n-1])-len(a.chunks[n-1]) < need {
+		chunkSize := hashArenaMaxChunkSize
+		if n < 6 {
+			chunkSize = hashArenaMinChunkSize << n
+		}
+		if need > chunkSize {
+			chunkSize = need
+		}
+		a.chunks = append(a.chunks, make([]byte, 0, chunkSize))
+		n++
+	}
+	chunk := a.chunks[n-1]
+	ref := uint64(n-1)<<32 | uint64(len(chunk))
+	chunk = binary.AppendUvarint(chunk, uint64(len(key)))
+	a.chunks[n-1] = append(chunk, key...)
+	a.size += int64(uvarintSize(uint64(len(key))) + len(key))
+	return ref
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
+
+func (a *keyArena) get(ref uint64) []byte {
+	chunk := a.chunks[ref>>32][uint32(ref):]
+	keySize, n := binary.Uvarint(chunk)
+	return chunk[n : n+int(keySize) : n+int(keySize)]
+}
+
+// 拷贝块的列表，之后的写入不会追加到共享的块中
+func (a *keyArena) clone() *keyArena {
+	chunks := make([][]byte, len(a.chunks))
+	for i, chunk := range a.chunks {
+		chunks[i] = chunk[:len(chunk):len(chunk)]
+	}
+	return &keyArena{chunks: chunks, size: a.size, garbage: a.garbage}
+}
+
+// HashTable 开放寻址(线性探测)的哈希索引，只支持单个key的操作，迭代器是无序的
+// 每个key占用一个32字节的entry、key本身以及8字节的槽位(按负载因子计算)，比有序的树索引节省大量内存
+type HashTable struct {
+	slots   []hashSlot
+	entries [][]hashEntry //按块分配，增加entry时不需要拷贝已有的entry
+	free    []uint32      //已经删除可以复用的entry
+	used    uint32        //分配过的entry数量
+	count   int
+	arena   *keyArena
+	seed    maphash.Seed
+	lock    *sync.RWMutex
+}
+
+func NewHashTable() *HashTable {
+	return &HashTable{
+		slots: make([]hashSlot, hashIndexInitSlots),
+		arena: &keyArena{},
+		seed:  maphash.MakeSeed(),
+		lock:  new(sync.RWMutex),
+	}
+}
+
+func (h *HashTable) hash(key []byte) uint64 {
+	return maphash.Bytes(h.seed, key)
+}
+
+func fingerprintOf(hash uint64) uint32 {
+	fp := uint32(hash >> 32)
+	if fp == 0 {
+		fp = 1
+	}
+	return fp
+}
+
+func (h *HashTable) entry(id uint32) *hashEntry {
+	return &h.entries[id>>hashEntryChunkBits][id&(1<<hashEntryChunkBits-1)]
+}
+
+func (h *HashTable) allocEntry() uint32 {
+	if n := len(h.free); n > 0 {
+		id := h.free[n-1]
+		h.free = h.free[:n-1]
+		return id
+	}
+	id := h.used
+	if int(id>>hashEntryChunkBits) == len(h.entries) {
+		h.entries = append(h.entries, make([]hashEntry, 1<<hashEntryChunkBits))
+	}
+	h.used++
+	return id
+}
+
+// 查找key所在的槽位，不存在时返回应该插入的空槽位
+func (h *HashTable) find(key []byte, hash uint64) (uint64, bool) {
+	fp := fingerprintOf(hash)
+	mask := uint64(len(h.slots) - 1)
+	for i := hash & mask; ; i = (i + 1) & mask {
+		s := h.slots[i]
+		if s.fingerprint == 0 {
+			return i, false
+		}
+		if s.fingerprint == fp && bytes.Equal(h.arena.get(h.entry(s.entry).keyRef), key) {
+			return i, true
+		}
+	}
+}
+
+// 使用slotNum个槽位重新构建哈希表，删除的key较多时同时整理key存储区
+func (h *HashTable) rehash(slotNum int) {
+	oldSlots, oldArena := h.slots, h.arena
+	compact := oldArena.garbage > oldArena.size/2
+	if compact {
+		h.arena = &keyArena{}
+	}
+
+	h.slots = make([]hashSlot, slotNum)
+	mask := uint64(slotNum - 1)
+	for _, s := range oldSlots {
+		if s.fingerprint == 0 {
+			continue
+		}
+		e := h.entry(s.entry)
+		key := oldArena.get(e.keyRef)
+		if compact {
+			e.keyRef = h.arena.add(key)
+		}
+		i := h.hash(key) & mask
+		for h.slots[i].fingerprint != 0 {
+			i = (i + 1) & mask
+		}
+		h.slots[i] = s
+	}
+}
+
+// Put 向索引中添加key对应的位置信息
+func (h *HashTable) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	//负载因子超过3/4时扩容
+	if (h.count+1)*4 > len(h.slots)*3 {
+		h.rehash(len(h.slots) * 2)
+	}
+
+	hash := h.hash(key)
+	i, ok := h.find(key, hash)
+	if ok {
+		e := h.entry(h.slots[i].entry)
+		oldPos := e.pos()
+		e.setPos(pos)
+		return oldPos
+	}
+
+	id := h.allocEntry()
+	e := h.entry(id)
+	e.keyRef = h.arena.add(key)
+	e.setPos(pos)
+	h.slots[i] = hashSlot{fingerprint: fingerprintOf(hash), entry: id}
+	h.count++
+	return nil
+}
+
+// Get 根据key获取索引中对应的位置信息
+func (h *HashTable) Get(key []byte) *data.LogRecordPos {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	i, ok := h.find(key, h.hash(key))
+	if !ok {
+		return nil
+	}
+	return h.entry(h.slots[i].entry).pos()
+}
+
+// Delete 删除索引中key对应的位置信息
+func (h *HashTable) Delete(key []byte) (*data.LogRecordPos, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	i, ok := h.find(key, h.hash(key))
+	if !ok {
+		return nil, false
+	}
+	id := h.slots[i].entry
+	e := h.entry(id)
+	oldPos := e.pos()
+	h.arena.garbage += int64(uvarintSize(uint64(len(key))) + len(key))
+	*e = hashEntry{}
+	h.free = append(h.free, id)
+	h.count--
+
+	//把后面探测链上的元素向前移动填补空位，这样不需要墓碑标记
+	mask := uint64(len(h.slots) - 1)
+	hole := i
+	for j := (hole + 1) & mask; h.slots[j].fingerprint != 0; j = (j + 1) & mask {
+		k := h.hash(h.arena.get(h.entry(h.slots[j].entry).keyRef)) & mask
+		//k在(hole, j]之间时，j上的元素不能移动到hole
+		if (hole < j && hole < k && k <= j) || (hole > j && (hole < k || k <= j)) {
+			continue
+		}
+		h.slots[hole] = h.slots[j]
+		hole = j
+	}
+	h.slots[hole] = hashSlot{}
+
+	//删除的key占用了一半以上的存储区时整理
+	if h.arena.garbage > hashArenaMaxChunkSize && h.arena.garbage > h.arena.size/2 {
+		h.rehash(len(h.slots))
+	}
+	return oldPos, true
+}
+
+// Size 获取索引中元素的数量
+func (h *HashTable) Size() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.count
+}
+
+// Iterator 获取索引迭代器，哈希索引的迭代顺序是不确定的
+func (h *HashTable) Iterator(reverse bool) Iterator {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	values := make([]hashEntry, 0, h.count)
+	for _, s := range h.slots {
+		if s.fingerprint != 0 {
+			values = append(values, *h.entry(s.entry))
+		}
+	}
+	if reverse {
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	return &hashIterator{values: values, arena: h.arena.clone(), reverse: reverse}
+}
+
+// Snapshot 拷贝一份哈希表，key存储区中已有的数据是只读的，可以共享
+func (h *HashTable) Snapshot() (Indexer, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	slots := make([]hashSlot, len(h.slots))
+	copy(slots, h.slots)
+	entries := make([][]hashEntry, len(h.entries))
+	for i, chunk := range h.entries {
+		entries[i] = make([]hashEntry, len(chunk))
+		copy(entries[i], chunk)
+	}
+	return &HashTable{
+		slots:   slots,
+		entries: entries,
+		free:    append([]uint32(nil), h.free...),
+		used:    h.used,
+		count:   h.count,
+		arena:   h.arena.clone(),
+		seed:    h.seed,
+		lock:    new(sync.RWMutex),
+	}, nil
+}
+
+func (h *HashTable) Close() error {
+	return nil
+}
+
+// hashIterator 哈希索引的迭代器，Reverse只是反过来遍历同一个无序的结果
+// 第一次Seek时才按key排序，之后的遍历都是有序的
+type hashIterator struct {
+	currIndex int
+	values    []hashEntry
+	arena     *keyArena
+	reverse   bool
+	sorted    bool
+}
+
+func (it *hashIterator) Rewind() {
+	it.currIndex = 0
+}
+
+// Seek 哈希索引中的key是无序的，先对所有key排序，再二分查找第一个大于(或小于)等于key的元素
+func (it *hashIterator) Seek(key []byte) {
+	if !it.sorted {
+		sort.Slice(it.values, func(i, j int) bool {
+			cmp := bytes.Compare(it.arena.get(it.values[i].keyRef), it.arena.get(it.values[j].keyRef))
+			if it.reverse {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+		it.sorted = true
+	}
+	it.currIndex = sort.Search(len(it.values), func(i int) bool {
+		cmp := bytes.Compare(it.arena.get(it.values[i].keyRef), key)
+		if it.reverse {
+			return cmp <= 0
+		}
+		return cmp >= 0
+	})
+}
+
+func (it *hashIterator) Next() {
+	it.currIndex += 1
+}
+
+func (it *hashIterator) Valid() bool {
+	return it.currIndex >= 0 && it.currIndex < len(it.values)
+}
+
+func (it *hashIterator) Key() []byte {
+	if it.Valid() {
+		return it.arena.get(it.values[it.currIndex].keyRef)
+	}
+	return nil
+}
+
+func (it *hashIterator) Value() *data.LogRecordPos {
+	if it.Valid() {
+		return it.values[it.currIndex].pos()
+	}
+	return nil
+}
+
+func (it *hashIterator) Err() error {
+	return nil
+}
+
+func (it *hashIterator) Close() {
+	it.values = nil
+	it.currIndex = 0
+}
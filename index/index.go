@@ -24,6 +24,9 @@ type Indexer interface {
 	// Iterator 获取索引迭代器
 	Iterator(reverse bool) Iterator
 
+	// Snapshot 获取索引当前状态的只读副本，之后对索引的修改不会影响到副本
+	Snapshot() (Indexer, error)
+
 	// Close 关闭索引(bptree)
 	Close() error
 }
@@ -41,6 +44,9 @@ const (
 
 	//B+树索引
 	BPTree
+
+	//哈希索引，只适合单个key的读写，迭代器无序，Seek时需要先对所有key排序
+	HashIndex
 )
 
 func NewIndexer(tp IndexerType, dirPath string, sync bool) Indexer {
@@ -50,10 +56,11 @@ func NewIndexer(tp IndexerType, dirPath string, sync bool) Indexer {
 	case ART:
 		return NewART()
 	case SkipListIndex:
-		//return NewSkipList(util.StringComprator)
-		return nil
+		return NewSkipList()
 	case BPTree:
 		return NewBPTree(dirPath, sync)
+	case HashIndex:
+		return NewHashTable()
 	default:
 		panic("unsupport indexer type.")
 	}
@@ -88,6 +95,9 @@ type Iterator interface {
 	//Value 获取当前元素的位置信息
 	Value() *data.LogRecordPos
 
+	//Err 遍历过程中出现的错误，例如索引中的数据无法解码，出错之后迭代器不再有效
+	Err() error
+
 	//Close 关闭迭代器
 	Close()
 }
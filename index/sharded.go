@@ -0,0 +1,207 @@
+package index
+
+import (
+	"Bitcask_go/data"
+	"bytes"
+	"container/heap"
+)
+
+// ShardedIndex 按key的哈希把数据分到多个子索引中，每个子索引有自己的锁，减少并发访问时的锁竞争
+// 单个key的操作只访问对应的子索引，迭代器对所有子索引的迭代器做多路归并，保持全局有序
+type ShardedIndex struct {
+	shards []Indexer
+}
+
+// NewShardedIndex 创建shardNum个tp类型的子索引，B+树索引所有数据在同一个文件中，不支持分片
+func NewShardedIndex(tp IndexerType, shardNum int) *ShardedIndex {
+	shards := make([]Indexer, shardNum)
+	for i := range shards {
+		shards[i] = NewIndexer(tp, "", false)
+	}
+	return &ShardedIndex{shards: shards}
+}
+
+// NewShardedIndexer 分片数量大于1时创建分片索引，否则和 NewIndexer 相同
+func NewShardedIndexer(tp IndexerType, shardNum int, dirPath string, sync bool) Indexer {
+	if shardNum <= 1 || tp == BPTree {
+		return NewIndexer(tp, dirPath, sync)
+	}
+	return NewShardedIndex(tp, shardNum)
+}
+
+// 使用FNV-1a哈希选择key所在的子索引
+func (si *ShardedIndex) shard(key []byte) Indexer {
+	h := uint32(2166136261)
+	for _, b := range key {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return si.shards[h%uint32(len(si.shards))]
+}
+
+// Put 向索引中添加key对应的位置信息
+func (si *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return si.shard(key).Put(key, pos)
+}
+
+// Get 根据key获取索引中对应的位置信息
+func (si *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	return si.shard(key).Get(key)
+}
+
+// Delete 删除索引中key对应的位置信息
+func (si *ShardedIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return si.shard(key).Delete(key)
+}
+
+// Size 获取索引中元素的数量
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// Iterator 获取索引迭代器
+func (si *ShardedIndex) Iterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		iters[i] = shard.Iterator(reverse)
+	}
+	it := &shardedIterator{iters: iters, heap: &iteratorHeap{reverse: reverse}}
+	it.rebuild()
+	return it
+}
+
+// Snapshot 对每个子索引做快照，调用方需要保证期间没有写入，否则各个子索引的快照不是同一时刻的
+func (si *ShardedIndex) Snapshot() (Indexer, error) {
+	shards := make([]Indexer, len(si.shards))
+	for i, shard := range si.shards {
+		snapshot, err := shard.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		shards[i] = snapshot
+	}
+	return &ShardedIndex{shards: shards}, nil
+}
+
+func (si *ShardedIndex) Close() error {
+	for _, shard := range si.shards {
+		if err := shard.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardedIterator 多路归并子索引的迭代器，不同子索引中的key不会重复
+type shardedIterator struct {
+	iters []Iterator
+	heap  *iteratorHeap //还有数据的子索引迭代器，堆顶是当前元素
+}
+
+// 使用所有还有数据的子索引迭代器重新建堆
+func (it *shardedIterator) rebuild() {
+	it.heap.iters = it.heap.iters[:0]
+	for _, iter := range it.iters {
+		if iter.Valid() {
+			it.heap.iters = append(it.heap.iters, iter)
+		}
+	}
+	heap.Init(it.heap)
+}
+
+func (it *shardedIterator) Rewind() {
+	for _, iter := range it.iters {
+		iter.Rewind()
+	}
+	it.rebuild()
+}
+
+func (it *shardedIterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.rebuild()
+}
+
+func (it *shardedIterator) Next() {
+	if !it.Valid() {
+		return
+	}
+	top := it.heap.iters[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(it.heap, 0)
+	} else {
+		heap.Pop(it.heap)
+	}
+}
+
+func (it *shardedIterator) Valid() bool {
+	return len(it.heap.iters) > 0
+}
+
+func (it *shardedIterator) Key() []byte {
+	if it.Valid() {
+		return it.heap.iters[0].Key()
+	}
+	return nil
+}
+
+func (it *shardedIterator) Value() *data.LogRecordPos {
+	if it.Valid() {
+		return it.heap.iters[0].Value()
+	}
+	return nil
+}
+
+func (it *shardedIterator) Err() error {
+	for _, iter := range it.iters {
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *shardedIterator) Close() {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+	it.heap.iters = nil
+}
+
+// iteratorHeap 按当前key排序的迭代器堆，反向迭代时key最大的在堆顶
+type iteratorHeap struct {
+	iters   []Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.iters)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.iters[i].Key(), h.iters[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.iters[i], h.iters[j] = h.iters[j], h.iters[i]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	h.iters = append(h.iters, x.(Iterator))
+}
+
+func (h *iteratorHeap) Pop() any {
+	last := h.iters[len(h.iters)-1]
+	h.iters = h.iters[:len(h.iters)-1]
+	return last
+}
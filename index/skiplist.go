@@ -8,10 +8,11 @@
 package index
 
 import (
-	"Bitcask_go/util"
-
+	"Bitcask_go/data"
+	"bytes"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -21,173 +22,296 @@ const (
 	Branching = 4
 )
 
+// Node 跳表节点，next指针和位置信息都通过原子操作读写，读操作不需要加锁
 type Node struct {
-	// Key-value data
-	key interface{}
+	key []byte
 
-	// Pointers to next nodes at each level
-	next []*Node
+	pos atomic.Pointer[data.LogRecordPos]
 
-	// Current level of the node
-	level int
+	// Pointers to next nodes at each level, len(next) is the height of the node
+	next []atomic.Pointer[Node]
 }
 
-func NewNode(key interface{}, level int) *Node {
-	return &Node{
-		key:   key,
-		next:  make([]*Node, level+1),
-		level: level,
+func NewNode(key []byte, pos *data.LogRecordPos, height int) *Node {
+	nd := &Node{
+		key:  key,
+		next: make([]atomic.Pointer[Node], height),
 	}
+	nd.pos.Store(pos)
+	return nd
 }
 
-func (nd *Node) SetNext(n int, x *Node) bool {
-	if n >= nd.level {
-		return false
-	}
-	nd.next[n] = x
-	return true
+func (nd *Node) SetNext(n int, x *Node) {
+	nd.next[n].Store(x)
 }
 
 func (nd *Node) GetNext(n int) *Node {
-	if n >= nd.level {
-		return nil
-	}
-	return nd.next[n]
+	return nd.next[n].Load()
 }
 
+// SkipList 跳表索引
+// 写操作之间通过互斥锁串行执行，读操作和迭代器不加锁，通过原子读取next指针遍历；
+// 删除时只把节点从前驱节点上摘除，被删除节点的next指针保持不变，正在经过它的读操作仍然可以继续向后遍历
 type SkipList struct {
 	// Skip list head node
 	head *Node
 
-	// Mutex for thread safety
-	mutex *sync.RWMutex
+	// Mutex for writers
+	mutex *sync.Mutex
 
 	// Current maximum level of the skip list
-	maxHeight int
+	maxHeight atomic.Int32
 
-	// Comprator
-	cmp util.Comprator
+	size atomic.Int64
 }
 
-func NewSkipList(cp util.Comprator) *SkipList {
-	return &SkipList{
-		head:      NewNode(nil, MaxHeight),
-		mutex:     new(sync.RWMutex),
-		maxHeight: 1, //初始高度为1， 但是head的next数组是有MaxHeight层
-		cmp:       cp,
+func NewSkipList() *SkipList {
+	sl := &SkipList{
+		head:  NewNode(nil, nil, MaxHeight),
+		mutex: new(sync.Mutex),
 	}
+	sl.maxHeight.Store(1) //初始高度为1， 但是head的next数组是有MaxHeight层
+	return sl
 }
 
 func (sl *SkipList) GetCurrentHeight() int {
-	return sl.maxHeight
-}
-
-// KeyIsAfterNode returns the given key is greater than
-// Node's key, return true means we need to keep searching in this list
-func (sl *SkipList) KeyIsAfterNode(key interface{}, nd *Node) bool {
-	if nd == nil {
-		return false //search in lower level
-	}
-	res, _ := sl.cmp(key, nd.key)
-	return res > 0
+	return int(sl.maxHeight.Load())
 }
 
 // GetGreaterOrEqual returns the first node whose key is >= given key
-func (sl *SkipList) GetGreaterOrEqual(key interface{}) (*Node, [MaxHeight]*Node) {
+// prevs不为空时记录每一层中最后一个小于key的节点
+func (sl *SkipList) GetGreaterOrEqual(key []byte, prevs *[MaxHeight]*Node) *Node {
 	cur := sl.head
 	level := sl.GetCurrentHeight() - 1
-	var prevs [MaxHeight]*Node
 	for {
 		next := cur.GetNext(level)
-		if sl.KeyIsAfterNode(key, next) {
+		if next != nil && bytes.Compare(next.key, key) < 0 {
 			//keep searching in this list
 			cur = next
-		} else {
-			// The key greater than cur but less than or equal to next
-			// cur ------key ------next
-			// maybe key equal to next or less than next
-
-			//Before jump to low level, we need to store current level value
+			continue
+		}
+		if prevs != nil {
 			prevs[level] = cur
-
-			if level == 0 {
-				//if we already at the level0
-				// res, err := sl.cmp(key, cur.key)
-				// if err == nil && res == 0 {
-				return next, prevs
-				// } else {
-				// 	return nil, prevs
-				// }
-			} else {
-				// Switch to next list
-				level = level - 1
-			}
 		}
+		if level == 0 {
+			return next
+		}
+		// Switch to next list
+		level--
 	}
 }
 
-func (sl *SkipList) Insert(key interface{}) {
-	_, prevs := sl.GetGreaterOrEqual(key)
-	new_height := sl.randomHeight()
-
-	if sl.GetCurrentHeight() < new_height {
-		for i := sl.GetCurrentHeight(); i < new_height; i++ {
-			prevs[i] = sl.head //这个高度之前没有人达到，用head进行补充，因为head的next数组是最高的
+// 返回最后一个小于key的节点，没有时返回head
+func (sl *SkipList) getLessThan(key []byte) *Node {
+	cur := sl.head
+	level := sl.GetCurrentHeight() - 1
+	for {
+		next := cur.GetNext(level)
+		if next != nil && bytes.Compare(next.key, key) < 0 {
+			cur = next
+			continue
+		}
+		if level == 0 {
+			return cur
 		}
-		sl.maxHeight = new_height
+		level--
 	}
+}
 
-	new_node := NewNode(key, new_height)
-	for i := 0; i < new_height; i++ {
-		//  prevs[i]  -> new_node -> prevs[i].next[i]
-		new_node.SetNext(i, prevs[i].GetNext(i))
-		prevs[i].SetNext(i, new_node)
+// 返回最后一个节点，跳表为空时返回head
+func (sl *SkipList) getLast() *Node {
+	cur := sl.head
+	level := sl.GetCurrentHeight() - 1
+	for {
+		if next := cur.GetNext(level); next != nil {
+			cur = next
+			continue
+		}
+		if level == 0 {
+			return cur
+		}
+		level--
 	}
-
 }
 
-func (sl *SkipList) Contains(key interface{}) bool {
-	if key == nil {
-		return false
+// Put 向索引中添加key对应的位置信息
+func (sl *SkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	var prevs [MaxHeight]*Node
+	nd := sl.GetGreaterOrEqual(key, &prevs)
+	if nd != nil && bytes.Equal(nd.key, key) {
+		return nd.pos.Swap(pos)
 	}
-	tar, _ := sl.GetGreaterOrEqual(key)
-	if tar == nil {
-		return false
+
+	height := randomHeight()
+	if cur := sl.GetCurrentHeight(); cur < height {
+		for i := cur; i < height; i++ {
+			prevs[i] = sl.head //这个高度之前没有人达到，用head进行补充，因为head的next数组是最高的
+		}
+		sl.maxHeight.Store(int32(height))
 	}
-	res, err := sl.cmp(key, tar.key)
-	if err == nil && res == 0 {
-		return true
+
+	//从下往上链接，读操作在高层看到新节点时低层一定也已经链接好了
+	nd = NewNode(key, pos, height)
+	for i := 0; i < height; i++ {
+		//  prevs[i]  -> nd -> prevs[i].next[i]
+		nd.SetNext(i, prevs[i].GetNext(i))
+		prevs[i].SetNext(i, nd)
 	}
-	return false
+	sl.size.Add(1)
+	return nil
 }
 
-func (sl *SkipList) Delete(key interface{}) bool {
-	if !sl.Contains(key) {
-		return false
+// Get 根据key获取索引中对应的位置信息
+func (sl *SkipList) Get(key []byte) *data.LogRecordPos {
+	nd := sl.GetGreaterOrEqual(key, nil)
+	if nd == nil || !bytes.Equal(nd.key, key) {
+		return nil
 	}
+	return nd.pos.Load()
+}
 
-	target, prevs := sl.GetGreaterOrEqual(key)
-	if nil == target {
-		return false
-	}
-	res, err := sl.cmp(key, target.key)
-	if err != nil || res != 0 {
-		return false
+// Delete 删除索引中key对应的位置信息
+func (sl *SkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	var prevs [MaxHeight]*Node
+	target := sl.GetGreaterOrEqual(key, &prevs)
+	if target == nil || !bytes.Equal(target.key, key) {
+		return nil, false
 	}
 
 	//这个地方应该使用target的高度去遍历，target level小于跳表当前高度情况下，高于target level的perv的next并不指向target，不能修改
-	//wrong: for i := 0; i < sl.GetCurrentHeight(); i++
-	for i := 0; i < target.level; i++ {
+	for i := len(target.next) - 1; i >= 0; i-- {
 		prevs[i].SetNext(i, target.GetNext(i))
 	}
-	target = nil
-	return true
+	sl.size.Add(-1)
+	return target.pos.Load(), true
 }
 
-func (sl *SkipList) randomHeight() int {
+// Size 获取索引中元素的数量
+func (sl *SkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+// Iterator 获取索引迭代器，迭代器直接遍历跳表，可以看到创建之后的修改
+func (sl *SkipList) Iterator(reverse bool) Iterator {
+	it := &skiplistIterator{sl: sl, reverse: reverse}
+	it.Rewind()
+	return it
+}
+
+// Snapshot 按顺序拷贝一份跳表，拷贝期间阻塞写操作
+func (sl *SkipList) Snapshot() (Indexer, error) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	snapshot := NewSkipList()
+	var tails [MaxHeight]*Node
+	for i := range tails {
+		tails[i] = snapshot.head
+	}
+	maxHeight := 1
+	for cur := sl.head.GetNext(0); cur != nil; cur = cur.GetNext(0) {
+		//节点是有序的，直接链接到每一层的末尾
+		height := randomHeight()
+		if height > maxHeight {
+			maxHeight = height
+		}
+		nd := NewNode(cur.key, cur.pos.Load(), height)
+		for i := 0; i < height; i++ {
+			tails[i].SetNext(i, nd)
+			tails[i] = nd
+		}
+	}
+	snapshot.maxHeight.Store(int32(maxHeight))
+	snapshot.size.Store(sl.size.Load())
+	return snapshot, nil
+}
+
+func (sl *SkipList) Close() error {
+	return nil
+}
+
+func randomHeight() int {
 	height := 1
 	for height < MaxHeight && (rand.Intn(Branching) == 0) { // 1/4 enter higher level.
 		height++
 	}
 	return height
 }
+
+// skiplistIterator 跳表迭代器，正向沿着第0层移动，反向每次查找前一个节点
+type skiplistIterator struct {
+	sl      *SkipList
+	reverse bool
+	node    *Node
+	pos     *data.LogRecordPos //移动到当前节点时读取的位置信息
+}
+
+func (it *skiplistIterator) Rewind() {
+	if it.reverse {
+		it.setNode(it.sl.getLast())
+	} else {
+		it.setNode(it.sl.head.GetNext(0))
+	}
+}
+
+func (it *skiplistIterator) Seek(key []byte) {
+	nd := it.sl.GetGreaterOrEqual(key, nil)
+	if it.reverse && (nd == nil || !bytes.Equal(nd.key, key)) {
+		// 反向查找第一个小于等于 key 的元素
+		nd = it.sl.getLessThan(key)
+	}
+	it.setNode(nd)
+}
+
+func (it *skiplistIterator) Next() {
+	if it.node == nil {
+		return
+	}
+	if it.reverse {
+		it.setNode(it.sl.getLessThan(it.node.key))
+	} else {
+		//当前节点被删除之后next指针仍然有效
+		it.setNode(it.node.GetNext(0))
+	}
+}
+
+func (it *skiplistIterator) setNode(nd *Node) {
+	if nd == it.sl.head {
+		nd = nil
+	}
+	it.node = nd
+	it.pos = nil
+	if nd != nil {
+		it.pos = nd.pos.Load()
+	}
+}
+
+func (it *skiplistIterator) Valid() bool {
+	return it.node != nil
+}
+
+func (it *skiplistIterator) Key() []byte {
+	if it.Valid() {
+		return it.node.key
+	}
+	return nil
+}
+
+func (it *skiplistIterator) Value() *data.LogRecordPos {
+	return it.pos
+}
+
+func (it *skiplistIterator) Err() error {
+	return nil
+}
+
+func (it *skiplistIterator) Close() {
+	it.node = nil
+	it.pos = nil
+}
@@ -0,0 +1,133 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/data"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// 启动时扫描数据文件每次读取的字节数
+const indexLoadBufferSize = 4 * 1024 * 1024
+
+// 扫描数据文件得到的一条索引记录
+type indexEntry struct {
+	key   []byte //去掉事务序列号之后的key
+	seqNo uint64
+	typ   data.LogRecordType
+	pos   *data.LogRecordPos
+}
+
+// 一个数据文件的扫描结果
+type dataFileScanResult struct {
+	entries  []indexEntry //按写入顺序排列
+	offset   int64        //最后一条有效记录之后的位置
+	fileSize int64
+	err      error //offset位置的记录读取失败的原因，正常读到文件末尾时为空
+	fromHint bool  //是否是从数据文件对应的hint文件中加载的
+}
+
+// 并行扫描数据文件，按照文件顺序返回扫描结果
+type dataFilesScanner struct {
+	results []chan *dataFileScanResult
+	window  chan struct{} //已经开始扫描但是结果还没有被取走的文件数量
+	done    chan struct{}
+	running *sync.WaitGroup //正在扫描的协程
+	next    int
+}
+
+// 开始扫描数据文件，同时存在的扫描结果不超过并发数，调用方取走结果之后才会扫描更后面的文件
+func (db *DB) scanDataFilesForIndex(dataFiles []*data.DataFile) *dataFilesScanner {
+	concurrency := db.configuration.IndexLoadWorkers
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	s := &dataFilesScanner{
+		results: make([]chan *dataFileScanResult, len(dataFiles)),
+		window:  make(chan struct{}, concurrency),
+		done:    make(chan struct{}),
+		running: new(sync.WaitGroup),
+	}
+	for i := range s.results {
+		s.results[i] = make(chan *dataFileScanResult, 1)
+	}
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		for i, dataFile := range dataFiles {
+			select {
+			case s.window <- struct{}{}:
+			case <-s.done:
+				return
+			}
+			s.running.Add(1)
+			go func(i int, dataFile *data.DataFile) {
+				defer s.running.Done()
+				//活跃文件还在写入，没有hint文件
+				s.results[i] <- db.scanDataFileForIndex(dataFile, dataFile != db.activeFile)
+			}(i, dataFile)
+		}
+	}()
+	return s
+}
+
+// Next 等待并返回下一个文件的扫描结果
+func (s *dataFilesScanner) Next() *dataFileScanResult {
+	result := <-s.results[s.next]
+	s.next++
+	<-s.window
+	return result
+}
+
+// Stop 不再扫描还没有开始的文件，并等待正在扫描的文件完成，之后才能关闭数据文件
+func (s *dataFilesScanner) Stop() {
+	close(s.done)
+	s.running.Wait()
+}
+
+// 扫描一个数据文件中的所有记录，useHint为true时优先从对应的hint文件中加载
+func (db *DB) scanDataFileForIndex(dataFile *data.DataFile, useHint bool) *dataFileScanResult {
+	result := &dataFileScanResult{}
+	fileSize, err := dataFile.IOManager.Size()
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.fileSize = fileSize
+
+	if useHint {
+		if entries, ok := db.loadDataFileHint(dataFile.Fid, fileSize); ok {
+			result.entries = entries
+			result.offset = fileSize
+			result.fromHint = true
+			return result
+		}
+	}
+	scanner, err := dataFile.NewScanner(indexLoadBufferSize)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	for {
+		logRecord, offset, size, err := scanner.Next()
+		if err != nil {
+			result.offset = offset
+			if err != io.EOF || offset < result.fileSize {
+				result.err = err
+			}
+			return result
+		}
+
+		//构造索引中的记录
+		key, seqNo := parseLogRecordKeyWithSeq(logRecord.Key)
+		result.entries = append(result.entries, indexEntry{
+			key:   key,
+			seqNo: seqNo,
+			typ:   logRecord.Type,
+			pos:   &data.LogRecordPos{Fid: dataFile.Fid, Offset: offset, Size: uint32(size), Expire: logRecord.Expire},
+		})
+	}
+}
@@ -63,20 +63,26 @@ func (it *Iterator) Value() ([]byte, error) {
 	return val, nil
 }
 
+// Err 遍历过程中索引出现的错误，例如加密的索引无法解密，出错之后迭代器不再有效
+func (it *Iterator) Err() error {
+	return it.indexIter.Err()
+}
+
 // Close 关闭迭代器
 func (it *Iterator) Close() {
 	it.indexIter.Close()
 }
 
+// SkipToNext 跳过前缀不匹配以及已经过期的元素
 func (it *Iterator) SkipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return // 没有前缀，直接返回
-	}
 
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if it.indexIter.Value().IsExpired() {
+			continue
+		}
 		key := it.indexIter.Key()
-		if len(key) >= prefixLen && string(key[:prefixLen]) == string(it.options.Prefix) {
+		if prefixLen == 0 || (len(key) >= prefixLen && string(key[:prefixLen]) == string(it.options.Prefix)) {
 			break
 		}
 	}
@@ -1,6 +1,7 @@
 package Bitcask_go
 
 import (
+	"Bitcask_go/config"
 	"Bitcask_go/data"
 	"Bitcask_go/util"
 	"io"
@@ -9,11 +10,15 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+
+	//merge时每次从数据文件中读取的字节数
+	mergeReadBufferSize = 1024 * 1024
 )
 
 func (db *DB) Merge() error {
@@ -62,6 +67,10 @@ func (db *DB) Merge() error {
 	}
 
 	db.olderFiles[db.activeFile.Fid] = db.activeFile
+	if err := db.sealDataFile(db.activeFile); err != nil {
+		db.mutex.Unlock()
+		return err
+	}
 	if err := db.setActiveDataFile(); err != nil {
 		db.mutex.Unlock()
 		return err
@@ -97,6 +106,8 @@ func (db *DB) Merge() error {
 	mergeConfig := db.configuration
 	mergeConfig.DataDir = mergePath
 	mergeConfig.SyncWrites = false
+	//merge完成之后统一同步，重写数据的过程中不需要同步，也不启动后台同步
+	mergeConfig.SyncPolicy = config.SyncNever
 
 	mergeDB, err := Open(mergeConfig)
 	if err != nil {
@@ -108,11 +119,16 @@ func (db *DB) Merge() error {
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 	//遍历处理每个datafile
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		//按顺序读取整个文件，使用scanner减少小IO
+		scanner, err := dataFile.NewScanner(mergeReadBufferSize)
+		if err != nil {
+			return err
+		}
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			logRecord, offset, _, err := scanner.Next()
 			if err != nil {
 				if err == io.EOF {
 					break
@@ -122,8 +138,9 @@ func (db *DB) Merge() error {
 
 			realKey, _ := parseLogRecordKeyWithSeq(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
-			//和内存中的索引位置进行比较，如果有效就重写
-			if logRecordPos != nil && logRecordPos.Fid == dataFile.Fid && logRecordPos.Offset == offset {
+			//和内存中的索引位置进行比较，如果有效就重写，已经过期的数据直接丢弃
+			if logRecordPos != nil && logRecordPos.Fid == dataFile.Fid && logRecordPos.Offset == offset &&
+				!data.IsExpired(logRecord.Expire) {
 				//这已经是一条有效数据了，不需要事务序列号了
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
 				//这是一个新的DB，只有merge操作会操作此DB，所以可以用无锁版本
@@ -137,8 +154,6 @@ func (db *DB) Merge() error {
 					return err
 				}
 			}
-
-			offset += size
 		}
 	}
 
@@ -176,8 +191,12 @@ func (db *DB) Merge() error {
 // eg. current db dir: /tmp/bitcask-go
 // Invoke this func will acquire /tmp/bitcask-go-merge
 func (db *DB) getMergePath() string {
-	dir := path.Dir(path.Clean(db.configuration.DataDir)) // /tmp
-	base := path.Base(db.configuration.DataDir)           // bitcask-go
+	return mergeDirPath(db.configuration.DataDir)
+}
+
+func mergeDirPath(dataDir string) string {
+	dir := path.Dir(path.Clean(dataDir)) // /tmp
+	base := path.Base(dataDir)           // bitcask-go
 	return path.Join(dir, base+mergeDirName)
 }
 
@@ -209,6 +228,14 @@ func (db *DB) loadMergeFiles() error {
 		if entry.Name() == data.SeqNoFileName {
 			continue
 		}
+		//密钥校验文件以数据目录中的为准
+		if entry.Name() == data.EncryptionCheckFileName {
+			continue
+		}
+		//merge之后的数据文件使用hint-index加载索引
+		if strings.HasSuffix(entry.Name(), data.HintFileSuffix) {
+			continue
+		}
 		//文件锁所在的文件也不需要拷贝
 		if entry.Name() == fileLockName {
 			continue
@@ -234,6 +261,9 @@ func (db *DB) loadMergeFiles() error {
 				return err
 			}
 		}
+		if err := os.Remove(data.GetHintFileName(db.configuration.DataDir, fileId)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
 	//将新的数据文件移动到数据目录中
@@ -248,10 +278,16 @@ func (db *DB) loadMergeFiles() error {
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
+	return readNonMergeFileId(dirPath)
+}
+
+// 从merge完成标记文件中读取没有参与merge的第一个文件id
+func readNonMergeFileId(dirPath string) (uint32, error) {
 	mergeFinFile, err := data.OpenMergeFinFile(dirPath)
 	if err != nil {
 		return 0, err
 	}
+	defer mergeFinFile.Close()
 
 	record, _, err := mergeFinFile.ReadLogRecord(0)
 	if err != nil {
@@ -279,6 +315,7 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 
 	//读取文件中的索引
 	var offset int64 = 0
@@ -292,8 +329,10 @@ func (db *DB) loadIndexFromHintFile() error {
 		}
 
 		//解码拿到对应的LogRecordPos
-		pos := data.DecodeLogRecordPos(logRecord.Key)
-		db.index.Put(logRecord.Key, pos)
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if !pos.IsExpired() {
+			db.index.Put(logRecord.Key, pos)
+		}
 		offset += size
 	}
 	return nil
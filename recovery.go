@@ -0,0 +1,67 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"io"
+	"log"
+	"os"
+)
+
+// 截断数据文件从offset开始的损坏部分
+// 进程在写入过程中崩溃会在活跃文件末尾留下不完整的记录，这部分数据从来没有写入成功，直接丢弃；
+// 旧数据文件已经同步到磁盘，其中的损坏说明磁盘数据有问题，截断会丢失损坏位置之后所有的有效记录，
+// 所以默认拒绝打开，只有设置了TruncateSealedFile才截断
+func (db *DB) truncateCorruptedData(dataFile *data.DataFile, offset, fileSize int64, cause error) error {
+	//记录能完整读出来但是解密、解压失败，不是写入不完整导致的
+	if !isCorruptedRecordErr(cause) {
+		return cause
+	}
+
+	//可读写的内存映射预先分配的空间，进程没有正常关闭时会留在活跃文件末尾，不是损坏的数据
+	if cause == io.EOF {
+		zero, err := isZeroTail(dataFile, offset, fileSize)
+		if err != nil {
+			return err
+		}
+		if zero {
+			return os.Truncate(data.GetDataFileName(db.configuration.DataDir, dataFile.Fid), offset)
+		}
+	}
+
+	isActive := dataFile == db.activeFile
+	if !isActive && !db.configuration.TruncateSealedFile {
+		log.Printf("bitcask: sealed data file %d is corrupted at offset %d: %v\n", dataFile.Fid, offset, cause)
+		return util.ErrDataFileCorrupted
+	}
+
+	log.Printf("bitcask: data file %d is corrupted at offset %d: %v, truncate %d bytes\n",
+		dataFile.Fid, offset, cause, fileSize-offset)
+	return os.Truncate(data.GetDataFileName(db.configuration.DataDir, dataFile.Fid), offset)
+}
+
+// 数据文件从offset开始到文件末尾是否全部是0
+func isZeroTail(dataFile *data.DataFile, offset, fileSize int64) (bool, error) {
+	buf := make([]byte, 64*1024)
+	for offset < fileSize {
+		n := int64(len(buf))
+		if n > fileSize-offset {
+			n = fileSize - offset
+		}
+		if _, err := dataFile.IOManager.Read(buf[:n], offset); err != nil && err != io.EOF {
+			return false, err
+		}
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		offset += n
+	}
+	return true, nil
+}
+
+// 读取记录时的错误是否说明这个位置的数据已经损坏
+func isCorruptedRecordErr(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == util.ErrInvalidCRC
+}
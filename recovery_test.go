@@ -0,0 +1,103 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Open_TornTail(t *testing.T) {
+	for _, mmapAtStartup := range []bool{true, false} {
+		opts := config.DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-torn-tail")
+		opts.DataDir = dir
+		opts.MMapAtStartup = mmapAtStartup
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(32)))
+		}
+		assert.Nil(t, db.Close())
+
+		//模拟写入一半时崩溃，在活跃文件末尾留下不完整的记录
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("torn"), Value: util.RandomValue(64)})
+		fileName := data.GetDataFileName(dir, 0)
+		info, err := os.Stat(fileName)
+		assert.Nil(t, err)
+		file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+		assert.Nil(t, err)
+		_, err = file.Write(encRecord[:len(encRecord)/2])
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 100, len(db.ListKeys()))
+		info2, err := os.Stat(fileName)
+		assert.Nil(t, err)
+		assert.Equal(t, info.Size(), info2.Size())
+
+		//截断之后可以正常写入
+		assert.Nil(t, db.Put([]byte("after"), []byte("recovery")))
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		val, err := db.Get([]byte("after"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("recovery"), val)
+		assert.Equal(t, 101, len(db.ListKeys()))
+		destroyDB(db)
+	}
+}
+
+func TestDB_Open_CorruptedSealedFile(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-corrupted-sealed")
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 16 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), util.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	//有hint文件的旧数据文件不会被扫描，删除之后才能在打开时发现损坏
+	hintFiles, err := filepath.Glob(filepath.Join(dir, "*"+data.HintFileSuffix))
+	assert.Nil(t, err)
+	assert.True(t, len(hintFiles) > 0)
+	for _, fileName := range hintFiles {
+		assert.Nil(t, os.Remove(fileName))
+	}
+
+	//修改第一个数据文件中间的一个字节
+	fileName := data.GetDataFileName(dir, 0)
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	buf[len(buf)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+
+	//默认打开失败，文件保持不变
+	_, err = Open(opts)
+	assert.Equal(t, util.ErrDataFileCorrupted, err)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(buf)), stat.Size())
+
+	//显式设置之后丢弃损坏位置之后的数据
+	opts.TruncateSealedFile = true
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	keyNum := len(db.ListKeys())
+	assert.True(t, keyNum < 500)
+	assert.True(t, keyNum > 0)
+	val, err := db.Get(util.GetTestKey(499))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
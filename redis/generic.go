@@ -1,9 +1,55 @@
 package redis
 
+import (
+	"Bitcask_go/util"
+	"encoding/binary"
+	"time"
+)
+
 func (rdb *RedisDB) Del(key []byte) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	return rdb.db.Delete(key)
 }
 
+// Expire 设置key的过期时间，key不存在或已经过期时返回false
+func (rdb *RedisDB) Expire(key []byte, ttl time.Duration) (bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	encValue, err := rdb.db.Get(key)
+	if err == util.ErrKeyNotFound || len(encValue) == 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	expire := time.Now().Add(ttl).UnixNano()
+	if encValue[0] == byte(RString) {
+		if isExpiredString(encValue) {
+			return false, nil
+		}
+		//重新编码 type + expire + payload
+		_, n := binary.Varint(encValue[1:])
+		payload := encValue[1+n:]
+		buf := make([]byte, 1+binary.MaxVarintLen64+len(payload))
+		buf[0] = byte(RString)
+		index := 1 + binary.PutVarint(buf[1:], expire)
+		index += copy(buf[index:], payload)
+		return true, rdb.db.Put(key, buf[:index])
+	}
+
+	meta := decodeMetadata(encValue)
+	if meta.expire > 0 && time.Now().UnixNano() >= meta.expire {
+		return false, nil
+	}
+	meta.expire = expire
+	return true, rdb.db.Put(key, meta.encode())
+}
+
+// Type 返回key的数据类型，key不存在或者已经过期时返回RUnknown
 func (rdb *RedisDB) Type(key []byte) redisDataStructureType {
 	encValue, err := rdb.db.Get(key)
 	if err != nil {
@@ -13,5 +59,34 @@ func (rdb *RedisDB) Type(key []byte) redisDataStructureType {
 	if len(encValue) == 0 {
 		return RUnknown
 	}
-	return redisDataStructureType(encValue[0])
+	if encValue[0] == byte(RString) {
+		if isExpiredString(encValue) {
+			return RUnknown
+		}
+		return RString
+	}
+
+	meta := decodeMetadata(encValue)
+	if meta.expire > 0 && time.Now().UnixNano() >= meta.expire {
+		return RUnknown
+	}
+	return redisDataStructureType(meta.dataType)
+}
+
+// String 返回数据类型在 redis TYPE 命令中的名称
+func (t redisDataStructureType) String() string {
+	switch t {
+	case RString:
+		return "string"
+	case RHash:
+		return "hash"
+	case RSet:
+		return "set"
+	case RList:
+		return "list"
+	case RZSet:
+		return "zset"
+	default:
+		return "none"
+	}
 }
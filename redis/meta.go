@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math"
 )
@@ -73,6 +74,61 @@ func decodeMetadata(buf []byte) *metadata {
 	}
 }
 
+// 严格解码元数据，只有重新编码后与原始数据完全一致才认为是元数据，否则返回nil
+// 用于后台清理时从普通value中区分出元数据
+func tryDecodeMetadata(buf []byte) *metadata {
+	if len(buf) == 0 {
+		return nil
+	}
+	switch redisDataStructureType(buf[0]) {
+	case RHash, RSet, RList, RZSet:
+	default:
+		return nil
+	}
+
+	var n = 1
+	expire, len := binary.Varint(buf[n:])
+	if len <= 0 {
+		return nil
+	}
+	n += len
+	version, len := binary.Varint(buf[n:])
+	if len <= 0 {
+		return nil
+	}
+	n += len
+	size, len := binary.Uvarint(buf[n:])
+	if len <= 0 || size > math.MaxUint32 {
+		return nil
+	}
+	n += len
+
+	var head, tail uint64 = 0, 0
+	if buf[0] == byte(RList) {
+		if head, len = binary.Uvarint(buf[n:]); len <= 0 {
+			return nil
+		}
+		n += len
+		if tail, len = binary.Uvarint(buf[n:]); len <= 0 {
+			return nil
+		}
+		n += len
+	}
+
+	meta := &metadata{
+		dataType: buf[0],
+		expire:   expire,
+		version:  version,
+		size:     uint32(size),
+		head:     head,
+		tail:     tail,
+	}
+	if !bytes.Equal(meta.encode(), buf) {
+		return nil
+	}
+	return meta
+}
+
 type hashInternalKey struct {
 	key     []byte
 	version int64
@@ -144,3 +200,95 @@ func (lk *listInternalKey) encode() []byte {
 
 	return buf
 }
+
+const (
+	zsetMemberTag byte = iota // member -> score
+	zsetScoreTag              // score + member, 按分数有序
+)
+
+const zsetScoreSize = 8
+
+type zsetInternalKey struct {
+	key     []byte
+	version int64
+	member  []byte
+	score   float64
+}
+
+// key + version + tag
+func (zk *zsetInternalKey) prefix(tag byte) []byte {
+	buf := make([]byte, len(zk.key)+8+1)
+
+	var index = 0
+	copy(buf[index:index+len(zk.key)], zk.key)
+	index += len(zk.key)
+
+	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
+	index += 8
+
+	buf[index] = tag
+	return buf
+}
+
+// 通过member查找score: key + version + memberTag + member
+func (zk *zsetInternalKey) encodeMember() []byte {
+	prefix := zk.prefix(zsetMemberTag)
+	buf := make([]byte, len(prefix)+len(zk.member))
+	copy(buf, prefix)
+	copy(buf[len(prefix):], zk.member)
+	return buf
+}
+
+// 按分数排序的key: key + version + scoreTag + score + member + member size
+func (zk *zsetInternalKey) encodeScore() []byte {
+	prefix := zk.prefix(zsetScoreTag)
+	buf := make([]byte, len(prefix)+zsetScoreSize+len(zk.member)+4)
+
+	var index = 0
+	copy(buf[index:index+len(prefix)], prefix)
+	index += len(prefix)
+
+	//score
+	copy(buf[index:index+zsetScoreSize], encodeScore(zk.score))
+	index += zsetScoreSize
+
+	//member
+	copy(buf[index:index+len(zk.member)], zk.member)
+	index += len(zk.member)
+
+	//member size
+	binary.LittleEndian.PutUint32(buf[index:], uint32(len(zk.member)))
+
+	return buf
+}
+
+// 从按分数排序的key中解析出score和member，prefixLen为key + version + tag的长度
+func decodeZSetScoreKey(buf []byte, prefixLen int) (float64, []byte) {
+	score := decodeScore(buf[prefixLen : prefixLen+zsetScoreSize])
+	member := buf[prefixLen+zsetScoreSize : len(buf)-4]
+	return score, member
+}
+
+// 将float64编码成可以按字节序比较的格式
+// 正数将符号位置1，负数全部取反，使用大端序
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if score >= 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	buf := make([]byte, zsetScoreSize)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
@@ -0,0 +1,43 @@
+package redis
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeScore(t *testing.T) {
+	scores := []float64{math.Inf(-1), -1000.5, -1, -0.25, 0, 0.25, 1, 3.14, 1000.5, math.Inf(1)}
+	encoded := make([][]byte, len(scores))
+	for i, s := range scores {
+		encoded[i] = encodeScore(s)
+		assert.Equal(t, s, decodeScore(encoded[i]))
+	}
+
+	//编码后的字节序和分数大小顺序一致
+	assert.True(t, sort.SliceIsSorted(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	}))
+}
+
+func TestZSetInternalKey(t *testing.T) {
+	zk := &zsetInternalKey{
+		key:     []byte("leaderboard"),
+		version: 100,
+		member:  []byte("player-1"),
+		score:   99.5,
+	}
+
+	prefix := zk.prefix(zsetScoreTag)
+	encKey := zk.encodeScore()
+	assert.True(t, bytes.HasPrefix(encKey, prefix))
+
+	score, member := decodeZSetScoreKey(encKey, len(prefix))
+	assert.Equal(t, 99.5, score)
+	assert.Equal(t, []byte("player-1"), member)
+
+	assert.False(t, bytes.HasPrefix(zk.encodeMember(), prefix))
+}
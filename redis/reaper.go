@@ -0,0 +1,325 @@
+package redis
+
+import (
+	bitcask "Bitcask_go"
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReaperStat 过期key清理的统计信息
+type ReaperStat struct {
+	ScannedKeys  uint64 //检查过的元数据和String类型的key数量
+	ExpiredKeys  uint64 //删除的过期key数量
+	StaleSubKeys uint64 //删除的过期或者旧版本的数据部分key数量
+}
+
+func (rs *ReaperStat) add(other *ReaperStat) {
+	atomic.AddUint64(&rs.ScannedKeys, other.ScannedKeys)
+	atomic.AddUint64(&rs.ExpiredKeys, other.ExpiredKeys)
+	atomic.AddUint64(&rs.StaleSubKeys, other.StaleSubKeys)
+}
+
+// expireReaper 后台定期清理过期key的协程
+type expireReaper struct {
+	options config.ExpireReaperOptions
+	stat    ReaperStat     //累计的清理统计信息
+	closeCh chan struct{}  //通知后台协程退出
+	wg      sync.WaitGroup //等待后台协程退出
+	lastErr atomic.Value   //最近一次清理失败的错误
+}
+
+// StartExpireReaper 启动后台清理协程，每隔一段时间抽样检查一批元数据key，
+// 删除已经过期的key以及它们对应的数据部分，同时清理旧版本遗留的数据
+func (rdb *RedisDB) StartExpireReaper(opts config.ExpireReaperOptions) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	if rdb.reaper != nil {
+		return
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = config.DefaultExpireReaperOptions.Interval
+	}
+	if opts.SampleSize <= 0 {
+		opts.SampleSize = config.DefaultExpireReaperOptions.SampleSize
+	}
+
+	reaper := &expireReaper{
+		options: opts,
+		closeCh: make(chan struct{}),
+	}
+	rdb.reaper = reaper
+
+	reaper.wg.Add(1)
+	go func() {
+		defer reaper.wg.Done()
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-reaper.closeCh:
+				return
+			case <-ticker.C:
+				stat, err := rdb.ReapExpired(opts.SampleSize)
+				if err != nil {
+					reaper.lastErr.Store(err)
+					continue
+				}
+				reaper.stat.add(stat)
+			}
+		}
+	}()
+}
+
+// StopExpireReaper 停止后台清理协程
+func (rdb *RedisDB) StopExpireReaper() {
+	rdb.mu.Lock()
+	reaper := rdb.reaper
+	rdb.reaper = nil
+	rdb.mu.Unlock()
+
+	if reaper == nil {
+		return
+	}
+	close(reaper.closeCh)
+	reaper.wg.Wait()
+}
+
+// ExpireReaperStat 返回后台清理协程累计的统计信息，以及最近一次清理失败的错误
+func (rdb *RedisDB) ExpireReaperStat() (ReaperStat, error) {
+	rdb.mu.Lock()
+	reaper := rdb.reaper
+	rdb.mu.Unlock()
+
+	if reaper == nil {
+		return ReaperStat{}, nil
+	}
+
+	stat := ReaperStat{
+		ScannedKeys:  atomic.LoadUint64(&reaper.stat.ScannedKeys),
+		ExpiredKeys:  atomic.LoadUint64(&reaper.stat.ExpiredKeys),
+		StaleSubKeys: atomic.LoadUint64(&reaper.stat.StaleSubKeys),
+	}
+	err, _ := reaper.lastErr.Load().(error)
+	return stat, err
+}
+
+// ReapExpired 执行一次清理，从上次结束的位置开始最多检查 sampleSize 个key
+//
+// 数据部分的key都以 key + version 开头，并且按字节序排在元数据key之后，
+// 所以按顺序遍历时，以某个元数据 key + 当前version 开头的key就是它当前版本的数据部分:
+//  1. 元数据已经过期，删除元数据以及它当前版本的数据部分
+//  2. 不属于任何元数据当前版本的key，如果value既不是String类型也不是元数据，
+//     只能是key过期后重新创建、或者被删除之后遗留下来的数据部分，直接删除
+//
+// 用户的key的value一定是String类型或者元数据，即使恰好以其他元数据key开头也不会被误删
+func (rdb *RedisDB) ReapExpired(sampleSize int) (*ReaperStat, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	pass := &reapPass{
+		rdb:    rdb,
+		stat:   &ReaperStat{},
+		wbOpts: config.DefaultWriteBatchOptions,
+	}
+	pass.wb = rdb.db.NewWriteBatch(pass.wbOpts)
+
+	iter := rdb.db.NewIterator(config.DefaultIteratorOptions)
+	defer iter.Close()
+
+	if rdb.reapCursor == nil {
+		iter.Rewind()
+	} else {
+		//以游标为前缀的元数据已经在之前检查过了，重新读取它们，才能识别出游标之后属于它们的数据部分
+		if err := pass.restoreOwners(rdb.reapCursor); err != nil {
+			return pass.stat, err
+		}
+		iter.Seek(rdb.reapCursor)
+	}
+
+	var visited int
+	rdb.reapCursor = nil
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()
+		//抽样数量已满，下次从这里继续
+		if visited >= sampleSize {
+			rdb.reapCursor = append([]byte{}, key...)
+			break
+		}
+		visited++
+
+		if err := pass.visit(key); err != nil {
+			return pass.stat, err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return pass.stat, err
+	}
+
+	if pass.pending > 0 {
+		if err := pass.wb.Commit(); err != nil {
+			return pass.stat, err
+		}
+	}
+	return pass.stat, nil
+}
+
+// reapOwner 遍历过程中遇到的元数据，它的key是当前遍历位置的前缀
+type reapOwner struct {
+	key     []byte
+	prefix  []byte //key + version，当前版本数据部分的公共前缀
+	expired bool
+}
+
+// reapPass 一次清理过程中的状态
+type reapPass struct {
+	rdb     *RedisDB
+	stat    *ReaperStat
+	wb      *bitcask.WriteBatch
+	wbOpts  config.WriteBatchOptions
+	pending uint
+	owners  []*reapOwner //按key长度从短到长排列
+}
+
+func (rp *reapPass) deleteKey(key []byte) error {
+	if err := rp.wb.Delete(key); err != nil {
+		return err
+	}
+	rp.pending++
+	//超过单批次的最大数量，先提交一次
+	if rp.pending >= rp.wbOpts.MaxBatchNum {
+		if err := rp.wb.Commit(); err != nil {
+			return err
+		}
+		rp.pending = 0
+	}
+	return nil
+}
+
+// 弹出不再是key前缀的元数据，然后判断key是否属于某个元数据的当前版本
+// 返回值 owned 表示属于某个元数据，expired 表示它所属的元数据都已经过期
+func (rp *reapPass) findOwner(key []byte) (owned bool, expired bool) {
+	for len(rp.owners) > 0 && !bytes.HasPrefix(key, rp.owners[len(rp.owners)-1].key) {
+		rp.owners = rp.owners[:len(rp.owners)-1]
+	}
+
+	expired = true
+	for _, owner := range rp.owners {
+		if bytes.HasPrefix(key, owner.prefix) {
+			owned = true
+			expired = expired && owner.expired
+		}
+	}
+	return owned, owned && expired
+}
+
+func (rp *reapPass) pushOwner(key []byte, meta *metadata) *reapOwner {
+	prefix := make([]byte, len(key)+8)
+	copy(prefix, key)
+	binary.LittleEndian.PutUint64(prefix[len(key):], uint64(meta.version))
+
+	owner := &reapOwner{
+		key:     prefix[:len(key)],
+		prefix:  prefix,
+		expired: meta.expire > 0 && time.Now().UnixNano() >= meta.expire,
+	}
+	rp.owners = append(rp.owners, owner)
+	return owner
+}
+
+// 从短到长检查游标的每个前缀，恢复游标所在位置的元数据
+func (rp *reapPass) restoreOwners(cursor []byte) error {
+	for i := 1; i < len(cursor); i++ {
+		key := cursor[:i]
+		if owned, _ := rp.findOwner(key); owned {
+			continue
+		}
+		value, err := rp.rdb.db.Get(key)
+		if err == util.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if meta := tryDecodeMetadata(value); meta != nil {
+			rp.pushOwner(key, meta)
+		}
+	}
+	return nil
+}
+
+func (rp *reapPass) visit(key []byte) error {
+	owned, expired := rp.findOwner(key)
+	//属于元数据的当前版本，只有元数据过期时才需要删除
+	if owned && !expired {
+		return nil
+	}
+
+	value, err := rp.rdb.db.Get(key)
+	if err == util.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	//数据部分的value不会是String类型或者元数据，无法确定时当作用户的key处理
+	if !isTopLevelValue(value) {
+		if err := rp.deleteKey(key); err != nil {
+			return err
+		}
+		rp.stat.StaleSubKeys++
+		return nil
+	}
+	if owned {
+		return nil
+	}
+
+	rp.stat.ScannedKeys++
+	if isExpiredString(value) {
+		if err := rp.deleteKey(key); err != nil {
+			return err
+		}
+		rp.stat.ExpiredKeys++
+		return nil
+	}
+
+	meta := tryDecodeMetadata(value)
+	if meta == nil {
+		return nil
+	}
+	if owner := rp.pushOwner(key, meta); owner.expired {
+		if err := rp.deleteKey(key); err != nil {
+			return err
+		}
+		rp.stat.ExpiredKeys++
+	}
+	return nil
+}
+
+// 用户的key对应的value是 String类型的value 或者 元数据
+func isTopLevelValue(value []byte) bool {
+	if len(value) > 0 && value[0] == byte(RString) {
+		_, n := binary.Varint(value[1:])
+		return n > 0
+	}
+	return tryDecodeMetadata(value) != nil
+}
+
+// String类型的value: type(1 byte) + expire(n byte) + payload(n byte)
+func isExpiredString(value []byte) bool {
+	if len(value) == 0 || value[0] != byte(RString) {
+		return false
+	}
+	expire, n := binary.Varint(value[1:])
+	if n <= 0 {
+		return false
+	}
+	return expire > 0 && time.Now().UnixNano() >= expire
+}
@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisDB_ReapExpired(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-reaper")
+	opts.DataDir = dir
+	rdb, err := NewRedisDB(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rdb.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	//一个会过期的hash，一个会过期的string，一个永不过期的set
+	for i := 0; i < 10; i++ {
+		_, err := rdb.HSet(util.GetTestKey(1), util.GetTestKey(i), util.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	ok, err := rdb.Expire(util.GetTestKey(1), time.Millisecond*50)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	err = rdb.Set(util.GetTestKey(2), time.Millisecond*50, util.RandomValue(10))
+	assert.Nil(t, err)
+
+	_, err = rdb.SAdd(util.GetTestKey(3), []byte("member"))
+	assert.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 100)
+
+	stat, err := rdb.ReapExpired(100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), stat.ScannedKeys)
+	assert.Equal(t, uint64(2), stat.ExpiredKeys)
+	assert.Equal(t, uint64(10), stat.StaleSubKeys)
+
+	// 只剩下set的元数据和数据
+	assert.Equal(t, 2, len(rdb.db.ListKeys()))
+	ok, err = rdb.SIsMember(util.GetTestKey(3), []byte("member"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestRedisDB_ReapStaleVersion(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-reaper-version")
+	opts.DataDir = dir
+	rdb, err := NewRedisDB(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rdb.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	key := util.GetTestKey(1)
+	for i := 0; i < 5; i++ {
+		_, err := rdb.HSet(key, util.GetTestKey(i), util.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	_, err = rdb.Expire(key, time.Millisecond*10)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 20)
+
+	//过期之后重新创建，产生新的版本
+	_, err = rdb.HSet(key, []byte("new-field"), []byte("new-value"))
+	assert.Nil(t, err)
+
+	//分多次抽样，每次只检查一个key
+	rdb.StartExpireReaper(config.ExpireReaperOptions{Interval: time.Millisecond * 10, SampleSize: 1})
+	time.Sleep(time.Millisecond * 200)
+
+	stat, err := rdb.ExpireReaperStat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), stat.StaleSubKeys)
+	assert.Equal(t, uint64(0), stat.ExpiredKeys)
+	rdb.StopExpireReaper()
+
+	assert.Equal(t, 2, len(rdb.db.ListKeys()))
+	val, err := rdb.HGet(key, []byte("new-field"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+}
+
+func TestRedisDB_ReapKeepsUserKeys(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-reaper-user")
+	opts.DataDir = dir
+	rdb, err := NewRedisDB(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rdb.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	//以 owner + 一个很小的版本号 开头的用户key
+	owner := []byte("owner")
+	userKey := func(version uint64, suffix string) []byte {
+		buf := make([]byte, len(owner)+8+len(suffix))
+		copy(buf, owner)
+		binary.LittleEndian.PutUint64(buf[len(owner):], version)
+		copy(buf[len(owner)+8:], suffix)
+		return buf
+	}
+	err = rdb.Set(userKey(1, "string"), 0, []byte("string-value"))
+	assert.Nil(t, err)
+	_, err = rdb.HSet(userKey(2, "hash"), []byte("field"), []byte("hash-value"))
+	assert.Nil(t, err)
+	_, err = rdb.RPush(userKey(3, "list"), []byte("list-value"))
+	assert.Nil(t, err)
+
+	//owner过期之后，它自己的数据部分会被删除
+	for i := 0; i < 10; i++ {
+		_, err := rdb.HSet(owner, util.GetTestKey(i), util.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	_, err = rdb.Expire(owner, time.Millisecond*10)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 20)
+
+	//每次只检查一个key，多次清理直到遍历完一轮
+	var rounds int
+	for {
+		_, err := rdb.ReapExpired(1)
+		assert.Nil(t, err)
+		rounds++
+		if rdb.reapCursor == nil {
+			break
+		}
+	}
+	assert.Equal(t, 16, rounds)
+
+	//只剩下用户的 string、hash、list
+	assert.Equal(t, 5, len(rdb.db.ListKeys()))
+	val, err := rdb.Get(userKey(1, "string"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("string-value"), val)
+	val, err = rdb.HGet(userKey(2, "hash"), []byte("field"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hash-value"), val)
+	val, err = rdb.LPop(userKey(3, "list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("list-value"), val)
+}
@@ -4,8 +4,11 @@ import (
 	bitcask "Bitcask_go"
 	"Bitcask_go/config"
 	"Bitcask_go/util"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"math"
+	"sync"
 	"time"
 )
 
@@ -23,23 +26,45 @@ const (
 var (
 	ErrWrongTypeOperation = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 	ErrKeyIsExpired       = errors.New("THE Current key is expired")
+	ErrScoreIsNaN         = errors.New("The score of the sorted set member is NaN")
+	ErrIndexUnsupported   = errors.New("The redis data structures need an ordered index, the hash index is not supported")
 )
 
 type RedisDB struct {
 	db *bitcask.DB
+	mu *sync.Mutex //写命令需要先读元数据再写入，和过期清理互斥
+
+	reaper     *expireReaper //后台过期key清理
+	reapCursor []byte        //下一次清理开始的位置，为空时从头开始
 }
 
 func NewRedisDB(cfg config.Configuration) (*RedisDB, error) {
+	//有序集合的范围查询、过期清理都依赖按key有序遍历，哈希索引每次Seek都要对所有key排序
+	if cfg.IndexerType == config.HashIndex {
+		return nil, ErrIndexUnsupported
+	}
 	db, err := bitcask.Open(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &RedisDB{db: db}, nil
+	return &RedisDB{
+		db: db,
+		mu: new(sync.Mutex),
+	}, nil
+}
+
+// Close 关闭底层的存储引擎
+func (rdb *RedisDB) Close() error {
+	rdb.StopExpireReaper()
+	return rdb.db.Close()
 }
 
 //==================== String ====================
 
 func (rdb *RedisDB) Set(key []byte, ttl time.Duration, value []byte) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	if value == nil {
 		return nil
 	}
@@ -90,6 +115,9 @@ func (rdb *RedisDB) Get(key []byte) ([]byte, error) {
 //
 //	key     field   value
 func (rdb *RedisDB) HSet(key, field, value []byte) (bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	meta, err := rdb.findMetadata(key, RHash)
 
 	if err != nil {
@@ -149,6 +177,9 @@ func (rdb *RedisDB) HGet(key, field []byte) ([]byte, error) {
 }
 
 func (rdb *RedisDB) HDel(key, field []byte) (bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	meta, err := rdb.findMetadata(key, RHash)
 
 	if err != nil {
@@ -188,6 +219,9 @@ func (rdb *RedisDB) HDel(key, field []byte) (bool, error) {
 //==================== Set ====================
 
 func (rdb *RedisDB) SAdd(key, member []byte) (bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	meta, err := rdb.findMetadata(key, RSet)
 
 	if err != nil {
@@ -259,6 +293,9 @@ func (rdb *RedisDB) SIsMember(key, member []byte) (bool, error) {
 }
 
 func (rdb *RedisDB) SRem(key, member []byte) (bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	meta, err := rdb.findMetadata(key, RSet)
 
 	if err != nil {
@@ -304,6 +341,9 @@ func (rdb *RedisDB) RPush(key, element []byte) (uint32, error) {
 }
 
 func (rdb *RedisDB) pushInner(key, element []byte, isLeft bool) (uint32, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	meta, err := rdb.findMetadata(key, RList)
 
 	if err != nil {
@@ -350,6 +390,9 @@ func (rdb *RedisDB) RPop(key []byte) ([]byte, error) {
 }
 
 func (rdb *RedisDB) popInner(key []byte, isLeft bool) ([]byte, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
 	meta, err := rdb.findMetadata(key, RList)
 
 	if meta == nil {
@@ -395,6 +438,327 @@ func (rdb *RedisDB) popInner(key []byte, isLeft bool) ([]byte, error) {
 	return val, nil
 }
 
+//==================== ZSet ====================
+
+// ZSetMember 有序集合中的一个元素
+type ZSetMember struct {
+	Member []byte
+	Score  float64
+}
+
+func (rdb *RedisDB) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	//NaN 没有顺序，既无法编码成有序的 key，也无法和旧分数比较
+	if math.IsNaN(score) {
+		return false, ErrScoreIsNaN
+	}
+
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	meta, err := rdb.findMetadata(key, RZSet)
+
+	if err != nil {
+		return false, err
+	}
+
+	//构造ZSet数据部分的key
+	zk := &zsetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+		score:   score,
+	}
+
+	encZk := zk.encodeMember()
+
+	//查找当前的member是否存在
+	var exist = true
+	oldScore, err := rdb.getZSetScore(encZk)
+	if err != nil && err != util.ErrKeyNotFound {
+		return false, err
+	}
+	if err == util.ErrKeyNotFound {
+		exist = false
+	}
+
+	//分数没有变化，不需要更新
+	if exist && oldScore == score {
+		return false, nil
+	}
+
+	wb := rdb.db.NewWriteBatch(config.DefaultWriteBatchOptions)
+	if !exist {
+		meta.size++
+		_ = wb.Put(key, meta.encode())
+	} else {
+		//删除旧分数对应的有序key
+		oldZk := &zsetInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+			score:   oldScore,
+		}
+		_ = wb.Delete(oldZk.encodeScore())
+	}
+
+	_ = wb.Put(encZk, encodeScore(score))
+	_ = wb.Put(zk.encodeScore(), nil)
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+
+	return !exist, nil
+}
+
+func (rdb *RedisDB) ZScore(key, member []byte) (float64, error) {
+	meta, err := rdb.findMetadata(key, RZSet)
+
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, util.ErrKeyNotFound
+	}
+
+	zk := &zsetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+
+	return rdb.getZSetScore(zk.encodeMember())
+}
+
+func (rdb *RedisDB) ZRem(key, member []byte) (bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+
+	meta, err := rdb.findMetadata(key, RZSet)
+
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+
+	zk := &zsetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+	encZk := zk.encodeMember()
+
+	score, err := rdb.getZSetScore(encZk)
+	if err == util.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	zk.score = score
+
+	wb := rdb.db.NewWriteBatch(config.DefaultWriteBatchOptions)
+	meta.size--
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Delete(encZk)
+	_ = wb.Delete(zk.encodeScore())
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (rdb *RedisDB) ZCard(key []byte) (uint32, error) {
+	meta, err := rdb.findMetadata(key, RZSet)
+
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// ZRank 返回member按分数从小到大的排名，从0开始
+func (rdb *RedisDB) ZRank(key, member []byte) (int, error) {
+	return rdb.zrankInner(key, member, false)
+}
+
+// ZRevRank 返回member按分数从大到小的排名，从0开始
+func (rdb *RedisDB) ZRevRank(key, member []byte) (int, error) {
+	return rdb.zrankInner(key, member, true)
+}
+
+func (rdb *RedisDB) zrankInner(key, member []byte, reverse bool) (int, error) {
+	meta, err := rdb.findMetadata(key, RZSet)
+
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, util.ErrKeyNotFound
+	}
+
+	zk := &zsetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+	score, err := rdb.getZSetScore(zk.encodeMember())
+	if err != nil {
+		return 0, err
+	}
+
+	//有序key按照 score + member 排列，统计排在它前面的元素数量
+	var rank = 0
+	rdb.zsetScan(key, meta, nil, func(s float64, m []byte) bool {
+		if s == score && bytes.Equal(m, member) {
+			return false
+		}
+		rank++
+		return true
+	})
+
+	if reverse {
+		rank = int(meta.size) - 1 - rank
+	}
+	return rank, nil
+}
+
+// ZRange 返回排名在[start, stop]之间的元素，按分数从小到大排列，支持负数下标
+func (rdb *RedisDB) ZRange(key []byte, start, stop int) ([]*ZSetMember, error) {
+	return rdb.zrangeInner(key, start, stop, false)
+}
+
+// ZRevRange 返回排名在[start, stop]之间的元素，按分数从大到小排列，支持负数下标
+func (rdb *RedisDB) ZRevRange(key []byte, start, stop int) ([]*ZSetMember, error) {
+	return rdb.zrangeInner(key, start, stop, true)
+}
+
+func (rdb *RedisDB) zrangeInner(key []byte, start, stop int, reverse bool) ([]*ZSetMember, error) {
+	meta, err := rdb.findMetadata(key, RZSet)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(meta.size)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if size == 0 || start > stop {
+		return nil, nil
+	}
+
+	//反向排名转换成正向排名
+	if reverse {
+		start, stop = size-1-stop, size-1-start
+	}
+
+	members := make([]*ZSetMember, 0, stop-start+1)
+	var rank = 0
+	rdb.zsetScan(key, meta, nil, func(score float64, member []byte) bool {
+		if rank > stop {
+			return false
+		}
+		if rank >= start {
+			members = append(members, &ZSetMember{Member: member, Score: score})
+		}
+		rank++
+		return true
+	})
+
+	if reverse {
+		reverseZSetMembers(members)
+	}
+	return members, nil
+}
+
+// ZRangeByScore 返回分数在[min, max]之间的元素，按分数从小到大排列
+func (rdb *RedisDB) ZRangeByScore(key []byte, min, max float64) ([]*ZSetMember, error) {
+	return rdb.zrangeByScoreInner(key, min, max, false)
+}
+
+// ZRevRangeByScore 返回分数在[min, max]之间的元素，按分数从大到小排列
+func (rdb *RedisDB) ZRevRangeByScore(key []byte, min, max float64) ([]*ZSetMember, error) {
+	return rdb.zrangeByScoreInner(key, min, max, true)
+}
+
+func (rdb *RedisDB) zrangeByScoreInner(key []byte, min, max float64, reverse bool) ([]*ZSetMember, error) {
+	meta, err := rdb.findMetadata(key, RZSet)
+
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 || min > max {
+		return nil, nil
+	}
+
+	var members []*ZSetMember
+	rdb.zsetScan(key, meta, encodeScore(min), func(score float64, member []byte) bool {
+		if score > max {
+			return false
+		}
+		members = append(members, &ZSetMember{Member: member, Score: score})
+		return true
+	})
+
+	if reverse {
+		reverseZSetMembers(members)
+	}
+	return members, nil
+}
+
+// 按分数从小到大遍历有序集合，seek不为空时从第一个不小于seek的分数开始，fn返回false时停止遍历
+func (rdb *RedisDB) zsetScan(key []byte, meta *metadata, seek []byte, fn func(score float64, member []byte) bool) {
+	zk := &zsetInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	prefix := zk.prefix(zsetScoreTag)
+
+	iter := rdb.db.NewIterator(config.DefaultIteratorOptions)
+	defer iter.Close()
+
+	seekKey := make([]byte, len(prefix)+len(seek))
+	copy(seekKey, prefix)
+	copy(seekKey[len(prefix):], seek)
+
+	for iter.Seek(seekKey); iter.Valid(); iter.Next() {
+		encKey := iter.Key()
+		//已经超出了当前有序集合的范围
+		if !bytes.HasPrefix(encKey, prefix) {
+			break
+		}
+		score, member := decodeZSetScoreKey(encKey, len(prefix))
+		if !fn(score, member) {
+			break
+		}
+	}
+}
+
+func (rdb *RedisDB) getZSetScore(encMemberKey []byte) (float64, error) {
+	encScore, err := rdb.db.Get(encMemberKey)
+	if err != nil {
+		return 0, err
+	}
+	return decodeScore(encScore), nil
+}
+
+func reverseZSetMembers(members []*ZSetMember) {
+	for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+		members[i], members[j] = members[j], members[i]
+	}
+}
+
 // 查找元数据，如果不存在返回一个初始化的metadata
 func (rdb *RedisDB) findMetadata(key []byte, dataType redisDataStructureType) (*metadata, error) {
 	encMeta, err := rdb.db.Get(key)
@@ -3,6 +3,7 @@ package redis
 import (
 	"Bitcask_go/config"
 	"Bitcask_go/util"
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -64,6 +65,32 @@ func TestRedisData_Del_Type(t *testing.T) {
 
 }
 
+func TestRedisData_Type_Expired(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-type-expired")
+	opts.DataDir = dir
+	rdb, err := NewRedisDB(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rdb.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	assert.Nil(t, rdb.Set([]byte("str"), time.Millisecond*10, []byte("value")))
+	_, err = rdb.HSet([]byte("hash"), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	ok, err := rdb.Expire([]byte("hash"), time.Millisecond*10)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, RString, rdb.Type([]byte("str")))
+	assert.Equal(t, RHash, rdb.Type([]byte("hash")))
+
+	//过期之后和不存在的key一样
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, RUnknown, rdb.Type([]byte("str")))
+	assert.Equal(t, RUnknown, rdb.Type([]byte("hash")))
+}
+
 func TestRedisData_Hash(t *testing.T) {
 	opts := config.DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-redis-hash")
@@ -166,3 +193,94 @@ func TestRedisData_List(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, s3, uint32(2))
 }
+
+func TestRedisData_HashIndex(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-hash-index")
+	defer os.RemoveAll(dir)
+	opts.DataDir = dir
+	opts.IndexerType = config.HashIndex
+
+	//有序集合的范围查询需要按key有序遍历，使用哈希索引时直接报错，而不是返回空的结果
+	rdb, err := NewRedisDB(opts)
+	assert.Equal(t, ErrIndexUnsupported, err)
+	assert.Nil(t, rdb)
+}
+
+func TestRedisData_ZSet(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-zset")
+	opts.DataDir = dir
+	rdb, err := NewRedisDB(opts)
+	assert.Nil(t, err)
+
+	key := util.GetTestKey(1)
+	ok, err := rdb.ZAdd(key, 100, []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rdb.ZAdd(key, -5.5, []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rdb.ZAdd(key, 30, []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	//更新已经存在的member的分数
+	ok, err = rdb.ZAdd(key, 50, []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	//NaN 分数不能写入
+	ok, err = rdb.ZAdd(key, math.NaN(), []byte("d"))
+	assert.Equal(t, ErrScoreIsNaN, err)
+	assert.False(t, ok)
+
+	score, err := rdb.ZScore(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(50), score)
+	_, err = rdb.ZScore(key, []byte("not exist"))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	size, err := rdb.ZCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+
+	members, err := rdb.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(members))
+	assert.Equal(t, []byte("b"), members[0].Member)
+	assert.Equal(t, []byte("c"), members[1].Member)
+	assert.Equal(t, []byte("a"), members[2].Member)
+
+	members, err = rdb.ZRevRange(key, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(members))
+	assert.Equal(t, []byte("a"), members[0].Member)
+
+	members, err = rdb.ZRangeByScore(key, 0, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(members))
+	assert.Equal(t, []byte("c"), members[0].Member)
+	assert.Equal(t, float64(50), members[1].Score)
+
+	rank, err := rdb.ZRank(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rank)
+	rank, err = rdb.ZRevRank(key, []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rank)
+
+	ok, err = rdb.ZRem(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rdb.ZRem(key, []byte("c"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	members, err = rdb.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(members))
+
+	_, err = rdb.HSet(key, []byte("field"), []byte("value"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
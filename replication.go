@@ -0,0 +1,668 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const replicationPosFileName = "replication-position"
+
+// 复制流中的帧类型，每一帧的格式为 type(1) + payload size(4) + payload
+const (
+	frameRecord    byte = iota + 1 //一条LogRecord, payload为 下一条记录的位置(12) + 编码后的LogRecord
+	frameHeartbeat                 //心跳，leader没有新数据时定期发送
+	frameResync                    //follower的位置已经失效，需要清空本地数据后从头同步
+	frameError                     //leader读取数据出错，随后会关闭连接
+)
+
+// 复制位置编码后的长度 fid(4) + offset(8)
+const replicationPosSize = 12
+
+// ReplicationPos 复制的位置，表示下一条需要读取的记录所在的文件id和偏移量
+type ReplicationPos struct {
+	Fid    uint32
+	Offset int64
+}
+
+func encodeReplicationPos(buf []byte, pos ReplicationPos) {
+	binary.LittleEndian.PutUint32(buf[:4], pos.Fid)
+	binary.LittleEndian.PutUint64(buf[4:replicationPosSize], uint64(pos.Offset))
+}
+
+func decodeReplicationPos(buf []byte) ReplicationPos {
+	return ReplicationPos{
+		Fid:    binary.LittleEndian.Uint32(buf[:4]),
+		Offset: int64(binary.LittleEndian.Uint64(buf[4:replicationPosSize])),
+	}
+}
+
+func writeReplicationFrame(w io.Writer, typ byte, payload []byte) error {
+	var header [5]byte
+	header[0] = typ
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// 一帧最多包含一条记录，记录一般不会超过一个数据文件的大小，
+// 超过这个大小的帧不会被发送，接收时直接拒绝，避免按照错误的长度分配大量内存
+func maxReplicationFrameSize(opts config.ReplicationOptions, dataFileMaxSize int64) int64 {
+	return replicationPosSize + max(int64(opts.MaxBatchBytes), dataFileMaxSize)
+}
+
+// 读取一帧数据，payload超过maxSize时返回错误
+func readReplicationFrame(r io.Reader, maxSize int64) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[1:])
+	if int64(size) > maxSize {
+		return 0, nil, util.ErrFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// Leader 把数据文件中的记录通过TCP推送给follower
+// follower连接之后先发送自己的复制位置，leader从这个位置开始依次读取数据文件，
+// 追上最新的写入之后持续等待新数据，相当于把数据文件当作预写日志来复制
+// 记录按数据文件中的原样发送，加密的数据需要follower配置能够解密的KeyProvider
+type Leader struct {
+	db        *DB
+	options   config.ReplicationOptions
+	mu        *sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closeCh   chan struct{}
+	closed    bool
+	wg        sync.WaitGroup
+	//merge之后小于这个id的数据文件被重写过，落在这些文件中的复制位置都已经失效
+	nonMergeFileId uint32
+}
+
+// NewLeader 创建Leader，需要调用Serve开始接受follower的连接
+func NewLeader(db *DB, opts config.ReplicationOptions) (*Leader, error) {
+	opts = checkReplicationOptions(opts)
+
+	var nonMergeFileId uint32
+	mergeFinFileName := filepath.Join(db.configuration.DataDir, data.MergeFinFileName)
+	if _, err := os.Stat(mergeFinFileName); err == nil {
+		fid, err := db.getNonMergeFileId(db.configuration.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		nonMergeFileId = fid
+	}
+
+	return &Leader{
+		db:             db,
+		options:        opts,
+		mu:             new(sync.Mutex),
+		listeners:      make(map[net.Listener]struct{}),
+		conns:          make(map[net.Conn]struct{}),
+		closeCh:        make(chan struct{}),
+		nonMergeFileId: nonMergeFileId,
+	}, nil
+}
+
+// Serve 在listener上接受follower的连接，直到listener出错或者Leader被关闭
+func (l *Leader) Serve(listener net.Listener) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return util.ErrReplicationClosed
+	}
+	l.listeners[listener] = struct{}{}
+	l.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			l.mu.Lock()
+			delete(l.listeners, listener)
+			closed := l.closed
+			l.mu.Unlock()
+			if closed {
+				return util.ErrReplicationClosed
+			}
+			return err
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			_ = conn.Close()
+			continue
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mu.Unlock()
+
+		go func() {
+			defer l.wg.Done()
+			l.handleConn(conn)
+
+			l.mu.Lock()
+			delete(l.conns, conn)
+			l.mu.Unlock()
+		}()
+	}
+}
+
+// Close 关闭所有的listener和follower连接
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.closeCh)
+	for listener := range l.listeners {
+		_ = listener.Close()
+	}
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	return nil
+}
+
+func (l *Leader) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	//读取follower请求的复制位置
+	var buf [replicationPosSize]byte
+	_ = conn.SetReadDeadline(time.Now().Add(l.options.ReadTimeout))
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return
+	}
+	pos := decodeReplicationPos(buf[:])
+
+	w := bufio.NewWriter(conn)
+	flush := func() error {
+		_ = conn.SetWriteDeadline(time.Now().Add(l.options.ReadTimeout))
+		return w.Flush()
+	}
+
+	if !l.isValidPos(pos) {
+		pos = ReplicationPos{}
+		_ = writeReplicationFrame(w, frameResync, nil)
+		if err := flush(); err != nil {
+			return
+		}
+	}
+
+	lastSend := time.Now()
+	for {
+		select {
+		case <-l.closeCh:
+			return
+		default:
+		}
+
+		payloads, err := l.db.readReplicationRecords(&pos, l.options)
+		if err != nil {
+			_ = writeReplicationFrame(w, frameError, []byte(err.Error()))
+			_ = flush()
+			return
+		}
+
+		//已经追上最新的写入，等待一会儿再检查
+		if len(payloads) == 0 {
+			if time.Since(lastSend) >= l.options.HeartbeatInterval {
+				_ = writeReplicationFrame(w, frameHeartbeat, nil)
+				if err := flush(); err != nil {
+					return
+				}
+				lastSend = time.Now()
+			}
+			select {
+			case <-l.closeCh:
+				return
+			case <-time.After(l.options.PollInterval):
+			}
+			continue
+		}
+
+		for _, payload := range payloads {
+			if err := writeReplicationFrame(w, frameRecord, payload); err != nil {
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			return
+		}
+		lastSend = time.Now()
+	}
+}
+
+// 判断follower的复制位置在当前的数据文件中是否还有意义
+func (l *Leader) isValidPos(pos ReplicationPos) bool {
+	if pos == (ReplicationPos{}) {
+		return true
+	}
+	if pos.Fid < l.nonMergeFileId {
+		return false
+	}
+
+	db := l.db
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if db.activeFile == nil || pos.Fid > db.activeFile.Fid {
+		return false
+	}
+	if pos.Fid == db.activeFile.Fid {
+		return pos.Offset <= db.activeFile.WriteOffset
+	}
+	dataFile := db.olderFiles[pos.Fid]
+	if dataFile == nil {
+		return pos.Offset == 0
+	}
+	size, err := dataFile.IOManager.Size()
+	return err == nil && pos.Offset <= size
+}
+
+// 从pos开始读取数据文件中的记录并封装成复制流中的数据，同时把pos移动到最后一条记录之后
+func (db *DB) readReplicationRecords(pos *ReplicationPos, opts config.ReplicationOptions) ([][]byte, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	maxBytes := opts.MaxBatchBytes
+	maxFrameSize := maxReplicationFrameSize(opts, db.configuration.DataFileMaxSize)
+
+	var payloads [][]byte
+	var total int
+	for total < maxBytes && db.activeFile != nil {
+		var dataFile *data.DataFile
+		if pos.Fid == db.activeFile.Fid {
+			dataFile = db.activeFile
+		} else {
+			dataFile = db.olderFiles[pos.Fid]
+		}
+
+		//活跃文件只能读到已经写完的位置
+		if dataFile == db.activeFile && pos.Offset >= dataFile.WriteOffset {
+			break
+		}
+
+		var size int64
+		var err error
+		if dataFile != nil {
+			_, size, err = dataFile.ReadLogRecord(pos.Offset)
+		}
+
+		//文件不存在或者已经读完，移动到下一个文件
+		if dataFile == nil || err == io.EOF {
+			fid, ok := db.nextDataFileId(pos.Fid)
+			if !ok {
+				break
+			}
+			*pos = ReplicationPos{Fid: fid}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if replicationPosSize+size > maxFrameSize {
+			return nil, util.ErrFrameTooLarge
+		}
+
+		//按数据文件中的原始数据发送，加密的记录在网络上仍然是加密的
+		encRecord, err := dataFile.ReadRawLogRecord(pos.Offset, size)
+		if err != nil {
+			return nil, err
+		}
+		pos.Offset += size
+		payload := make([]byte, replicationPosSize+len(encRecord))
+		encodeReplicationPos(payload, *pos)
+		copy(payload[replicationPosSize:], encRecord)
+
+		payloads = append(payloads, payload)
+		total += len(payload)
+	}
+	return payloads, nil
+}
+
+// 找到比fid大的最小的数据文件id
+func (db *DB) nextDataFileId(fid uint32) (uint32, bool) {
+	next, ok := db.activeFile.Fid, db.activeFile.Fid > fid
+	for id := range db.olderFiles {
+		if id > fid && id < next {
+			next, ok = id, true
+		}
+	}
+	return next, ok
+}
+
+// Follower 从leader接收数据文件中的记录并应用到本地DB
+// 本地DB可以正常提供读服务，但是不应该直接写入，否则数据会和leader不一致
+// 复制位置保存在本地数据目录中，断开连接之后会自动重连并从上次的位置继续同步
+type Follower struct {
+	db         *DB
+	leaderAddr string
+	options    config.ReplicationOptions
+	mu         *sync.Mutex
+	pos        ReplicationPos //已经应用到本地DB的位置，不会落在一个未完成的事务中间
+	savedPos   ReplicationPos //已经持久化的位置
+	conn       net.Conn
+	closeCh    chan struct{}
+	started    bool
+	closed     bool
+	wg         sync.WaitGroup
+	lastErr    error //最近一次同步失败的错误
+}
+
+// NewFollower 创建Follower，需要调用Start开始同步
+func NewFollower(db *DB, leaderAddr string, opts config.ReplicationOptions) (*Follower, error) {
+	f := &Follower{
+		db:         db,
+		leaderAddr: leaderAddr,
+		options:    checkReplicationOptions(opts),
+		mu:         new(sync.Mutex),
+		closeCh:    make(chan struct{}),
+	}
+
+	//加载上次同步到的位置
+	buf, err := os.ReadFile(f.posFileName())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if len(buf) != replicationPosSize {
+			return nil, util.ErrDataDirCorrupted
+		}
+		f.pos = decodeReplicationPos(buf)
+		f.savedPos = f.pos
+	}
+	return f, nil
+}
+
+// Start 启动后台同步协程
+func (f *Follower) Start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.started || f.closed {
+		return
+	}
+	f.started = true
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		for {
+			if err := f.replicate(); err != nil {
+				f.mu.Lock()
+				f.lastErr = err
+				f.mu.Unlock()
+			}
+			select {
+			case <-f.closeCh:
+				return
+			case <-time.After(f.options.ReconnectInterval):
+			}
+		}
+	}()
+}
+
+// Stop 停止同步并持久化复制位置，需要在关闭DB之前调用
+func (f *Follower) Stop() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.closeCh)
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return f.savePos()
+}
+
+// Position 返回已经应用到本地DB的复制位置
+func (f *Follower) Position() ReplicationPos {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pos
+}
+
+// LastError 返回最近一次同步失败的错误
+func (f *Follower) LastError() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastErr
+}
+
+// 连接leader并持续应用收到的记录，直到连接断开或者Follower被停止
+func (f *Follower) replicate() error {
+	conn, err := net.DialTimeout("tcp", f.leaderAddr, f.options.ReadTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.conn = conn
+	pos := f.pos
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.conn = nil
+		f.mu.Unlock()
+	}()
+
+	//发送复制位置
+	var buf [replicationPosSize]byte
+	encodeReplicationPos(buf[:], pos)
+	_ = conn.SetWriteDeadline(time.Now().Add(f.options.ReadTimeout))
+	if _, err := conn.Write(buf[:]); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	maxFrameSize := maxReplicationFrameSize(f.options, f.db.configuration.DataFileMaxSize)
+	//暂存还没有收到完成标记的事务记录
+	transactionRecords := make(map[uint64][]*data.LogRecord)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(f.options.ReadTimeout))
+		typ, payload, err := readReplicationFrame(r, maxFrameSize)
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case frameHeartbeat:
+		case frameResync:
+			if err := f.clearLocalData(); err != nil {
+				return err
+			}
+			transactionRecords = make(map[uint64][]*data.LogRecord)
+			f.setPos(ReplicationPos{})
+		case frameError:
+			return errors.New(string(payload))
+		case frameRecord:
+			if len(payload) < replicationPosSize {
+				return util.ErrInvalidCRC
+			}
+			logRecord, err := data.DecodeLogRecordWithCipher(payload[replicationPosSize:], f.db.cipher)
+			if err != nil {
+				return err
+			}
+			if err := f.apply(logRecord, transactionRecords); err != nil {
+				return err
+			}
+			//事务中间的位置不能作为恢复的起点
+			if len(transactionRecords) == 0 {
+				f.setPos(decodeReplicationPos(payload))
+			}
+		}
+
+		//收到的数据都处理完之后持久化一次复制位置
+		if r.Buffered() == 0 {
+			if err := f.savePos(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 把一条记录应用到本地DB，事务中的记录要等到完成标记之后一起写入
+func (f *Follower) apply(logRecord *data.LogRecord, transactionRecords map[uint64][]*data.LogRecord) error {
+	db := f.db
+	realKey, seqNo := parseLogRecordKeyWithSeq(logRecord.Key)
+
+	if seqNo == nonTransactionSeqNo {
+		db.mutex.Lock()
+		defer db.mutex.Unlock()
+		if logRecord.Type == data.LogRecordDeleted {
+			return db.deleteLocked(realKey)
+		}
+		return db.putLocked(realKey, logRecord.Value, logRecord.Expire)
+	}
+
+	if logRecord.Type != data.LogRecordFinished {
+		logRecord.Key = realKey
+		transactionRecords[seqNo] = append(transactionRecords[seqNo], logRecord)
+		return nil
+	}
+
+	records := transactionRecords[seqNo]
+	delete(transactionRecords, seqNo)
+	if len(records) == 0 {
+		return nil
+	}
+	pendingWrites := make(map[string]*data.LogRecord, len(records))
+	for _, record := range records {
+		pendingWrites[string(record.Key)] = record
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.commitPendingWrites(pendingWrites, db.syncEveryWrite())
+}
+
+// 清空本地数据，准备从头开始同步
+// 直接遍历索引，已经过期的key也要删除，否则它们的记录会一直留在follower中
+func (f *Follower) clearLocalData() error {
+	db := f.db
+	db.mutex.RLock()
+	keys := make([][]byte, 0, db.index.Size())
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		//B+树索引的key指向bbolt的内存，迭代器关闭之后不再有效
+		keys = append(keys, append([]byte(nil), iterator.Key()...))
+	}
+	err := iterator.Err()
+	iterator.Close()
+	db.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		db.mutex.Lock()
+		err := db.deleteLocked(key)
+		db.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Follower) setPos(pos ReplicationPos) {
+	f.mu.Lock()
+	f.pos = pos
+	f.mu.Unlock()
+}
+
+// 持久化复制位置，先把本地DB刷盘，保证位置之前的数据不会丢失
+func (f *Follower) savePos() error {
+	f.mu.Lock()
+	pos := f.pos
+	f.mu.Unlock()
+	if pos == f.savedPos {
+		return nil
+	}
+
+	if err := f.db.Sync(); err != nil {
+		return err
+	}
+
+	var buf [replicationPosSize]byte
+	encodeReplicationPos(buf[:], pos)
+	tmpFileName := f.posFileName() + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf[:]); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFileName, f.posFileName()); err != nil {
+		return err
+	}
+	f.savedPos = pos
+	return nil
+}
+
+func (f *Follower) posFileName() string {
+	return filepath.Join(f.db.configuration.DataDir, replicationPosFileName)
+}
+
+func checkReplicationOptions(opts config.ReplicationOptions) config.ReplicationOptions {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = config.DefaultReplicationOptions.PollInterval
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = config.DefaultReplicationOptions.HeartbeatInterval
+	}
+	if opts.ReadTimeout <= 0 {
+		opts.ReadTimeout = config.DefaultReplicationOptions.ReadTimeout
+	}
+	if opts.ReconnectInterval <= 0 {
+		opts.ReconnectInterval = config.DefaultReplicationOptions.ReconnectInterval
+	}
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = config.DefaultReplicationOptions.MaxBatchBytes
+	}
+	return opts
+}
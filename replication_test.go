@@ -0,0 +1,237 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testReplicationOptions = config.ReplicationOptions{
+	PollInterval:      5 * time.Millisecond,
+	HeartbeatInterval: 50 * time.Millisecond,
+	ReadTimeout:       time.Second,
+	ReconnectInterval: 20 * time.Millisecond,
+	MaxBatchBytes:     4 * 1024,
+}
+
+func openReplicationTestDB(t *testing.T, name string) *DB {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", name)
+	opts.DataDir = dir
+	opts.DataFileMaxSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func startTestLeader(t *testing.T, db *DB, addr string) (*Leader, string) {
+	listener, err := net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	leader, err := NewLeader(db, testReplicationOptions)
+	assert.Nil(t, err)
+	go func() {
+		_ = leader.Serve(listener)
+	}()
+	return leader, listener.Addr().String()
+}
+
+// 等待follower的复制位置追上leader
+func waitForCatchUp(t *testing.T, leaderDB *DB, follower *Follower) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		leaderDB.mutex.RLock()
+		pos := ReplicationPos{Fid: leaderDB.activeFile.Fid, Offset: leaderDB.activeFile.WriteOffset}
+		leaderDB.mutex.RUnlock()
+		if follower.Position() == pos {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("follower did not catch up, last error: %v", follower.LastError())
+}
+
+func TestReplication(t *testing.T) {
+	leaderDB := openReplicationTestDB(t, "bitcask-go-replication-leader")
+	defer destroyDB(leaderDB)
+	followerDB := openReplicationTestDB(t, "bitcask-go-replication-follower")
+	defer destroyDB(followerDB)
+
+	//写入足够多的数据，让leader产生多个数据文件
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, leaderDB.Put(util.GetTestKey(i), util.RandomValue(128)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leaderDB.Delete(util.GetTestKey(i)))
+	}
+	wb := leaderDB.NewWriteBatch(config.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(util.GetTestKey(2000), []byte("batch")))
+	assert.Nil(t, wb.Delete(util.GetTestKey(100)))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, leaderDB.PutWithTTL(util.GetTestKey(3000), []byte("ttl"), time.Hour))
+	assert.True(t, len(leaderDB.olderFiles) > 1)
+
+	leader, addr := startTestLeader(t, leaderDB, "127.0.0.1:0")
+
+	follower, err := NewFollower(followerDB, addr, testReplicationOptions)
+	assert.Nil(t, err)
+	follower.Start()
+	waitForCatchUp(t, leaderDB, follower)
+
+	assert.Equal(t, leaderDB.ListKeys(), followerDB.ListKeys())
+	for _, key := range leaderDB.ListKeys() {
+		expected, err := leaderDB.Get(key)
+		assert.Nil(t, err)
+		val, err := followerDB.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+	ttl, err := followerDB.TTL(util.GetTestKey(3000))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+
+	//持续同步新的写入
+	assert.Nil(t, leaderDB.Put(util.GetTestKey(4000), []byte("live")))
+	assert.Nil(t, leaderDB.Delete(util.GetTestKey(2000)))
+	waitForCatchUp(t, leaderDB, follower)
+	val, err := followerDB.Get(util.GetTestKey(4000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("live"), val)
+	_, err = followerDB.Get(util.GetTestKey(2000))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	//leader断开之后follower自动重连，并从上次的位置继续
+	assert.Nil(t, leader.Close())
+	assert.Nil(t, leaderDB.Put(util.GetTestKey(5000), []byte("after reconnect")))
+	leader, _ = startTestLeader(t, leaderDB, addr)
+	defer leader.Close()
+	waitForCatchUp(t, leaderDB, follower)
+	val, err = followerDB.Get(util.GetTestKey(5000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after reconnect"), val)
+
+	//follower重启之后从持久化的位置继续
+	assert.Nil(t, follower.Stop())
+	pos := follower.Position()
+	assert.Nil(t, leaderDB.Put(util.GetTestKey(6000), []byte("after restart")))
+
+	follower, err = NewFollower(followerDB, addr, testReplicationOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, pos, follower.Position())
+	follower.Start()
+	defer follower.Stop()
+	waitForCatchUp(t, leaderDB, follower)
+	val, err = followerDB.Get(util.GetTestKey(6000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after restart"), val)
+	assert.Equal(t, leaderDB.ListKeys(), followerDB.ListKeys())
+}
+
+func TestReplication_Resync(t *testing.T) {
+	leaderDB := openReplicationTestDB(t, "bitcask-go-replication-leader")
+	defer destroyDB(leaderDB)
+	followerDB := openReplicationTestDB(t, "bitcask-go-replication-follower")
+	defer destroyDB(followerDB)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, leaderDB.Put(util.GetTestKey(i), util.RandomValue(16)))
+	}
+	//follower中有leader不存在的数据，并且复制位置超出了leader的数据文件
+	assert.Nil(t, followerDB.Put([]byte("stale"), []byte("stale")))
+	//已经过期的key在重新同步时也要删除
+	assert.Nil(t, followerDB.PutWithTTL([]byte("expired"), []byte("expired"), 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+
+	leader, addr := startTestLeader(t, leaderDB, "127.0.0.1:0")
+	defer leader.Close()
+
+	follower, err := NewFollower(followerDB, addr, testReplicationOptions)
+	assert.Nil(t, err)
+	follower.pos = ReplicationPos{Fid: 100, Offset: 100}
+	follower.Start()
+	defer follower.Stop()
+	waitForCatchUp(t, leaderDB, follower)
+
+	_, err = followerDB.Get([]byte("stale"))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+	assert.Nil(t, followerDB.index.Get([]byte("expired")))
+	assert.Equal(t, leaderDB.ListKeys(), followerDB.ListKeys())
+	assert.Equal(t, leaderDB.index.Size(), followerDB.index.Size())
+}
+
+func TestReplication_FrameTooLarge(t *testing.T) {
+	//长度字段超过上限时不会按照这个长度分配内存
+	header := []byte{frameRecord, 0xff, 0xff, 0xff, 0xff}
+	_, _, err := readReplicationFrame(bytes.NewReader(header), 1024)
+	assert.Equal(t, util.ErrFrameTooLarge, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, writeReplicationFrame(&buf, frameRecord, make([]byte, 1024)))
+	typ, payload, err := readReplicationFrame(&buf, 1024)
+	assert.Nil(t, err)
+	assert.Equal(t, frameRecord, typ)
+	assert.Equal(t, 1024, len(payload))
+}
+
+func TestReplication_Encrypted(t *testing.T) {
+	provider := &config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)},
+	}
+	openEncrypted := func(name string, provider config.KeyProvider) *DB {
+		opts := config.DefaultOptions
+		dir, _ := os.MkdirTemp("", name)
+		opts.DataDir = dir
+		opts.KeyProvider = provider
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		return db
+	}
+	leaderDB := openEncrypted("bitcask-go-replication-leader", provider)
+	defer destroyDB(leaderDB)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, leaderDB.Put(util.GetTestKey(i), []byte("secret-value")))
+	}
+
+	//复制流中的记录仍然是加密的
+	var pos ReplicationPos
+	payloads, err := leaderDB.readReplicationRecords(&pos, testReplicationOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(payloads))
+	for _, payload := range payloads {
+		assert.False(t, bytes.Contains(payload, []byte("secret-value")))
+		assert.False(t, bytes.Contains(payload, []byte("bitcask-go-key")))
+	}
+
+	leader, addr := startTestLeader(t, leaderDB, "127.0.0.1:0")
+	defer leader.Close()
+
+	//follower没有密钥无法解密
+	plainDB := openEncrypted("bitcask-go-replication-plain", nil)
+	defer destroyDB(plainDB)
+	plainFollower, err := NewFollower(plainDB, addr, testReplicationOptions)
+	assert.Nil(t, err)
+	plainFollower.Start()
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, plainFollower.Stop())
+	assert.Equal(t, util.ErrEncryptionKeyRequired, plainFollower.LastError())
+	assert.Equal(t, 0, len(plainDB.ListKeys()))
+
+	followerDB := openEncrypted("bitcask-go-replication-follower", provider)
+	defer destroyDB(followerDB)
+	follower, err := NewFollower(followerDB, addr, testReplicationOptions)
+	assert.Nil(t, err)
+	follower.Start()
+	defer follower.Stop()
+	waitForCatchUp(t, leaderDB, follower)
+
+	assert.Equal(t, leaderDB.ListKeys(), followerDB.ListKeys())
+	val, err := followerDB.Get(util.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value"), val)
+}
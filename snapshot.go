@@ -0,0 +1,110 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/index"
+	"Bitcask_go/util"
+)
+
+// Snapshot DB在某一时刻的只读视图
+// 创建之后的写入、删除以及批量写入都不会影响快照中看到的数据
+type Snapshot struct {
+	db    *DB           //所属DB实例
+	index index.Indexer //创建快照时索引的只读副本
+	seqNo uint64        //创建快照时最新的事务序列号
+}
+
+// NewSnapshot 创建一个快照，使用完毕后需要调用Release释放
+// 加密的B+树索引无法解密时返回错误
+func (db *DB) NewSnapshot() (*Snapshot, error) {
+	//WriteBatch 在持有锁的情况下更新索引，这里加锁可以保证不会看到一半的批量写入
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	snapshot, err := db.index.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		db:    db,
+		index: snapshot,
+		seqNo: db.seqNo,
+	}, nil
+}
+
+// SeqNo 创建快照时最新的事务序列号
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// Get 从快照中获取数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, util.ErrKeyIsEmpty
+	}
+
+	pos := s.index.Get(key)
+
+	s.db.mutex.RLock()
+	defer s.db.mutex.RUnlock()
+	return s.db.GetValueByPosition(pos)
+}
+
+// NewIterator 创建快照上的迭代器
+func (s *Snapshot) NewIterator(ops config.IteratorOptions) *Iterator {
+	return &Iterator{
+		indexIter: s.index.Iterator(ops.Reverse),
+		db:        s.db,
+		options:   ops,
+	}
+}
+
+// ListKeys 获取快照中所有的key
+func (s *Snapshot) ListKeys() [][]byte {
+	keys := make([][]byte, 0, s.index.Size())
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired() {
+			continue
+		}
+		keys = append(keys, iterator.Key())
+	}
+	return keys
+}
+
+// Fold 遍历快照中所有的key，执行fn函数. 如果fn返回false，则停止遍历
+// 和DB.Fold不同，遍历过程中不会一直持有DB的锁，不会阻塞写入
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired() {
+			continue
+		}
+
+		s.db.mutex.RLock()
+		value, err := s.db.GetValueByPosition(pos)
+		s.db.mutex.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+	return iterator.Err()
+}
+
+// Release 释放快照持有的索引副本
+func (s *Snapshot) Release() error {
+	if s.index == nil {
+		return nil
+	}
+	err := s.index.Close()
+	s.index = nil
+	return err
+}
@@ -0,0 +1,75 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	for _, indexType := range []config.IndexerType{config.Btree, config.ART, config.SkipListIndex, config.BPTree} {
+		opts := config.DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+		opts.DataDir = dir
+		opts.IndexerType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for i := 0; i < 100; i++ {
+			err := db.Put(util.GetTestKey(i), []byte("old"))
+			assert.Nil(t, err)
+		}
+
+		snap, err := db.NewSnapshot()
+		assert.Nil(t, err)
+
+		// 快照之后的写入、删除、批量写入都不可见
+		err = db.Put(util.GetTestKey(1), []byte("new"))
+		assert.Nil(t, err)
+		err = db.Delete(util.GetTestKey(2))
+		assert.Nil(t, err)
+		wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+		_ = wb.Put(util.GetTestKey(3), []byte("new"))
+		_ = wb.Put(util.GetTestKey(1000), []byte("new"))
+		err = wb.Commit()
+		assert.Nil(t, err)
+
+		for _, i := range []int{1, 2, 3} {
+			val, err := snap.Get(util.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("old"), val)
+		}
+		_, err = snap.Get(util.GetTestKey(1000))
+		assert.Equal(t, util.ErrKeyNotFound, err)
+
+		var count int
+		err = snap.Fold(func(key []byte, value []byte) bool {
+			assert.Equal(t, []byte("old"), value)
+			count++
+			return true
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 100, count)
+		assert.Equal(t, 100, len(snap.ListKeys()))
+
+		iter := snap.NewIterator(config.IteratorOptions{Reverse: true})
+		iter.Rewind()
+		assert.True(t, iter.Valid())
+		assert.Equal(t, util.GetTestKey(99), iter.Key())
+		iter.Close()
+
+		// DB 中可以看到最新的数据
+		val, err := db.Get(util.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new"), val)
+		assert.Equal(t, 100, len(db.ListKeys()))
+
+		assert.Nil(t, snap.Release())
+		assert.Nil(t, db.Close())
+		_ = os.RemoveAll(dir)
+	}
+}
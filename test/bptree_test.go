@@ -1,13 +1,16 @@
 package test
 
 import (
+	"Bitcask_go/config"
 	"Bitcask_go/data"
 	"Bitcask_go/index"
+	"bytes"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
 )
 
 func TestNewBPlusTree_Put(t *testing.T) {
@@ -126,3 +129,75 @@ func TestBplusTree_Iterator(t *testing.T) {
 		t.Log(string(iter1.Key()))
 	}
 }
+
+func TestBplusTree_EncryptedIterator(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-encrypted-test")
+	_ = os.RemoveAll(path)
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	cipher := data.NewCipher(&config.StaticKeyProvider{
+		CurrentId: 1,
+		Keys:      map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)},
+	})
+	tree, err := index.NewEncryptedBPTree(path, false, cipher)
+	assert.Nil(t, err)
+	keys := [][]byte{[]byte("123"), []byte("132"), []byte("312"), []byte("321"), []byte("431"), []byte("471")}
+	for _, key := range keys {
+		tree.Put(key, &data.LogRecordPos{Fid: 123, Offset: 999})
+	}
+
+	//不Seek时按HMAC的顺序遍历
+	var got [][]byte
+	iter := tree.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		got = append(got, iter.Key())
+		assert.Equal(t, int64(999), iter.Value().Offset)
+	}
+	assert.Nil(t, iter.Err())
+	iter.Close()
+	assert.ElementsMatch(t, keys, got)
+
+	//Seek之后按key排序
+	got = nil
+	iter = tree.Iterator(true)
+	for iter.Seek([]byte("400")); iter.Valid(); iter.Next() {
+		got = append(got, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, [][]byte{[]byte("321"), []byte("312"), []byte("132"), []byte("123")}, got)
+	assert.Nil(t, tree.Close())
+
+	//篡改其中一个元素，遍历和快照返回错误而不是panic
+	db, err := bbolt.Open(filepath.Join(path, "bptree-index"), 0644, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("bitcask-encrypted-index"))
+		k, v := bucket.Cursor().First()
+		corrupted := append([]byte{}, v...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		return bucket.Put(append([]byte{}, k...), corrupted)
+	}))
+	assert.Nil(t, db.Close())
+
+	tree, err = index.NewEncryptedBPTree(path, false, cipher)
+	assert.Nil(t, err)
+	defer tree.Close()
+
+	iter = tree.Iterator(false)
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+	assert.NotNil(t, iter.Err())
+	iter.Close()
+
+	iter = tree.Iterator(false)
+	iter.Seek([]byte("123"))
+	assert.False(t, iter.Valid())
+	assert.NotNil(t, iter.Err())
+	iter.Close()
+
+	_, err = tree.Snapshot()
+	assert.NotNil(t, err)
+}
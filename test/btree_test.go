@@ -3,6 +3,7 @@ package test
 import (
 	"Bitcask_go/data"
 	"Bitcask_go/index"
+	"Bitcask_go/util"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -111,3 +112,38 @@ func TestBTree_Iterator(t *testing.T) {
 	assert.Equal(t, it6.Key(), []byte("c"))
 	assert.NotNil(t, it6.Value())
 }
+
+func TestBTree_IteratorBatches(t *testing.T) {
+	bt := index.NewBTree(32)
+	for i := 0; i < 1000; i++ {
+		bt.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	//1.正向遍历跨越多个批次
+	it1 := bt.Iterator(false)
+	var count int
+	for it1.Rewind(); it1.Valid(); it1.Next() {
+		assert.Equal(t, util.GetTestKey(count), it1.Key())
+		count++
+	}
+	assert.Equal(t, 1000, count)
+
+	//2.反向遍历跨越多个批次
+	it2 := bt.Iterator(true)
+	count = 999
+	for it2.Seek(util.GetTestKey(999)); it2.Valid(); it2.Next() {
+		assert.Equal(t, util.GetTestKey(count), it2.Key())
+		count--
+	}
+	assert.Equal(t, -1, count)
+
+	//3.创建迭代器之后的修改不可见
+	it3 := bt.Iterator(false)
+	bt.Delete(util.GetTestKey(500))
+	bt.Put([]byte("zzz"), &data.LogRecordPos{Fid: 1, Offset: 1000})
+	count = 0
+	for it3.Seek(util.GetTestKey(400)); it3.Valid(); it3.Next() {
+		count++
+	}
+	assert.Equal(t, 600, count)
+}
@@ -0,0 +1,52 @@
+package test
+
+import (
+	"Bitcask_go/cache"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache_GetPut(t *testing.T) {
+	c := cache.NewLRUCache(1024 * 1024)
+
+	_, ok := c.Get(cache.Key{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+
+	c.Put(cache.Key{Fid: 1, Offset: 0}, []byte("a"))
+	c.Put(cache.Key{Fid: 1, Offset: 10}, []byte("b"))
+	value, ok := c.Get(cache.Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), value)
+	value, ok = c.Get(cache.Key{Fid: 1, Offset: 10})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("b"), value)
+
+	hits, misses := c.Stats()
+	assert.Equal(t, uint64(2), hits)
+	assert.Equal(t, uint64(1), misses)
+	assert.True(t, c.Size() > 2)
+}
+
+func TestLRUCache_Evict(t *testing.T) {
+	//每个分片大约可以放下10个1KB的value
+	c := cache.NewLRUCache(16 * 10 * 1200)
+
+	for i := 0; i < 10000; i++ {
+		c.Put(cache.Key{Fid: 0, Offset: int64(i)}, make([]byte, 1024))
+		//一直访问第一个key，它不会被淘汰
+		_, ok := c.Get(cache.Key{Fid: 0, Offset: 0})
+		assert.True(t, ok)
+	}
+	assert.True(t, c.Size() <= 16*10*1200)
+
+	_, ok := c.Get(cache.Key{Fid: 0, Offset: 1})
+	assert.False(t, ok)
+	_, ok = c.Get(cache.Key{Fid: 0, Offset: 9999})
+	assert.True(t, ok)
+
+	//超过分片容量的value不会被缓存
+	c.Put(cache.Key{Fid: 0, Offset: -1}, make([]byte, 20*1024))
+	_, ok = c.Get(cache.Key{Fid: 0, Offset: -1})
+	assert.False(t, ok)
+}
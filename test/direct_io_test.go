@@ -0,0 +1,155 @@
+package test
+
+import (
+	"Bitcask_go/fio"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectIO_Read(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "direct-io-a.data")
+	defer destoryFile(path)
+
+	directIO, err := fio.NewDirectIOManager(path)
+	if err != nil {
+		t.Skipf("direct io is not supported: %v", err)
+	}
+
+	value := make([]byte, 10000)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	_, err = directIO.Write(value[:3])
+	assert.Nil(t, err)
+	_, err = directIO.Write(value[3:])
+	assert.Nil(t, err)
+
+	//没有同步的数据也可以读到
+	b := make([]byte, 5)
+	n, err := directIO.Read(b, 4094)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, value[4094:4099], b)
+
+	assert.Nil(t, directIO.Sync())
+
+	//跨越多个块并且超过缓冲池大小的读取
+	large := make([]byte, 9000)
+	_, err = directIO.Read(large, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, value[1:9001], large)
+
+	//读到文件末尾
+	n, err = directIO.Read(b, int64(len(value))-2)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+	_, err = directIO.Read(b, int64(len(value))+4096)
+	assert.Equal(t, io.EOF, err)
+
+	size, err := directIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(value)), size)
+	assert.Nil(t, directIO.Close())
+}
+
+func TestIOUring_ReadBatch(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "io-uring-a.data")
+	defer destoryFile(path)
+
+	uringIO, err := fio.NewIOUringManager(path)
+	if err != nil {
+		t.Skipf("io_uring is not supported: %v", err)
+	}
+
+	_, err = uringIO.Write([]byte("hello bitcask"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 7)
+	n, err := uringIO.Read(b, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, []byte("bitcask"), b)
+
+	//超过提交队列长度的批量读取分多次提交
+	reqs := make([]fio.ReadRequest, 300)
+	for i := range reqs {
+		reqs[i] = fio.ReadRequest{Buf: make([]byte, 5), Offset: int64(i % 2 * 6)}
+	}
+	reqs[299] = fio.ReadRequest{Buf: make([]byte, 5), Offset: 10}
+	assert.Nil(t, uringIO.ReadBatch(reqs))
+	assert.Equal(t, []byte("hello"), reqs[0].Buf)
+	assert.Equal(t, []byte("bitca"), reqs[1].Buf)
+	assert.Equal(t, []byte("hello"), reqs[298].Buf)
+	assert.Nil(t, reqs[298].Err)
+
+	//最后一个请求读到文件末尾
+	assert.Equal(t, 3, reqs[299].N)
+	assert.Equal(t, io.EOF, reqs[299].Err)
+	assert.Equal(t, []byte("ask"), reqs[299].Buf[:3])
+	assert.Nil(t, uringIO.Close())
+}
+
+func TestIOUring_ReadBatchConcurrent(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "io-uring-concurrent.data")
+	defer destoryFile(path)
+
+	uringIO, err := fio.NewIOUringManager(path)
+	if err != nil {
+		t.Skipf("io_uring is not supported: %v", err)
+	}
+	defer uringIO.Close()
+
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	_, err = uringIO.Write(content)
+	assert.Nil(t, err)
+
+	//并发的批量读取各自使用一个io_uring实例，结果不会互相串
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for round := 0; round < 50; round++ {
+				reqs := make([]fio.ReadRequest, 16)
+				for i := range reqs {
+					reqs[i] = fio.ReadRequest{Buf: make([]byte, 100), Offset: int64((g*16 + i + round) * 100)}
+				}
+				assert.Nil(t, uringIO.ReadBatch(reqs))
+				for _, req := range reqs {
+					assert.Nil(t, req.Err)
+					assert.Equal(t, content[req.Offset:req.Offset+100], req.Buf)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestNewIOManager_Fallback(t *testing.T) {
+	for _, ioType := range []fio.FileIOType{fio.DirectFIO, fio.IOUringFIO} {
+		path := filepath.Join(os.TempDir(), "io-fallback-a.data")
+
+		//不支持的时候使用标准文件IO，行为和标准文件IO一致
+		ioManager, err := fio.NewIOManager(path, ioType, 0)
+		assert.Nil(t, err)
+		_, err = ioManager.Write([]byte("key-a"))
+		assert.Nil(t, err)
+
+		reqs := []fio.ReadRequest{{Buf: make([]byte, 3), Offset: 0}, {Buf: make([]byte, 3), Offset: 3}}
+		assert.Nil(t, fio.ReadBatch(ioManager, reqs))
+		assert.Equal(t, []byte("key"), reqs[0].Buf)
+		assert.Equal(t, io.EOF, reqs[1].Err)
+		assert.Equal(t, 2, reqs[1].N)
+		assert.Nil(t, ioManager.Sync())
+		assert.Nil(t, ioManager.Close())
+		destoryFile(path)
+	}
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"Bitcask_go/data"
+	"Bitcask_go/index"
+	"Bitcask_go/util"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashTable_PutGetDelete(t *testing.T) {
+	ht := index.NewHashTable()
+
+	assert.Nil(t, ht.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.Equal(t, int64(100), ht.Get(nil).Offset)
+
+	assert.Nil(t, ht.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2, Size: 10, Expire: 5}))
+	old := ht.Put([]byte("a"), &data.LogRecordPos{Fid: 3, Offset: 111})
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 2, Size: 10, Expire: 5}, old)
+	assert.Equal(t, &data.LogRecordPos{Fid: 3, Offset: 111}, ht.Get([]byte("a")))
+	assert.Nil(t, ht.Get([]byte("b")))
+	assert.Equal(t, 2, ht.Size())
+
+	//写入的key被复制，调用方修改之后不影响索引
+	key := []byte("abc")
+	ht.Put(key, &data.LogRecordPos{Fid: 4})
+	key[0] = 'x'
+	assert.NotNil(t, ht.Get([]byte("abc")))
+	assert.Nil(t, ht.Get([]byte("xbc")))
+
+	pos, ok := ht.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(3), pos.Fid)
+	pos, ok = ht.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Nil(t, pos)
+	assert.Equal(t, 2, ht.Size())
+}
+
+// 随机写入和删除，结果和map保持一致，覆盖扩容和删除时移动探测链的逻辑
+func TestHashTable_Random(t *testing.T) {
+	ht := index.NewHashTable()
+	expected := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200000; i++ {
+		key := util.GetTestKey(r.Intn(20000))
+		if r.Intn(3) == 0 {
+			_, ok := ht.Delete(key)
+			_, exists := expected[string(key)]
+			assert.Equal(t, exists, ok)
+			delete(expected, string(key))
+			continue
+		}
+		ht.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		expected[string(key)] = int64(i)
+	}
+
+	assert.Equal(t, len(expected), ht.Size())
+	for i := 0; i < 20000; i++ {
+		key := util.GetTestKey(i)
+		offset, exists := expected[string(key)]
+		pos := ht.Get(key)
+		if !exists {
+			assert.Nil(t, pos)
+			continue
+		}
+		assert.Equal(t, offset, pos.Offset)
+	}
+
+	actual := make(map[string]int64)
+	it := ht.Iterator(false)
+	for it.Rewind(); it.Valid(); it.Next() {
+		actual[string(it.Key())] = it.Value().Offset
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestHashTable_Iterator(t *testing.T) {
+	ht := index.NewHashTable()
+	assert.False(t, ht.Iterator(false).Valid())
+
+	for i := 0; i < 100; i++ {
+		ht.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	var forward, backward []string
+	it1 := ht.Iterator(false)
+	for it1.Rewind(); it1.Valid(); it1.Next() {
+		forward = append(forward, string(it1.Key()))
+	}
+	it2 := ht.Iterator(true)
+	for it2.Rewind(); it2.Valid(); it2.Next() {
+		backward = append(backward, string(it2.Key()))
+	}
+	assert.Equal(t, 100, len(forward))
+	for i := range forward {
+		assert.Equal(t, forward[i], backward[len(backward)-1-i])
+	}
+
+	//创建迭代器之后的修改不可见
+	ht.Delete(util.GetTestKey(1))
+	var count int
+	for it1.Rewind(); it1.Valid(); it1.Next() {
+		count++
+	}
+	assert.Equal(t, 100, count)
+
+	//Seek之后按key的顺序遍历
+	it1.Seek(util.GetTestKey(5))
+	for i := 5; i < 100; i++ {
+		assert.True(t, it1.Valid())
+		assert.Equal(t, util.GetTestKey(i), it1.Key())
+		it1.Next()
+	}
+	assert.False(t, it1.Valid())
+	it1.Seek([]byte("zzz"))
+	assert.False(t, it1.Valid())
+	it1.Close()
+
+	it2.Seek(util.GetTestKey(5))
+	for i := 5; i >= 0; i-- {
+		assert.True(t, it2.Valid())
+		assert.Equal(t, util.GetTestKey(i), it2.Key())
+		it2.Next()
+	}
+	assert.False(t, it2.Valid())
+	it2.Close()
+}
+
+func TestHashTable_Snapshot(t *testing.T) {
+	ht := index.NewHashTable()
+	for i := 0; i < 100; i++ {
+		ht.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	snapshot, err := ht.Snapshot()
+	assert.Nil(t, err)
+
+	ht.Put(util.GetTestKey(1), &data.LogRecordPos{Fid: 2})
+	ht.Delete(util.GetTestKey(2))
+	ht.Put(util.GetTestKey(1000), &data.LogRecordPos{Fid: 2})
+
+	assert.Equal(t, 100, snapshot.Size())
+	assert.Equal(t, uint32(1), snapshot.Get(util.GetTestKey(1)).Fid)
+	assert.NotNil(t, snapshot.Get(util.GetTestKey(2)))
+	assert.Nil(t, snapshot.Get(util.GetTestKey(1000)))
+
+	//快照中写入的key不会覆盖原索引中的key
+	snapshot.Put([]byte("snapshot-key"), &data.LogRecordPos{Fid: 3})
+	ht.Put([]byte("origin-key-00"), &data.LogRecordPos{Fid: 4})
+	assert.Nil(t, ht.Get([]byte("snapshot-key")))
+	assert.Equal(t, uint32(4), ht.Get([]byte("origin-key-00")).Fid)
+	assert.Equal(t, uint32(3), snapshot.Get([]byte("snapshot-key")).Fid)
+}
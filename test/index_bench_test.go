@@ -0,0 +1,109 @@
+package test
+
+import (
+	"Bitcask_go/data"
+	"Bitcask_go/index"
+	"Bitcask_go/util"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// 对比内存索引的性能: go test ./test -run none -bench Indexer
+var benchIndexers = []struct {
+	name   string
+	create func() index.Indexer
+}{
+	{"BTree", func() index.Indexer { return index.NewIndexer(index.Btree, "", false) }},
+	{"ART", func() index.Indexer { return index.NewIndexer(index.ART, "", false) }},
+	{"SkipList", func() index.Indexer { return index.NewIndexer(index.SkipListIndex, "", false) }},
+	{"ShardedBTree", func() index.Indexer { return index.NewShardedIndex(index.Btree, 16) }},
+	{"ShardedART", func() index.Indexer { return index.NewShardedIndex(index.ART, 16) }},
+	{"Hash", func() index.Indexer { return index.NewIndexer(index.HashIndex, "", false) }},
+}
+
+const benchKeyNum = 100000
+
+func newBenchIndexer(create func() index.Indexer) index.Indexer {
+	indexer := create()
+	for i := 0; i < benchKeyNum; i++ {
+		indexer.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	return indexer
+}
+
+func BenchmarkIndexer_Put(b *testing.B) {
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := bi.create()
+			pos := &data.LogRecordPos{Fid: 1}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				indexer.Put(util.GetTestKey(rand.Intn(benchKeyNum)), pos)
+			}
+		})
+	}
+}
+
+func BenchmarkIndexer_Get(b *testing.B) {
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := newBenchIndexer(bi.create)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				indexer.Get(util.GetTestKey(rand.Intn(benchKeyNum)))
+			}
+		})
+	}
+}
+
+// 并发读取的性能
+func BenchmarkIndexer_ParallelGet(b *testing.B) {
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := newBenchIndexer(bi.create)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					indexer.Get(util.GetTestKey(r.Intn(benchKeyNum)))
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkIndexer_Iterator(b *testing.B) {
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			indexer := newBenchIndexer(bi.create)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				it := indexer.Iterator(false)
+				for it.Seek(util.GetTestKey(rand.Intn(benchKeyNum))); it.Valid(); it.Next() {
+				}
+				it.Close()
+			}
+		})
+	}
+}
+
+// 每个key占用的内存，key为24字节
+func BenchmarkIndexer_Memory(b *testing.B) {
+	for _, bi := range benchIndexers {
+		b.Run(bi.name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			indexer := newBenchIndexer(bi.create)
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/benchKeyNum, "B/key")
+			runtime.KeepAlive(indexer)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package test
+
+import (
+	"Bitcask_go/fio"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritableMMap_Allocated(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-alloc.data")
+	defer destoryFile(path)
+
+	mmapIO, err := fio.NewWritableMMapIOManager(path, 1024*1024)
+	if err != nil {
+		t.Skipf("fallocate is not supported: %v", err)
+	}
+	defer mmapIO.Close()
+
+	//预分配的空间已经在磁盘上分配，不是稀疏文件
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024*1024), stat.Size())
+	assert.True(t, stat.Sys().(*syscall.Stat_t).Blocks*512 >= 1024*1024)
+
+	//扩大映射时新的部分同样分配了空间
+	_, err = mmapIO.Write(make([]byte, 1024*1024+1))
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.True(t, stat.Size() > 1024*1024)
+	assert.True(t, stat.Sys().(*syscall.Stat_t).Blocks*512 >= stat.Size())
+}
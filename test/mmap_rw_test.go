@@ -0,0 +1,103 @@
+package test
+
+import (
+	"Bitcask_go/fio"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritableMMap_Write(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-a.data")
+	defer destoryFile(path)
+
+	mmapIO, err := fio.NewWritableMMapIOManager(path, 4096)
+	assert.Nil(t, err)
+
+	//预先分配了文件空间，但逻辑大小为0
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4096), stat.Size())
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+
+	b := make([]byte, 3)
+	_, err = mmapIO.Read(b, 0)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = mmapIO.Write([]byte("123"))
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("456"))
+	assert.Nil(t, err)
+	size, _ = mmapIO.Size()
+	assert.Equal(t, int64(6), size)
+
+	n, err := mmapIO.Read(b, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []byte("456"), b)
+
+	//不会读到逻辑大小之后的部分
+	n, err = mmapIO.Read(b, 4)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+
+	assert.Nil(t, mmapIO.Sync())
+	assert.Nil(t, mmapIO.Close())
+
+	//关闭之后文件截断到逻辑大小
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("123456"), content)
+}
+
+func TestWritableMMap_Grow(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-b.data")
+	defer destoryFile(path)
+
+	fileIO, err := fio.NewFileIOManager(path)
+	assert.Nil(t, err)
+	_, err = fileIO.Write([]byte("abc"))
+	assert.Nil(t, err)
+	assert.Nil(t, fileIO.Close())
+
+	//打开已有的文件，在末尾追加，写入超过预分配的大小时重新映射
+	mmapIO, err := fio.NewWritableMMapIOManager(path, 16)
+	assert.Nil(t, err)
+	size, _ := mmapIO.Size()
+	assert.Equal(t, int64(3), size)
+
+	value := make([]byte, 3*1024*1024)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = mmapIO.Write(value)
+		assert.Nil(t, err)
+		assert.Nil(t, mmapIO.Sync())
+	}
+	size, _ = mmapIO.Size()
+	assert.Equal(t, int64(3+2*len(value)), size)
+
+	b := make([]byte, len(value))
+	_, err = mmapIO.Read(b, 3+int64(len(value)))
+	assert.Nil(t, err)
+	assert.Equal(t, value, b)
+	assert.Nil(t, mmapIO.Close())
+
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3+2*len(value)), stat.Size())
+
+	//NewIOManager打开的可读写内存映射读取之前写入的数据
+	ioManager, err := fio.NewIOManager(path, fio.WritableMemoryMap, 0)
+	assert.Nil(t, err)
+	_, err = ioManager.Read(b[:3], 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), b[:3])
+	assert.Nil(t, ioManager.Close())
+}
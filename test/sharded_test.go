@@ -0,0 +1,93 @@
+package test
+
+import (
+	"Bitcask_go/data"
+	"Bitcask_go/index"
+	"Bitcask_go/util"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedIndex_PutGetDelete(t *testing.T) {
+	for _, tp := range []index.IndexerType{index.Btree, index.ART, index.SkipListIndex} {
+		si := index.NewShardedIndex(tp, 8)
+
+		assert.Nil(t, si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2}))
+		old := si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+		assert.Equal(t, int64(2), old.Offset)
+		assert.Equal(t, int64(3), si.Get([]byte("a")).Offset)
+		assert.Nil(t, si.Get([]byte("b")))
+
+		for i := 0; i < 1000; i++ {
+			si.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		assert.Equal(t, 1001, si.Size())
+
+		pos, ok := si.Delete([]byte("a"))
+		assert.True(t, ok)
+		assert.Equal(t, int64(3), pos.Offset)
+		_, ok = si.Delete([]byte("a"))
+		assert.False(t, ok)
+		assert.Equal(t, 1000, si.Size())
+		assert.Nil(t, si.Close())
+	}
+}
+
+func TestShardedIndex_Iterator(t *testing.T) {
+	si := index.NewShardedIndex(index.Btree, 4)
+	//1.索引为空的情况
+	assert.False(t, si.Iterator(false).Valid())
+
+	//2.多个子索引中的数据合并之后全局有序
+	for i := 0; i < 100; i++ {
+		si.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	var keys []string
+	it1 := si.Iterator(false)
+	for it1.Rewind(); it1.Valid(); it1.Next() {
+		assert.NotNil(t, it1.Value())
+		keys = append(keys, string(it1.Key()))
+	}
+	assert.Equal(t, 100, len(keys))
+	assert.IsIncreasing(t, keys)
+
+	//3.反向遍历
+	keys = keys[:0]
+	it2 := si.Iterator(true)
+	for it2.Rewind(); it2.Valid(); it2.Next() {
+		keys = append(keys, string(it2.Key()))
+	}
+	assert.Equal(t, 100, len(keys))
+	assert.IsDecreasing(t, keys)
+
+	//4.Seek
+	it3 := si.Iterator(false)
+	it3.Seek(util.GetTestKey(50))
+	assert.Equal(t, util.GetTestKey(50), it3.Key())
+	it3.Seek([]byte("zzz"))
+	assert.False(t, it3.Valid())
+
+	it4 := si.Iterator(true)
+	it4.Seek(util.GetTestKey(50))
+	assert.Equal(t, util.GetTestKey(50), it4.Key())
+	it4.Next()
+	assert.Equal(t, util.GetTestKey(49), it4.Key())
+	it4.Close()
+	assert.False(t, it4.Valid())
+}
+
+func TestShardedIndex_Snapshot(t *testing.T) {
+	si := index.NewShardedIndex(index.ART, 4)
+	for i := 0; i < 100; i++ {
+		si.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	snapshot, err := si.Snapshot()
+	assert.Nil(t, err)
+	si.Delete(util.GetTestKey(1))
+	si.Put(util.GetTestKey(1000), &data.LogRecordPos{Fid: 2})
+
+	assert.Equal(t, 100, snapshot.Size())
+	assert.NotNil(t, snapshot.Get(util.GetTestKey(1)))
+	assert.Nil(t, snapshot.Get(util.GetTestKey(1000)))
+}
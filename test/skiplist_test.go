@@ -0,0 +1,205 @@
+package test
+
+import (
+	"Bitcask_go/data"
+	"Bitcask_go/index"
+	"Bitcask_go/util"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipList_Put(t *testing.T) {
+	sl := index.NewSkipList()
+
+	res1 := sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.Nil(t, res1)
+
+	res2 := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res2)
+
+	res3 := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 3, Offset: 111})
+	assert.NotNil(t, res3)
+	assert.Equal(t, int64(2), res3.Offset)
+	assert.Equal(t, 2, sl.Size())
+}
+
+func TestSkipList_Get(t *testing.T) {
+	sl := index.NewSkipList()
+
+	sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	pos1 := sl.Get(nil)
+	assert.Equal(t, uint32(1), pos1.Fid)
+	assert.Equal(t, int64(100), pos1.Offset)
+
+	sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	pos2 := sl.Get([]byte("a"))
+	assert.Equal(t, int64(3), pos2.Offset)
+
+	assert.Nil(t, sl.Get([]byte("b")))
+}
+
+func TestSkipList_Delete(t *testing.T) {
+	sl := index.NewSkipList()
+
+	sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	pos, ok := sl.Delete(nil)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), pos.Offset)
+
+	sl.Put([]byte("aaa"), &data.LogRecordPos{Fid: 22, Offset: 33})
+	pos, ok = sl.Delete([]byte("aaa"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(22), pos.Fid)
+	assert.Nil(t, sl.Get([]byte("aaa")))
+
+	pos, ok = sl.Delete([]byte("not exist"))
+	assert.False(t, ok)
+	assert.Nil(t, pos)
+	assert.Equal(t, 0, sl.Size())
+
+	//大量数据删除一半
+	for i := 0; i < 1000; i++ {
+		sl.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	for i := 0; i < 1000; i += 2 {
+		_, ok := sl.Delete(util.GetTestKey(i))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 500, sl.Size())
+	for i := 0; i < 1000; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, sl.Get(util.GetTestKey(i)))
+		} else {
+			assert.Equal(t, int64(i), sl.Get(util.GetTestKey(i)).Offset)
+		}
+	}
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := index.NewSkipList()
+	//1.跳表为空的情况
+	assert.False(t, sl.Iterator(false).Valid())
+	assert.False(t, sl.Iterator(true).Valid())
+
+	//2.跳表有多条数据的情况-正向遍历
+	sl.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	sl.Put([]byte("e"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	var keys []string
+	it1 := sl.Iterator(false)
+	for it1.Rewind(); it1.Valid(); it1.Next() {
+		assert.NotNil(t, it1.Value())
+		keys = append(keys, string(it1.Key()))
+	}
+	assert.Equal(t, []string{"a", "c", "e"}, keys)
+
+	//3.反向遍历
+	keys = nil
+	it2 := sl.Iterator(true)
+	for it2.Rewind(); it2.Valid(); it2.Next() {
+		keys = append(keys, string(it2.Key()))
+	}
+	assert.Equal(t, []string{"e", "c", "a"}, keys)
+
+	//4.Seek
+	it3 := sl.Iterator(false)
+	it3.Seek([]byte("b"))
+	assert.Equal(t, []byte("c"), it3.Key())
+	it3.Seek([]byte("c"))
+	assert.Equal(t, []byte("c"), it3.Key())
+	it3.Seek([]byte("f"))
+	assert.False(t, it3.Valid())
+
+	//5.反向Seek
+	it4 := sl.Iterator(true)
+	it4.Seek([]byte("z"))
+	assert.Equal(t, []byte("e"), it4.Key())
+	it4.Seek([]byte("d"))
+	assert.Equal(t, []byte("c"), it4.Key())
+	it4.Seek([]byte("c"))
+	assert.Equal(t, []byte("c"), it4.Key())
+	it4.Next()
+	assert.Equal(t, []byte("a"), it4.Key())
+	it4.Seek([]byte("0"))
+	assert.False(t, it4.Valid())
+
+	//6.当前元素被删除之后仍然可以继续遍历
+	it5 := sl.Iterator(false)
+	assert.Equal(t, []byte("a"), it5.Key())
+	sl.Delete([]byte("a"))
+	it5.Next()
+	assert.Equal(t, []byte("c"), it5.Key())
+}
+
+func TestSkipList_Snapshot(t *testing.T) {
+	sl := index.NewSkipList()
+	for i := 0; i < 100; i++ {
+		sl.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	snapshot, err := sl.Snapshot()
+	assert.Nil(t, err)
+
+	sl.Put(util.GetTestKey(1), &data.LogRecordPos{Fid: 2, Offset: 1})
+	sl.Delete(util.GetTestKey(2))
+	sl.Put(util.GetTestKey(1000), &data.LogRecordPos{Fid: 2, Offset: 1000})
+
+	assert.Equal(t, 100, snapshot.Size())
+	assert.Equal(t, uint32(1), snapshot.Get(util.GetTestKey(1)).Fid)
+	assert.NotNil(t, snapshot.Get(util.GetTestKey(2)))
+	assert.Nil(t, snapshot.Get(util.GetTestKey(1000)))
+
+	var count int
+	it := snapshot.Iterator(false)
+	for it.Rewind(); it.Valid(); it.Next() {
+		count++
+	}
+	assert.Equal(t, 100, count)
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	sl := index.NewSkipList()
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 4000; i += 4 {
+				sl.Put(util.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+				if i%3 == 0 {
+					sl.Delete(util.GetTestKey(i))
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 4000; i++ {
+				if pos := sl.Get(util.GetTestKey(i)); pos != nil {
+					assert.Equal(t, int64(i), pos.Offset)
+				}
+			}
+			//并发写入时迭代器看到的key仍然是有序的
+			var prev []byte
+			it := sl.Iterator(false)
+			for it.Rewind(); it.Valid(); it.Next() {
+				assert.True(t, prev == nil || string(prev) < string(it.Key()))
+				prev = it.Key()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var expected int
+	for i := 0; i < 4000; i++ {
+		if i%3 != 0 {
+			expected++
+			assert.NotNil(t, sl.Get(util.GetTestKey(i)))
+		}
+	}
+	assert.Equal(t, expected, sl.Size())
+}
@@ -0,0 +1,391 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/data"
+	"Bitcask_go/util"
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// txnOracle 记录事务开始之后被修改过的key，用于提交时的冲突检测
+type txnOracle struct {
+	mu        sync.Mutex
+	version   uint64            //每次修改递增的版本号
+	running   map[uint64]int    //正在运行的事务，开始时的版本号 -> 事务数量
+	committed []committedWrites //按版本号递增排列的修改记录
+}
+
+// committedWrites 一次修改涉及到的key
+type committedWrites struct {
+	version uint64
+	keys    map[string]struct{}
+}
+
+func newTxnOracle() *txnOracle {
+	return &txnOracle{
+		running: make(map[uint64]int),
+	}
+}
+
+// 开始一个事务，返回当前的版本号
+func (o *txnOracle) begin() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.running[o.version]++
+	return o.version
+}
+
+// 事务结束，清理不会再被用到的修改记录
+func (o *txnOracle) done(readVersion uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.running[readVersion]--; o.running[readVersion] <= 0 {
+		delete(o.running, readVersion)
+	}
+
+	//只保留比最早的运行中事务更新的修改记录
+	var idx int
+	if len(o.running) > 0 {
+		minVersion := o.version
+		for v := range o.running {
+			if v < minVersion {
+				minVersion = v
+			}
+		}
+		idx = sort.Search(len(o.committed), func(i int) bool {
+			return o.committed[i].version > minVersion
+		})
+	} else {
+		idx = len(o.committed)
+	}
+	o.committed = append(o.committed[:0], o.committed[idx:]...)
+}
+
+// 记录一次修改并返回新的版本号，没有正在运行的事务时只需要递增版本号
+func (o *txnOracle) recordWrites(keys ...[]byte) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.version++
+	if len(o.running) == 0 {
+		return o.version
+	}
+
+	writes := committedWrites{
+		version: o.version,
+		keys:    make(map[string]struct{}, len(keys)),
+	}
+	for _, key := range keys {
+		writes.keys[string(key)] = struct{}{}
+	}
+	o.committed = append(o.committed, writes)
+	return o.version
+}
+
+// 判断事务读取过的key在事务开始之后是否被修改过
+func (o *txnOracle) hasConflict(readVersion uint64, reads map[string]struct{}) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	idx := sort.Search(len(o.committed), func(i int) bool {
+		return o.committed[i].version > readVersion
+	})
+	for _, writes := range o.committed[idx:] {
+		for key := range reads {
+			if _, ok := writes.keys[key]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Txn 乐观读写事务
+// 事务中的写入先暂存在内存中，读取时可以看到自己的写入；提交时如果读取过的key
+// 在事务开始之后被其他写入修改过，则提交失败并返回 util.ErrTxnConflict
+type Txn struct {
+	options       config.WriteBatchOptions   //配置项
+	mu            *sync.Mutex                //互斥锁，保证多个协程使用同一个事务时的线程安全
+	pendingWrites map[string]*data.LogRecord //存放待写的数据
+	reads         map[string]struct{}        //事务读取过的key
+	readVersion   uint64                     //事务开始时的版本号
+	done          bool                       //事务是否已经提交或者放弃
+	db            *DB                        //所属DB实例
+}
+
+// Begin 开始一个乐观读写事务，使用完毕后需要调用Commit或者Discard
+func (db *DB) Begin() *Txn {
+	if db.configuration.IndexerType == config.BPTree && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot use transaction, seq no file not exists")
+	}
+	return &Txn{
+		options:       config.DefaultWriteBatchOptions,
+		mu:            new(sync.Mutex),
+		pendingWrites: make(map[string]*data.LogRecord),
+		reads:         make(map[string]struct{}),
+		readVersion:   db.oracle.begin(),
+		db:            db,
+	}
+}
+
+// Get 读取数据，优先返回事务中暂存的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, util.ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return nil, util.ErrTxnDiscarded
+	}
+
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, util.ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.reads[string(key)] = struct{}{}
+	return txn.db.Get(key)
+}
+
+// Put 在事务中写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return util.ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return util.ErrTxnDiscarded
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return util.ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return util.ErrTxnDiscarded
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// Commit 提交事务，读取过的key被其他写入修改过时返回 util.ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return util.ErrTxnDiscarded
+	}
+	defer txn.finish()
+
+	//只读事务不需要冲突检测
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	if len(txn.pendingWrites) > int(txn.options.MaxBatchNum) {
+		return util.ErrExceedMaxBatchNum
+	}
+
+	//保证事务处理的串行化
+	txn.db.mutex.Lock()
+	defer txn.db.mutex.Unlock()
+
+	if txn.db.oracle.hasConflict(txn.readVersion, txn.reads) {
+		return util.ErrTxnConflict
+	}
+
+	//删除不存在的key不需要写入数据文件
+	for key, record := range txn.pendingWrites {
+		if record.Type == data.LogRecordDeleted && txn.db.index.Get(record.Key) == nil {
+			delete(txn.pendingWrites, key)
+		}
+	}
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	return txn.db.commitPendingWrites(txn.pendingWrites, txn.options.SyncWrite)
+}
+
+// Discard 放弃事务，丢弃所有暂存的写入
+func (txn *Txn) Discard() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.done {
+		return
+	}
+	txn.finish()
+}
+
+func (txn *Txn) finish() {
+	txn.done = true
+	txn.pendingWrites = nil
+	txn.db.oracle.done(txn.readVersion)
+}
+
+// TxnIterator 事务迭代器，合并了DB中的数据和事务中暂存的写入
+type TxnIterator struct {
+	txn      *Txn
+	dbIter   *Iterator
+	pending  []*data.LogRecord //按key排序的暂存写入
+	pIdx     int               //暂存写入的当前下标
+	reverse  bool
+	currKey  []byte
+	currPend *data.LogRecord //当前元素来自暂存写入时不为空
+}
+
+// NewIterator 创建事务迭代器，遍历到的key会加入到事务的读集合中
+func (txn *Txn) NewIterator(ops config.IteratorOptions) *TxnIterator {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	pending := make([]*data.LogRecord, 0, len(txn.pendingWrites))
+	for _, record := range txn.pendingWrites {
+		if len(ops.Prefix) > 0 && !bytes.HasPrefix(record.Key, ops.Prefix) {
+			continue
+		}
+		pending = append(pending, record)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if ops.Reverse {
+			return bytes.Compare(pending[i].Key, pending[j].Key) > 0
+		}
+		return bytes.Compare(pending[i].Key, pending[j].Key) < 0
+	})
+
+	return &TxnIterator{
+		txn:     txn,
+		dbIter:  txn.db.NewIterator(ops),
+		pending: pending,
+		reverse: ops.Reverse,
+	}
+}
+
+// Rewind 重置迭代器
+func (it *TxnIterator) Rewind() {
+	it.dbIter.Rewind()
+	it.pIdx = 0
+	it.skipToNext()
+}
+
+// Seek 根据key查找第一个大于(或小于)等于key的元素
+func (it *TxnIterator) Seek(key []byte) {
+	it.dbIter.Seek(key)
+	it.pIdx = sort.Search(len(it.pending), func(i int) bool {
+		if it.reverse {
+			return bytes.Compare(it.pending[i].Key, key) <= 0
+		}
+		return bytes.Compare(it.pending[i].Key, key) >= 0
+	})
+	it.skipToNext()
+}
+
+// Next 移动到下一个元素
+func (it *TxnIterator) Next() {
+	if it.currKey == nil {
+		return
+	}
+	//当前key可能同时存在于DB和暂存写入中，两边都要前进
+	if it.dbIter.Valid() && bytes.Equal(it.dbIter.Key(), it.currKey) {
+		it.dbIter.Next()
+	}
+	if it.pIdx < len(it.pending) && bytes.Equal(it.pending[it.pIdx].Key, it.currKey) {
+		it.pIdx++
+	}
+	it.skipToNext()
+}
+
+// Valid 是否有效
+func (it *TxnIterator) Valid() bool {
+	return it.currKey != nil
+}
+
+// Key 获取当前元素的key
+func (it *TxnIterator) Key() []byte {
+	return it.currKey
+}
+
+// Value 获取当前元素的值
+func (it *TxnIterator) Value() ([]byte, error) {
+	if it.currPend != nil {
+		return it.currPend.Value, nil
+	}
+	return it.dbIter.Value()
+}
+
+// Err 遍历DB时索引出现的错误
+func (it *TxnIterator) Err() error {
+	return it.dbIter.Err()
+}
+
+// Close 关闭迭代器
+func (it *TxnIterator) Close() {
+	it.dbIter.Close()
+}
+
+// 在DB迭代器和暂存写入之间选出下一个元素，跳过事务中已经删除的key
+func (it *TxnIterator) skipToNext() {
+	for {
+		it.currKey, it.currPend = nil, nil
+		dbValid := it.dbIter.Valid()
+		pendValid := it.pIdx < len(it.pending)
+		if !dbValid && !pendValid {
+			return
+		}
+
+		var usePending bool
+		if dbValid && pendValid {
+			cmp := bytes.Compare(it.pending[it.pIdx].Key, it.dbIter.Key())
+			if it.reverse {
+				cmp = -cmp
+			}
+			usePending = cmp <= 0
+			//DB中相同的key被事务覆盖
+			if cmp == 0 {
+				it.dbIter.Next()
+			}
+		} else {
+			usePending = pendValid
+		}
+
+		if usePending {
+			record := it.pending[it.pIdx]
+			if record.Type == data.LogRecordDeleted {
+				it.pIdx++
+				continue
+			}
+			it.currKey, it.currPend = record.Key, record
+			return
+		}
+
+		it.currKey = it.dbIter.Key()
+		it.txn.mu.Lock()
+		it.txn.reads[string(it.currKey)] = struct{}{}
+		it.txn.mu.Unlock()
+		return
+	}
+}
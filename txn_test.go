@@ -0,0 +1,189 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(util.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	//读取到自己的写入
+	txn := db.Begin()
+	err = txn.Put(util.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	val, err := txn.Get(util.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	err = txn.Delete(util.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn.Get(util.GetTestKey(1))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	//提交之前其他人看不到
+	_, err = db.Get(util.GetTestKey(2))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Equal(t, util.ErrTxnDiscarded, err)
+
+	val, err = db.Get(util.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = db.Get(util.GetTestKey(1))
+	assert.Equal(t, util.ErrKeyNotFound, err)
+
+	//重启之后事务写入的数据依然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get(util.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-conflict")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(util.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	txn1 := db.Begin()
+	txn2 := db.Begin()
+
+	_, err = txn1.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(util.GetTestKey(1), []byte("txn1"))
+	assert.Nil(t, err)
+
+	_, err = txn2.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn2.Put(util.GetTestKey(1), []byte("txn2"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, txn1.Commit())
+	assert.Equal(t, util.ErrTxnConflict, txn2.Commit())
+
+	//非事务写入同样会造成冲突
+	txn3 := db.Begin()
+	_, err = txn3.Get(util.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Put(util.GetTestKey(1), []byte("put"))
+	assert.Nil(t, err)
+	err = txn3.Put(util.GetTestKey(3), []byte("txn3"))
+	assert.Nil(t, err)
+	assert.Equal(t, util.ErrTxnConflict, txn3.Commit())
+
+	//只写不读的事务不会冲突
+	txn4 := db.Begin()
+	err = db.Put(util.GetTestKey(1), []byte("put"))
+	assert.Nil(t, err)
+	err = txn4.Put(util.GetTestKey(1), []byte("txn4"))
+	assert.Nil(t, err)
+	assert.Nil(t, txn4.Commit())
+
+	assert.Equal(t, 0, len(db.oracle.committed))
+}
+
+func TestDB_Txn_Counter(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-counter")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := []byte("counter")
+	err = db.Put(key, []byte("0"))
+	assert.Nil(t, err)
+
+	//并发递增计数器，冲突时重试
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				for {
+					txn := db.Begin()
+					val, err := txn.Get(key)
+					assert.Nil(t, err)
+					n, _ := strconv.Atoi(string(val))
+					_ = txn.Put(key, []byte(strconv.Itoa(n+1)))
+					if err := txn.Commit(); err == nil {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), val)
+}
+
+func TestDB_Txn_Iterator(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-iterator")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for _, k := range []string{"a", "c", "e"} {
+		assert.Nil(t, db.Put([]byte(k), []byte("db")))
+	}
+
+	txn := db.Begin()
+	assert.Nil(t, txn.Put([]byte("b"), []byte("txn")))
+	assert.Nil(t, txn.Put([]byte("c"), []byte("txn")))
+	assert.Nil(t, txn.Delete([]byte("e")))
+
+	var keys, values []string
+	iter := txn.NewIterator(config.DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		keys = append(keys, string(iter.Key()))
+		values = append(values, string(val))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+	assert.Equal(t, []string{"db", "txn", "txn"}, values)
+
+	keys = nil
+	iter = txn.NewIterator(config.IteratorOptions{Reverse: true})
+	for iter.Seek([]byte("d")); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"c", "b", "a"}, keys)
+
+	txn.Discard()
+}
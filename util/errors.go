@@ -18,4 +18,23 @@ var (
 	ErrDataMergeRatioInvlid   = errors.New("Invlid merge ratio, must between 0 and 1.")
 	ErrMergeRatioUnreached    = errors.New("The merge ratio do not reach the ratio")
 	ErrNoEnoughSpaceForMerge  = errors.New("No enough disk space for merge operation")
+	ErrInvalidTTL             = errors.New("The ttl must not be negative.")
+	ErrTxnConflict            = errors.New("Transaction conflict, the keys it read were modified by others.")
+	ErrTxnDiscarded           = errors.New("The transaction has already been committed or discarded.")
+	ErrValueNotInteger        = errors.New("The value is not an integer or out of range.")
+	ErrReplicationClosed      = errors.New("The replication has already been closed.")
+	ErrFrameTooLarge          = errors.New("The replication frame is larger than a data file.")
+	ErrRestoreDirNotEmpty     = errors.New("The restore target directory is not empty.")
+	ErrUnknownCompression     = errors.New("Unknown compression type, the compressor maybe not registered.")
+	ErrWrongEncryptionKey     = errors.New("Failed to decrypt the data, the encryption key is wrong.")
+	ErrEncryptionKeyRequired  = errors.New("The data is encrypted, but no key provider is configured.")
+	ErrEncryptionKeyNotFound  = errors.New("The encryption key with the given id is not found.")
+	ErrDataFileCorrupted      = errors.New("The data file is corrupted.")
+	ErrRepairDirNotEmpty      = errors.New("The repair target directory is not empty.")
+	ErrIndexShardUnsupported  = errors.New("The bptree index can not be sharded, all keys are stored in one file.")
+	ErrUnknownFileIOType      = errors.New("Unknown file io type.")
+	ErrMMapWriteUnsupported   = errors.New("The writable mmap is not supported by the bptree index, it can not find the end of a preallocated data file.")
+	ErrUnknownSyncPolicy      = errors.New("Unknown sync policy.")
+	ErrSyncIntervalInvalid    = errors.New("The sync interval must be greater than 0.")
+	ErrBytesPerSyncInvalid    = errors.New("The bytes per sync must be greater than 0.")
 )
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -36,6 +37,19 @@ func AvailableDiskSize() (uint64, error) {
 	return stat.Bavail * uint64(stat.Bsize), nil
 }
 
+// SyncDir 把目录同步到磁盘，保证目录中新创建、重命名和删除的文件在崩溃之后仍然有效
+func SyncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
 // 拷贝数据目录，做备份
 func CopyDir(src, dest string, exclude []string) error {
 	//如果目标文件夹不存在，创建
@@ -73,3 +87,35 @@ func CopyDir(src, dest string, exclude []string) error {
 		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
 	})
 }
+
+// CopyFile 拷贝文件的前size个字节，先写入临时文件再重命名，不会留下不完整的目标文件
+func CopyFile(src, dest string, size int64) (err error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	tmpName := dest + ".tmp"
+	destFile, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = destFile.Close()
+			_ = os.Remove(tmpName)
+		}
+	}()
+
+	if _, err = io.CopyN(destFile, srcFile, size); err != nil {
+		return err
+	}
+	if err = destFile.Sync(); err != nil {
+		return err
+	}
+	if err = destFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, dest)
+}
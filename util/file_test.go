@@ -1,6 +1,7 @@
 package util
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -20,3 +21,36 @@ func TestAvailableDiskSize(t *testing.T) {
 
 	t.Log(size / 1024 / 1024 / 1024) //GB
 }
+
+func TestSyncDir(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sync-dir")
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644))
+	assert.Nil(t, SyncDir(dir))
+	assert.NotNil(t, SyncDir(filepath.Join(dir, "not-exist")))
+}
+
+func TestCopyFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-copy-file")
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	err := os.WriteFile(src, []byte("bitcask-go"), 0644)
+	assert.Nil(t, err)
+
+	//只拷贝前面一部分
+	dest := filepath.Join(dir, "dest")
+	err = CopyFile(src, dest, 7)
+	assert.Nil(t, err)
+	data, err := os.ReadFile(dest)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), data)
+
+	//超过文件大小
+	err = CopyFile(src, dest, 100)
+	assert.NotNil(t, err)
+	data, err = os.ReadFile(dest)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), data)
+}
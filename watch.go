@@ -0,0 +1,143 @@
+package Bitcask_go
+
+import (
+	"bytes"
+	"sync"
+)
+
+type EventType = byte
+
+const (
+	EventPut      EventType = iota + 1 //写入了一条数据
+	EventDelete                        //删除了一条数据
+	EventOverflow                      //订阅者消费太慢，缓冲区满了之后的事件被丢弃了
+)
+
+// Event 数据变更事件
+type Event struct {
+	Type  EventType
+	Key   []byte
+	Value []byte //删除事件和溢出事件的Value为空
+	SeqNo uint64 //DB实例内单调递增的序列号，同一个WriteBatch或事务中的修改序列号相同
+}
+
+// watcher 一个订阅者
+type watcher struct {
+	prefix     []byte
+	ch         chan Event
+	overflowed bool //是否有事件因为缓冲区满了被丢弃，还没有通知订阅者
+}
+
+// watchHub 管理所有的订阅者
+type watchHub struct {
+	mu         *sync.RWMutex
+	bufferSize int
+	nextId     uint64
+	watchers   map[uint64]*watcher
+	closed     bool
+}
+
+func newWatchHub(bufferSize int) *watchHub {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	return &watchHub{
+		mu:         new(sync.RWMutex),
+		bufferSize: bufferSize,
+		watchers:   make(map[uint64]*watcher),
+	}
+}
+
+// Watch 订阅key以prefix开头的数据变更，prefix为空表示订阅所有的key
+// 事件按照写入的顺序投递，包括WriteBatch和事务提交的修改；每个订阅者最多缓冲
+// Configuration.WatchBufferSize 个事件，缓冲区满了之后的事件会被丢弃，
+// 等到缓冲区有空闲时先投递一个 EventOverflow 事件，订阅者收到后需要自行重新同步数据
+// 调用返回的cancel函数或者关闭DB之后channel会被关闭
+func (db *DB) Watch(prefix []byte) (<-chan Event, func()) {
+	return db.watchers.add(prefix)
+}
+
+func (h *watchHub) add(prefix []byte) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w := &watcher{
+		prefix: append([]byte{}, prefix...),
+		ch:     make(chan Event, h.bufferSize),
+	}
+	if h.closed {
+		close(w.ch)
+		return w.ch, func() {}
+	}
+
+	id := h.nextId
+	h.nextId++
+	h.watchers[id] = w
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if _, ok := h.watchers[id]; ok {
+				delete(h.watchers, id)
+				close(w.ch)
+			}
+		})
+	}
+	return w.ch, cancel
+}
+
+// 把一次修改通知给所有匹配的订阅者，调用方需要持有db.mutex，保证事件的顺序和写入顺序一致
+func (h *watchHub) publish(seqNo uint64, typ EventType, key, value []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.watchers) == 0 {
+		return
+	}
+
+	var event *Event
+	for _, w := range h.watchers {
+		if !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		//key和value可能被调用方复用，需要拷贝一份
+		if event == nil {
+			event = &Event{Type: typ, Key: append([]byte{}, key...), SeqNo: seqNo}
+			if typ == EventPut {
+				event.Value = append([]byte{}, value...)
+			}
+		}
+		w.send(*event)
+	}
+}
+
+// 不会阻塞写入，缓冲区满了就丢弃事件并标记溢出
+func (w *watcher) send(event Event) {
+	if w.overflowed {
+		select {
+		case w.ch <- Event{Type: EventOverflow, SeqNo: event.SeqNo}:
+			w.overflowed = false
+		default:
+			return
+		}
+	}
+	select {
+	case w.ch <- event:
+	default:
+		w.overflowed = true
+	}
+}
+
+// 关闭所有订阅者的channel
+func (h *watchHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for id, w := range h.watchers {
+		delete(h.watchers, id)
+		close(w.ch)
+	}
+}
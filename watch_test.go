@@ -0,0 +1,122 @@
+package Bitcask_go
+
+import (
+	"Bitcask_go/config"
+	"Bitcask_go/util"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveEvent(t *testing.T, ch <-chan Event) Event {
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func TestDB_Watch(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	ch, cancel := db.Watch([]byte("user:"))
+	allCh, cancelAll := db.Watch(nil)
+	defer cancelAll()
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+	//删除不存在的key没有事件
+	assert.Nil(t, db.Delete([]byte("user:2")))
+
+	event := receiveEvent(t, ch)
+	assert.Equal(t, EventPut, event.Type)
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.Equal(t, []byte("a"), event.Value)
+	putSeqNo := event.SeqNo
+
+	event = receiveEvent(t, ch)
+	assert.Equal(t, EventDelete, event.Type)
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.Nil(t, event.Value)
+	assert.True(t, event.SeqNo > putSeqNo)
+
+	//前缀为空的订阅者能收到所有事件
+	assert.Equal(t, []byte("user:1"), receiveEvent(t, allCh).Key)
+	assert.Equal(t, []byte("order:1"), receiveEvent(t, allCh).Key)
+	assert.Equal(t, []byte("user:1"), receiveEvent(t, allCh).Key)
+
+	//批量写入的事件序列号相同
+	wb := db.NewWriteBatch(config.DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:3"), []byte("c")))
+	assert.Nil(t, wb.Put([]byte("user:4"), []byte("d")))
+	assert.Nil(t, wb.Commit())
+	e1, e2 := receiveEvent(t, ch), receiveEvent(t, ch)
+	assert.Equal(t, e1.SeqNo, e2.SeqNo)
+	assert.ElementsMatch(t, [][]byte{[]byte("user:3"), []byte("user:4")}, [][]byte{e1.Key, e2.Key})
+
+	//事务提交的修改
+	txn := db.Begin()
+	assert.Nil(t, txn.Delete([]byte("user:3")))
+	assert.Nil(t, txn.Commit())
+	event = receiveEvent(t, ch)
+	assert.Equal(t, EventDelete, event.Type)
+	assert.Equal(t, []byte("user:3"), event.Key)
+
+	//取消订阅之后channel被关闭
+	cancel()
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Nil(t, db.Put([]byte("user:5"), []byte("e")))
+}
+
+func TestDB_Watch_Overflow(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-overflow")
+	opts.DataDir = dir
+	opts.WatchBufferSize = 4
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	ch, cancel := db.Watch(nil)
+	defer cancel()
+
+	//写入不会因为订阅者消费太慢而阻塞
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(util.GetTestKey(i), []byte("v")))
+	}
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, util.GetTestKey(i), receiveEvent(t, ch).Key)
+	}
+
+	//缓冲区有空闲之后先收到溢出事件
+	assert.Nil(t, db.Put(util.GetTestKey(100), []byte("v")))
+	assert.Equal(t, EventOverflow, receiveEvent(t, ch).Type)
+	assert.Equal(t, util.GetTestKey(100), receiveEvent(t, ch).Key)
+}
+
+func TestDB_Watch_Close(t *testing.T) {
+	opts := config.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-close")
+	opts.DataDir = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	ch, cancel := db.Watch(nil)
+	assert.Nil(t, db.Close())
+	_, ok := <-ch
+	assert.False(t, ok)
+	cancel()
+	_ = os.RemoveAll(dir)
+}